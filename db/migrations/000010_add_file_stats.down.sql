@@ -0,0 +1,2 @@
+ALTER TABLE infos DROP COLUMN file_modified_at_unix;
+ALTER TABLE infos DROP COLUMN file_size;
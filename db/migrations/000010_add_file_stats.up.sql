@@ -0,0 +1,2 @@
+ALTER TABLE infos ADD COLUMN file_size INTEGER;
+ALTER TABLE infos ADD COLUMN file_modified_at_unix INTEGER;
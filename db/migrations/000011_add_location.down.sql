@@ -0,0 +1,5 @@
+DROP INDEX location_idx;
+
+ALTER TABLE infos DROP COLUMN altitude;
+ALTER TABLE infos DROP COLUMN longitude;
+ALTER TABLE infos DROP COLUMN latitude;
@@ -0,0 +1,10 @@
+ALTER TABLE infos ADD COLUMN latitude REAL;
+ALTER TABLE infos ADD COLUMN longitude REAL;
+ALTER TABLE infos ADD COLUMN altitude REAL;
+
+CREATE INDEX location_idx
+ON infos (
+  latitude,
+  longitude
+)
+WHERE latitude IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TRIGGER infos_fts_delete;
+DROP TRIGGER infos_fts_insert;
+DROP TABLE infos_fts;
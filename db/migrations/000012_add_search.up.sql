@@ -0,0 +1,24 @@
+CREATE VIRTUAL TABLE infos_fts USING fts5(
+  path,
+  filename,
+  tokenize = 'trigram'
+);
+
+INSERT INTO infos_fts(rowid, path, filename)
+SELECT infos.rowid, str, filename
+FROM infos
+JOIN prefix ON prefix.id == infos.path_prefix_id;
+
+CREATE TRIGGER infos_fts_insert AFTER INSERT ON infos
+BEGIN
+  INSERT INTO infos_fts(rowid, path, filename)
+  SELECT new.rowid, str, new.filename
+  FROM prefix
+  WHERE id == new.path_prefix_id;
+END;
+
+CREATE TRIGGER infos_fts_delete AFTER DELETE ON infos
+BEGIN
+  DELETE FROM infos_fts
+  WHERE rowid == old.rowid;
+END;
@@ -0,0 +1 @@
+ALTER TABLE infos DROP COLUMN image_hash;
@@ -0,0 +1 @@
+ALTER TABLE infos ADD COLUMN image_hash INTEGER;
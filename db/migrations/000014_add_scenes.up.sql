@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "scenes" (
+  "id" TEXT,
+  "collection_id" TEXT,
+  "config" TEXT,
+  "created_at_unix" INTEGER,
+  PRIMARY KEY ("id")
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS "collections" (
+  "id" TEXT,
+  "config" TEXT,
+  PRIMARY KEY ("id")
+);
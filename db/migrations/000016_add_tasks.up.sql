@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS "tasks" (
+  "id" TEXT,
+  "type" TEXT,
+  "collection_id" TEXT,
+  "name" TEXT,
+  "done" INTEGER,
+  "created_at_unix" INTEGER,
+  "resume_id" INTEGER,
+  PRIMARY KEY ("id")
+);
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS "users" (
+  "id" INTEGER,
+  "name" TEXT NOT NULL UNIQUE,
+  "password_hash" TEXT,
+  "admin" INTEGER,
+  "created_at_unix" INTEGER,
+  PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "sessions" (
+  "token_hash" TEXT,
+  "user_id" INTEGER,
+  "expires_at_unix" INTEGER,
+  PRIMARY KEY ("token_hash")
+);
+
+CREATE TABLE IF NOT EXISTS "tokens" (
+  "id" INTEGER,
+  "user_id" INTEGER,
+  "name" TEXT,
+  "token_hash" TEXT NOT NULL UNIQUE,
+  "created_at_unix" INTEGER,
+  PRIMARY KEY ("id")
+);
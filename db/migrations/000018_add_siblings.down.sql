@@ -0,0 +1,3 @@
+DROP INDEX siblings_idx;
+
+ALTER TABLE infos DROP COLUMN primary_filename;
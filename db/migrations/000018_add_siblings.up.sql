@@ -0,0 +1,10 @@
+-- Filename of the primary file in the same dir that this file is paired with,
+-- e.g. the JPEG of a RAW file or the photo of a Live Photo video
+ALTER TABLE infos ADD COLUMN primary_filename TEXT;
+
+CREATE INDEX siblings_idx
+ON infos (
+  path_prefix_id,
+  primary_filename
+)
+WHERE primary_filename IS NOT NULL;
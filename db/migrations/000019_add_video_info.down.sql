@@ -0,0 +1,3 @@
+ALTER TABLE infos DROP COLUMN frame_rate;
+ALTER TABLE infos DROP COLUMN video_codec;
+ALTER TABLE infos DROP COLUMN duration_ms;
@@ -0,0 +1,3 @@
+ALTER TABLE infos ADD COLUMN duration_ms INTEGER;
+ALTER TABLE infos ADD COLUMN video_codec TEXT;
+ALTER TABLE infos ADD COLUMN frame_rate REAL;
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"photofield/internal/image"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidCredentials = errors.New("invalid name or password")
+var ErrTooManyAttempts = errors.New("too many failed sign ins")
+
+const CookieName = "photofield_session"
+
+type Config struct {
+	// Require users to sign in and limit them to the collections they are
+	// allowed to read
+	Enabled bool `json:"enabled"`
+	// Number of days a sign in lasts
+	SessionDays int `json:"session_days"`
+	// Origins of other sites allowed to use the API from browsers, only the
+	// app itself can use it by default if authentication is enabled and any
+	// site can if it is not
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+type User struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+}
+
+type Token struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Only set when the token is created, as just its hash is stored
+	Token string `json:"token,omitempty"`
+}
+
+type Session struct {
+	User      User
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Auth authenticates users by their session cookie or API token. Passwords
+// are stored as bcrypt hashes, sessions and tokens as SHA-256 hashes.
+type Auth struct {
+	config Config
+	source *image.Source
+	// Compared against on sign in with unknown names, so that they take as
+	// long as ones with known names
+	dummyHash []byte
+	limiter   *limiter
+}
+
+func New(config Config, source *image.Source) *Auth {
+	if config.SessionDays <= 0 {
+		config.SessionDays = 30
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return &Auth{
+		config:    config,
+		source:    source,
+		dummyHash: dummyHash,
+		limiter:   newLimiter(),
+	}
+}
+
+func (auth *Auth) Enabled() bool {
+	return auth.config.Enabled
+}
+
+// SetUser adds the user or updates the password and admin flag of an
+// existing user with the same name
+func (auth *Auth) SetUser(name string, password string, admin bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if password == "" {
+		return errors.New("password is required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return auth.source.WriteUser(image.StoredUser{
+		Name:         name,
+		PasswordHash: string(hash),
+		Admin:        admin,
+		CreatedAt:    time.Now(),
+	})
+}
+
+// RemoveUser removes the user and signs them out everywhere
+func (auth *Auth) RemoveUser(name string) (bool, error) {
+	return auth.source.DeleteUser(name)
+}
+
+func (auth *Auth) ListUsers() ([]User, error) {
+	stored, err := auth.source.ListUsers()
+	users := make([]User, len(stored))
+	for i := range stored {
+		users[i] = newUser(stored[i])
+	}
+	return users, err
+}
+
+// SignIn checks the password of the user and starts a new session. Sign ins
+// are refused for a while after too many failed ones from the client address
+// or for the name.
+func (auth *Auth) SignIn(name string, password string, client string) (Session, error) {
+	now := time.Now()
+	keys := []string{"client:" + client, "name:" + name}
+	if !auth.limiter.Allow(now, keys...) {
+		return Session{}, ErrTooManyAttempts
+	}
+
+	stored, exists, err := auth.source.GetUser(name)
+	if err != nil {
+		return Session{}, err
+	}
+	hash := auth.dummyHash
+	if exists {
+		hash = []byte(stored.PasswordHash)
+	}
+	err = bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if !exists || err != nil {
+		auth.limiter.Fail(now, keys...)
+		return Session{}, ErrInvalidCredentials
+	}
+	auth.limiter.Reset(keys...)
+
+	if err := auth.source.DeleteExpiredSessions(now); err != nil {
+		log.Printf("unable to delete expired sessions: %s\n", err.Error())
+	}
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return Session{}, err
+	}
+	session := Session{
+		User:      newUser(stored),
+		Token:     token,
+		ExpiresAt: now.AddDate(0, 0, auth.config.SessionDays),
+	}
+	err = auth.source.WriteSession(tokenHash, stored.Id, session.ExpiresAt)
+	return session, err
+}
+
+func (auth *Auth) SignOut(token string) error {
+	return auth.source.DeleteSession(hashToken(token))
+}
+
+// Authenticate returns the user of the API token in the Authorization header
+// or the user of the session cookie
+func (auth *Auth) Authenticate(r *http.Request) (User, bool) {
+	var stored image.StoredUser
+	var exists bool
+	var err error
+	if token := bearerToken(r); token != "" {
+		stored, exists, err = auth.source.GetTokenUser(hashToken(token))
+	} else if cookie, cookieErr := r.Cookie(CookieName); cookieErr == nil && cookie.Value != "" {
+		stored, exists, err = auth.source.GetSessionUser(hashToken(cookie.Value), time.Now())
+	}
+	if err != nil {
+		log.Printf("unable to authenticate: %s\n", err.Error())
+		return User{}, false
+	}
+	if !exists {
+		return User{}, false
+	}
+	return newUser(stored), true
+}
+
+// CreateToken creates a new API token for the user. The token itself is only
+// returned here, it cannot be looked up later.
+func (auth *Auth) CreateToken(user User, name string) (Token, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return Token{}, err
+	}
+	stored := image.StoredToken{
+		UserId:    user.Id,
+		Name:      strings.TrimSpace(name),
+		TokenHash: tokenHash,
+		CreatedAt: time.Now(),
+	}
+	id, err := auth.source.WriteToken(stored)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{
+		Id:        id,
+		Name:      stored.Name,
+		CreatedAt: stored.CreatedAt,
+		Token:     token,
+	}, nil
+}
+
+func (auth *Auth) ListTokens(user User) ([]Token, error) {
+	stored, err := auth.source.ListTokens(user.Id)
+	tokens := make([]Token, len(stored))
+	for i, s := range stored {
+		tokens[i] = Token{
+			Id:        s.Id,
+			Name:      s.Name,
+			CreatedAt: s.CreatedAt,
+		}
+	}
+	return tokens, err
+}
+
+// DeleteToken revokes the API token of the user and returns false if the
+// user has no token with the id
+func (auth *Auth) DeleteToken(user User, id int64) (bool, error) {
+	return auth.source.DeleteToken(user.Id, id)
+}
+
+func newUser(stored image.StoredUser) User {
+	return User{
+		Id:    stored.Id,
+		Name:  stored.Name,
+		Admin: stored.Admin,
+	}
+}
+
+// newToken returns a random token and its hash
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+type contextKey struct{}
+
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// FromContext returns the authenticated user of the request context, which
+// is not set if authentication is disabled
+func FromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(contextKey{}).(User)
+	return user, ok
+}
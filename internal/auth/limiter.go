@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Failed sign ins are limited per client address and per name, so that
+// passwords cannot be guessed by trying many of them quickly
+const maxFailedSignIns = 10
+
+const failedSignInWindow = 15 * time.Minute
+
+// Keys without recent failures are only removed once there are this many, as
+// checking all of them on every failure would be wasteful
+const maxFailedSignInKeys = 10000
+
+type limiter struct {
+	mutex    sync.Mutex
+	failures map[string][]time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// Allow returns false if any of the keys failed too many times recently
+func (limiter *limiter) Allow(now time.Time, keys ...string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for _, key := range keys {
+		if len(limiter.recent(key, now)) >= maxFailedSignIns {
+			return false
+		}
+	}
+	return true
+}
+
+// Fail records a failed sign in for each of the keys
+func (limiter *limiter) Fail(now time.Time, keys ...string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if len(limiter.failures) >= maxFailedSignInKeys {
+		for key := range limiter.failures {
+			limiter.recent(key, now)
+		}
+	}
+	for _, key := range keys {
+		limiter.failures[key] = append(limiter.recent(key, now), now)
+	}
+}
+
+// Reset forgets the failures of the keys after a successful sign in
+func (limiter *limiter) Reset(keys ...string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for _, key := range keys {
+		delete(limiter.failures, key)
+	}
+}
+
+// recent returns the failures of the key within the window, dropping the
+// older ones
+func (limiter *limiter) recent(key string, now time.Time) []time.Time {
+	failures := limiter.failures[key]
+	start := 0
+	for start < len(failures) && now.Sub(failures[start]) > failedSignInWindow {
+		start++
+	}
+	if start == len(failures) {
+		delete(limiter.failures, key)
+		return nil
+	}
+	failures = failures[start:]
+	limiter.failures[key] = failures
+	return failures
+}
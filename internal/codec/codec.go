@@ -0,0 +1,35 @@
+package codec
+
+import "errors"
+
+var ErrUnsupported = errors.New("unsupported format")
+
+type Format string
+
+const (
+	Jpeg Format = "jpeg"
+	Png  Format = "png"
+	Webp Format = "webp"
+)
+
+func (format Format) MimeType() string {
+	return "image/" + string(format)
+}
+
+func (format Format) Supported() bool {
+	switch format {
+	case Jpeg, Png:
+		return true
+	case Webp:
+		return WebpSupported
+	default:
+		return false
+	}
+}
+
+type HeifConfig struct {
+	Width  int
+	Height int
+	// Raw EXIF data starting with the TIFF header
+	Exif []byte
+}
@@ -0,0 +1,24 @@
+//go:build !libheif
+// +build !libheif
+
+package codec
+
+import (
+	"image"
+	"io"
+)
+
+// HEIF/HEIC decoding requires building with the libheif tag
+const HeifSupported = false
+
+func DecodeHeif(reader io.Reader) (image.Image, error) {
+	return nil, ErrUnsupported
+}
+
+func DecodeHeifThumbnail(reader io.Reader) (image.Image, error) {
+	return nil, ErrUnsupported
+}
+
+func DecodeHeifConfig(reader io.Reader) (HeifConfig, error) {
+	return HeifConfig{}, ErrUnsupported
+}
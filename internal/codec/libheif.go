@@ -0,0 +1,168 @@
+//go:build libheif
+// +build libheif
+
+package codec
+
+/*
+#cgo LDFLAGS: -lheif
+#include <stdlib.h>
+#include <string.h>
+#include <libheif/heif.h>
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"unsafe"
+)
+
+const HeifSupported = true
+
+func init() {
+	C.heif_init(nil)
+}
+
+type heifFile struct {
+	ctx    *C.struct_heif_context
+	handle *C.struct_heif_image_handle
+}
+
+func openHeif(reader io.Reader) (*heifFile, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("unable to read empty heif")
+	}
+	file := &heifFile{
+		ctx: C.heif_context_alloc(),
+	}
+	// libheif copies the data, so it is not referenced after the call, as
+	// required for Go memory passed to C
+	err = heifError(C.heif_context_read_from_memory(file.ctx, unsafe.Pointer(&data[0]), C.size_t(len(data)), nil))
+	if err != nil {
+		file.close()
+		return nil, err
+	}
+	err = heifError(C.heif_context_get_primary_image_handle(file.ctx, &file.handle))
+	if err != nil {
+		file.close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (file *heifFile) close() {
+	if file.handle != nil {
+		C.heif_image_handle_release(file.handle)
+	}
+	C.heif_context_free(file.ctx)
+}
+
+func heifError(err C.struct_heif_error) error {
+	if err.code == C.heif_error_Ok {
+		return nil
+	}
+	return errors.New("heif: " + C.GoString(err.message))
+}
+
+// decodeHeifHandle decodes the image with its rotation and mirroring applied.
+// Grid images are assembled from their tiles by libheif.
+func decodeHeifHandle(handle *C.struct_heif_image_handle) (image.Image, error) {
+	var img *C.struct_heif_image
+	err := heifError(C.heif_decode_image(handle, &img, C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, nil))
+	if err != nil {
+		return nil, err
+	}
+	defer C.heif_image_release(img)
+
+	width := int(C.heif_image_get_width(img, C.heif_channel_interleaved))
+	height := int(C.heif_image_get_height(img, C.heif_channel_interleaved))
+	var cstride C.int
+	plane := C.heif_image_get_plane_readonly(img, C.heif_channel_interleaved, &cstride)
+	if plane == nil || width <= 0 || height <= 0 {
+		return nil, errors.New("unable to decode heif")
+	}
+	stride := int(cstride)
+
+	// libheif returns non-premultiplied alpha
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		row := unsafe.Pointer(uintptr(unsafe.Pointer(plane)) + uintptr(y*stride))
+		C.memcpy(unsafe.Pointer(&nrgba.Pix[y*nrgba.Stride]), row, C.size_t(width*4))
+	}
+	return nrgba, nil
+}
+
+// DecodeHeif decodes the primary image of a HEIF/HEIC file
+func DecodeHeif(reader io.Reader) (image.Image, error) {
+	file, err := openHeif(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer file.close()
+	return decodeHeifHandle(file.handle)
+}
+
+// DecodeHeifThumbnail decodes the first thumbnail embedded for the primary
+// image of a HEIF/HEIC file
+func DecodeHeifThumbnail(reader io.Reader) (image.Image, error) {
+	file, err := openHeif(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer file.close()
+
+	var id C.heif_item_id
+	if C.heif_image_handle_get_list_of_thumbnail_IDs(file.handle, &id, 1) < 1 {
+		return nil, errors.New("heif thumbnail not found")
+	}
+	var thumbnail *C.struct_heif_image_handle
+	err = heifError(C.heif_image_handle_get_thumbnail(file.handle, id, &thumbnail))
+	if err != nil {
+		return nil, err
+	}
+	defer C.heif_image_handle_release(thumbnail)
+	return decodeHeifHandle(thumbnail)
+}
+
+// DecodeHeifConfig returns the size of the primary image with its rotation
+// and mirroring applied, along with its EXIF data if there is any
+func DecodeHeifConfig(reader io.Reader) (HeifConfig, error) {
+	file, err := openHeif(reader)
+	if err != nil {
+		return HeifConfig{}, err
+	}
+	defer file.close()
+
+	config := HeifConfig{
+		Width:  int(C.heif_image_handle_get_width(file.handle)),
+		Height: int(C.heif_image_handle_get_height(file.handle)),
+	}
+
+	exifType := C.CString("Exif")
+	defer C.free(unsafe.Pointer(exifType))
+	var id C.heif_item_id
+	if C.heif_image_handle_get_list_of_metadata_block_IDs(file.handle, exifType, &id, 1) < 1 {
+		return config, nil
+	}
+	size := int(C.heif_image_handle_get_metadata_size(file.handle, id))
+	if size <= 4 {
+		return config, nil
+	}
+	data := make([]byte, size)
+	err = heifError(C.heif_image_handle_get_metadata(file.handle, id, unsafe.Pointer(&data[0])))
+	if err != nil {
+		return config, nil
+	}
+	// The block starts with the offset to the TIFF header
+	offset := 4 + int(binary.BigEndian.Uint32(data))
+	if offset < len(data) {
+		config.Exif = data[offset:]
+	}
+	return config, nil
+}
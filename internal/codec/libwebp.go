@@ -0,0 +1,52 @@
+//go:build libwebp
+// +build libwebp
+
+package codec
+
+/*
+#cgo LDFLAGS: -lwebp
+#include <stdlib.h>
+#include <webp/decode.h>
+#include <webp/encode.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+const WebpSupported = true
+
+func EncodeWebp(w io.Writer, img image.Image, quality int, lossless bool) error {
+	// libwebp expects non-premultiplied alpha
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		bounds := img.Bounds()
+		nrgba = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+	}
+	size := nrgba.Bounds().Size()
+	if size.X == 0 || size.Y == 0 {
+		return errors.New("unable to encode empty image")
+	}
+
+	var output *C.uint8_t
+	var length C.size_t
+	pix := (*C.uint8_t)(unsafe.Pointer(&nrgba.Pix[0]))
+	if lossless {
+		length = C.WebPEncodeLosslessRGBA(pix, C.int(size.X), C.int(size.Y), C.int(nrgba.Stride), &output)
+	} else {
+		length = C.WebPEncodeRGBA(pix, C.int(size.X), C.int(size.Y), C.int(nrgba.Stride), C.float(quality), &output)
+	}
+	if length == 0 {
+		return errors.New("unable to encode webp")
+	}
+	defer C.WebPFree(unsafe.Pointer(output))
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(output), C.int(length)))
+	return err
+}
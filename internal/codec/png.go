@@ -0,0 +1,15 @@
+package codec
+
+import (
+	"image"
+	"image/png"
+	"io"
+)
+
+var pngEncoder = png.Encoder{
+	CompressionLevel: png.BestSpeed,
+}
+
+func EncodePng(w io.Writer, image image.Image) error {
+	return pngEncoder.Encode(w, image)
+}
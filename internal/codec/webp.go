@@ -0,0 +1,16 @@
+//go:build !libwebp
+// +build !libwebp
+
+package codec
+
+import (
+	"image"
+	"io"
+)
+
+// WebP encoding requires building with the libwebp tag
+const WebpSupported = false
+
+func EncodeWebp(w io.Writer, image image.Image, quality int, lossless bool) error {
+	return ErrUnsupported
+}
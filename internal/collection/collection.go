@@ -1,11 +1,15 @@
 package collection
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"photofield/internal/image"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/gosimple/slug"
@@ -20,15 +24,119 @@ type Collection struct {
 	ExpandSubdirs bool       `json:"expand_subdirs"`
 	ExpandSort    string     `json:"expand_sort"`
 	Dirs          []string   `json:"dirs"`
+	Include       []string   `json:"include,omitempty"`
+	Exclude       []string   `json:"exclude,omitempty"`
+	MaxDepth      int        `json:"max_depth,omitempty"`
+	Filter        string     `json:"filter,omitempty"`
+	Users         []string   `json:"users,omitempty"`
 	IndexedAt     *time.Time `json:"indexed_at,omitempty"`
+	// Filter of the scene showing the collection, files have to match both
+	// the collection and the scene filter
+	SceneFilter string `json:"-"`
+	// Added at runtime and stored in the database instead of the
+	// configuration file
+	Stored bool `json:"-"`
 }
 
 func (collection *Collection) GenerateId() {
 	collection.Id = slug.Make(collection.Name)
 }
 
+// Validate checks that the collection has a name, valid filters and that all
+// of its dirs are existing absolute paths to directories
+func (collection *Collection) Validate() error {
+	if strings.TrimSpace(collection.Name) == "" {
+		return errors.New("name is required")
+	}
+	if slug.Make(collection.Name) == "" {
+		return errors.New("name needs to contain at least one letter or number")
+	}
+	if len(collection.Dirs) == 0 {
+		return errors.New("at least one dir is required")
+	}
+	for _, dir := range collection.Dirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("dir %s is not an absolute path", dir)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("dir %s is not accessible", dir)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("dir %s is not a directory", dir)
+		}
+	}
+	if _, err := collection.ParseFilter(); err != nil {
+		return err
+	}
+	if filter := collection.GetPathFilter(); filter != nil {
+		if err := filter.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasUser returns true if the user with the name is allowed to read the
+// collection
+func (collection *Collection) HasUser(name string) bool {
+	for _, user := range collection.Users {
+		if user == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPathFilter returns the filter of the include and exclude patterns and
+// max depth, or nil if none are set
+func (collection *Collection) GetPathFilter() *image.PathFilter {
+	if len(collection.Include) == 0 && len(collection.Exclude) == 0 && collection.MaxDepth == 0 {
+		return nil
+	}
+	return &image.PathFilter{
+		Include:  collection.Include,
+		Exclude:  collection.Exclude,
+		MaxDepth: collection.MaxDepth,
+	}
+}
+
+// Store saves the collection to the database, so that it can be loaded
+// again with LoadStored after a restart
+func (collection *Collection) Store(source *image.Source) error {
+	stored := *collection
+	stored.IndexedAt = nil
+	bytes, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return source.WriteCollection(collection.Id, string(bytes))
+}
+
+func LoadStored(source *image.Source) ([]Collection, error) {
+	configs, err := source.ListCollections()
+	if err != nil {
+		return nil, err
+	}
+	collections := make([]Collection, 0, len(configs))
+	for _, config := range configs {
+		var collection Collection
+		if err := json.Unmarshal([]byte(config), &collection); err != nil {
+			log.Printf("unable to load stored collection: %s\n", err.Error())
+			continue
+		}
+		collection.Stored = true
+		collections = append(collections, collection)
+	}
+	return collections, nil
+}
+
 func (collection *Collection) Expand() []Collection {
 	collections := make([]Collection, 0)
+	// The max depth applies within each expanded collection instead
+	excluded := image.PathFilter{
+		Exclude: collection.Exclude,
+	}
 	for _, collectionDir := range collection.Dirs {
 		dir, err := os.Open(collectionDir)
 		if err != nil {
@@ -42,11 +150,19 @@ func (collection *Collection) Expand() []Collection {
 				continue
 			}
 			name := entry.Name()
+			if !excluded.MatchDir(collectionDir, filepath.Join(collectionDir, name)) {
+				continue
+			}
 			child := Collection{
 				Name:       name,
 				Dirs:       []string{filepath.Join(collectionDir, name)},
 				Limit:      collection.Limit,
 				IndexLimit: collection.IndexLimit,
+				Include:    collection.Include,
+				Exclude:    collection.Exclude,
+				MaxDepth:   collection.MaxDepth,
+				Filter:     collection.Filter,
+				Users:      collection.Users,
 			}
 			collections = append(collections, child)
 		}
@@ -76,9 +192,48 @@ func (collection *Collection) UpdateStatus(source *image.Source) {
 }
 
 func (collection *Collection) GetInfos(source *image.Source, options image.ListOptions) <-chan image.SourcedInfo {
+	if collection.Filter != "" || collection.SceneFilter != "" {
+		query, err := collection.ParseFilter()
+		if err != nil {
+			log.Printf("collection %s filter ignored: %s\n", collection.Id, err.Error())
+		} else {
+			options.Query = &query
+		}
+	}
+	options.PathFilter = collection.GetPathFilter()
 	return source.ListInfos(collection.Dirs, options)
 }
 
+// ParseFilter parses the filter expression narrowing down the files of the
+// collection, see image.Query for the syntax. The scene filter is parsed
+// separately and has to match as well, so that it can only narrow down the
+// collection further.
+func (collection *Collection) ParseFilter() (image.Query, error) {
+	query, err := parseFilter(collection.Filter)
+	if err != nil {
+		return query, err
+	}
+	if collection.SceneFilter != "" {
+		sceneQuery, err := parseFilter(collection.SceneFilter)
+		if err != nil {
+			return query, err
+		}
+		query.And = append(query.And, sceneQuery)
+	}
+	return query, nil
+}
+
+func parseFilter(filter string) (image.Query, error) {
+	query, err := image.ParseQuery(filter)
+	if err != nil {
+		return query, err
+	}
+	if len(query.Collections) > 0 {
+		return query, fmt.Errorf("%w: collection is not supported in filters", image.ErrInvalidQuery)
+	}
+	return query, nil
+}
+
 func (collection *Collection) GetIds(source *image.Source) <-chan image.ImageId {
 	limit := 0
 	if collection.IndexLimit > 0 {
@@ -87,5 +242,13 @@ func (collection *Collection) GetIds(source *image.Source) <-chan image.ImageId
 	if collection.Limit > 0 {
 		limit = collection.Limit
 	}
-	return source.ListImageIds(collection.Dirs, limit)
+	return source.ListImageIds(collection.Dirs, limit, collection.GetPathFilter())
+}
+
+func (collection *Collection) GetIdsWithoutHash(source *image.Source) <-chan image.ImageId {
+	return source.ListImageIdsWithoutHash(collection.Dirs, collection.GetPathFilter())
+}
+
+func (collection *Collection) GetDuplicates(source *image.Source, maxDistance int) [][]image.DuplicateFile {
+	return source.ListDuplicates(collection.Dirs, maxDistance, collection.GetPathFilter())
 }
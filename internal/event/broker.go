@@ -0,0 +1,92 @@
+package event
+
+import (
+	"photofield/internal/metrics"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type Type string
+
+const (
+	// Task state or progress changed, the data is the task
+	Task Type = "task"
+	// Collection finished indexing, the data is the collection
+	Collection Type = "collection"
+	// Scene was invalidated or removed, the data is the scene change
+	Scene Type = "scene"
+)
+
+type Event struct {
+	Type Type
+	Data interface{}
+}
+
+// Events buffered per subscriber before newer events are dropped
+const subscriberBuffer = 100
+
+var dropped = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "events_dropped",
+})
+
+// Broker delivers published events to all current subscribers. Publishing
+// never blocks, events are dropped for subscribers that fall behind.
+type Broker struct {
+	mutex       sync.RWMutex
+	subscribers map[chan Event]struct{}
+	count       int64
+}
+
+func NewBroker() *Broker {
+	broker := &Broker{
+		subscribers: make(map[chan Event]struct{}),
+	}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "events_subscribers",
+	}, func() float64 {
+		return float64(atomic.LoadInt64(&broker.count))
+	})
+	return broker
+}
+
+// Subscribe returns the channel receiving the published events and the
+// function to call once the events are no longer needed
+func (broker *Broker) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, subscriberBuffer)
+	broker.mutex.Lock()
+	broker.subscribers[events] = struct{}{}
+	broker.mutex.Unlock()
+	atomic.AddInt64(&broker.count, 1)
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			broker.mutex.Lock()
+			delete(broker.subscribers, events)
+			broker.mutex.Unlock()
+			atomic.AddInt64(&broker.count, -1)
+		})
+	}
+	return events, unsubscribe
+}
+
+func (broker *Broker) Publish(t Type, data interface{}) {
+	event := Event{
+		Type: t,
+		Data: data,
+	}
+	broker.mutex.RLock()
+	defer broker.mutex.RUnlock()
+	for events := range broker.subscribers {
+		select {
+		case events <- event:
+		default:
+			dropped.Inc()
+		}
+	}
+}
@@ -1,10 +1,8 @@
 package image
 
 import (
-	"fmt"
 	"image"
 	"photofield/internal/metrics"
-	"reflect"
 	"time"
 	"unsafe"
 
@@ -93,11 +91,37 @@ func newImageCache(caches Caches) ImageCache {
 				return int64(unsafe.Sizeof(*img)) +
 					int64(cap(img.Pix))*int64(unsafe.Sizeof(img.Pix[0]))
 
+			case *image.Gray16:
+				return int64(unsafe.Sizeof(*img)) +
+					int64(cap(img.Pix))*int64(unsafe.Sizeof(img.Pix[0]))
+
+			case *image.NRGBA64:
+				return int64(unsafe.Sizeof(*img)) +
+					int64(cap(img.Pix))*int64(unsafe.Sizeof(img.Pix[0]))
+
+			case *image.RGBA64:
+				return int64(unsafe.Sizeof(*img)) +
+					int64(cap(img.Pix))*int64(unsafe.Sizeof(img.Pix[0]))
+
+			case *image.Paletted:
+				return int64(unsafe.Sizeof(*img)) +
+					int64(cap(img.Pix))*int64(unsafe.Sizeof(img.Pix[0])) +
+					int64(len(img.Palette))*int64(unsafe.Sizeof(img.Palette[0]))
+
+			case *image.NYCbCrA:
+				return int64(unsafe.Sizeof(*img)) +
+					int64(cap(img.Y))*int64(unsafe.Sizeof(img.Y[0])) +
+					int64(cap(img.Cb))*int64(unsafe.Sizeof(img.Cb[0])) +
+					int64(cap(img.Cr))*int64(unsafe.Sizeof(img.Cr[0])) +
+					int64(cap(img.A))*int64(unsafe.Sizeof(img.A[0]))
+
 			case nil:
 				return 1
 
 			default:
-				panic(fmt.Sprintf("Unable to compute cost, unsupported image format %v", reflect.TypeOf(img)))
+				// Estimate other formats as 8-bit RGBA
+				size := img.Bounds().Size()
+				return int64(size.X) * int64(size.Y) * 4
 			}
 		},
 	})
@@ -111,10 +135,7 @@ func newImageCache(caches Caches) ImageCache {
 }
 
 func (c *ImageCache) GetOrLoad(path string, thumbnail *Thumbnail, loader ImageLoader) (image.Image, Info, error) {
-	key := path
-	if thumbnail != nil {
-		key += "|thumbnail|" + thumbnail.Name
-	}
+	key := imageCacheKey(path, thumbnail)
 
 	value, found := c.cache.Get(key)
 	if found {
@@ -133,10 +154,26 @@ func (c *ImageCache) GetOrLoad(path string, thumbnail *Thumbnail, loader ImageLo
 	return image, info, err
 }
 
+func (c *ImageCache) Clear() {
+	c.cache.Clear()
+}
+
 func (c *ImageCache) Delete(path string) {
 	c.cache.Del(path)
 }
 
+func (c *ImageCache) DeleteThumbnail(path string, thumbnail *Thumbnail) {
+	c.cache.Del(imageCacheKey(path, thumbnail))
+}
+
+func imageCacheKey(path string, thumbnail *Thumbnail) string {
+	key := path
+	if thumbnail != nil {
+		key += "|thumbnail|" + thumbnail.Name
+	}
+	return key
+}
+
 func newFileExistsCache() *ristretto.Cache {
 	cache, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: 1e7,     // number of keys to track frequency of (10M).
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,6 +33,12 @@ const (
 type ListOptions struct {
 	OrderBy ListOrder
 	Limit   int
+	// Skip files paired with a primary file, e.g. RAW files of JPEGs
+	PrimaryOnly bool
+	// Only list files matching the query
+	Query *Query
+	// Only list files passing the filter in the listed dirs
+	PathFilter *PathFilter
 }
 
 type Database struct {
@@ -43,17 +51,47 @@ type Database struct {
 type InfoWriteType int32
 
 const (
-	AppendPath  InfoWriteType = iota
-	UpdateMeta  InfoWriteType = iota
-	UpdateColor InfoWriteType = iota
-	Delete      InfoWriteType = iota
-	Index       InfoWriteType = iota
+	AppendPath    InfoWriteType = iota
+	UpdateMeta    InfoWriteType = iota
+	UpdateColor   InfoWriteType = iota
+	Delete        InfoWriteType = iota
+	Index         InfoWriteType = iota
+	UpdateStat    InfoWriteType = iota
+	UpdateHash    InfoWriteType = iota
+	UpdatePrimary InfoWriteType = iota
 )
 
 type InfoWrite struct {
 	Path string
 	Type InfoWriteType
 	Info
+	Stat FileStat
+	Hash Hash
+	// Filename of the primary file, empty if not paired
+	Primary string
+}
+
+// FileStat holds the file properties used to detect changed files without
+// having to decode them again.
+type FileStat struct {
+	Size    int64
+	ModTime time.Time
+}
+
+func NewFileStat(fileInfo os.FileInfo) FileStat {
+	return FileStat{
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+	}
+}
+
+// Unknown for files indexed before the stats were stored
+func (stat FileStat) IsZero() bool {
+	return stat.Size == 0 && stat.ModTime.IsZero()
+}
+
+func (stat FileStat) Equal(other FileStat) bool {
+	return stat.Size == other.Size && stat.ModTime.Unix() == other.ModTime.Unix()
 }
 
 type InfoExistence struct {
@@ -134,7 +172,8 @@ func (source *Database) migrate(migrations embed.FS) {
 	}
 
 	version, dirty, err := m.Version()
-	if err != nil {
+	// New databases do not have a version until the first migration
+	if err != nil && err != migrate.ErrNilVersion {
 		panic(err)
 	}
 
@@ -168,7 +207,7 @@ func (source *Database) writePendingInfosSqlite() {
 	defer upsertPrefix.Finalize()
 
 	updateMeta := conn.Prep(`
-		INSERT INTO infos(path_prefix_id, filename, width, height, orientation, created_at_unix, created_at_tz_offset)
+		INSERT INTO infos(path_prefix_id, filename, width, height, orientation, created_at_unix, created_at_tz_offset, latitude, longitude, altitude, duration_ms, video_codec, frame_rate)
 		SELECT
 			id as path_prefix_id,
 			? as filename,
@@ -176,7 +215,13 @@ func (source *Database) writePendingInfosSqlite() {
 			? as height,
 			? orientation,
 			? as created_at_unix,
-			? as created_at_tz_offset
+			? as created_at_tz_offset,
+			? as latitude,
+			? as longitude,
+			? as altitude,
+			? as duration_ms,
+			? as video_codec,
+			? as frame_rate
 		FROM prefix
 		WHERE str == ?
 		ON CONFLICT(path_prefix_id, filename) DO UPDATE SET
@@ -184,7 +229,13 @@ func (source *Database) writePendingInfosSqlite() {
 			height=excluded.height,
 			orientation=excluded.orientation,
 			created_at_unix=excluded.created_at_unix,
-			created_at_tz_offset=excluded.created_at_tz_offset;`)
+			created_at_tz_offset=excluded.created_at_tz_offset,
+			latitude=excluded.latitude,
+			longitude=excluded.longitude,
+			altitude=excluded.altitude,
+			duration_ms=excluded.duration_ms,
+			video_codec=excluded.video_codec,
+			frame_rate=excluded.frame_rate;`)
 	defer updateMeta.Finalize()
 
 	updateColor := conn.Prep(`
@@ -199,6 +250,41 @@ func (source *Database) writePendingInfosSqlite() {
 			color=excluded.color;`)
 	defer updateColor.Finalize()
 
+	updateStat := conn.Prep(`
+		INSERT INTO infos(path_prefix_id, filename, file_size, file_modified_at_unix)
+		SELECT
+			id as path_prefix_id,
+			? as filename,
+			? as file_size,
+			? as file_modified_at_unix
+		FROM prefix
+		WHERE str == ?
+		ON CONFLICT(path_prefix_id, filename) DO UPDATE SET
+			file_size=excluded.file_size,
+			file_modified_at_unix=excluded.file_modified_at_unix,
+			image_hash=NULL;`)
+	defer updateStat.Finalize()
+
+	updateHash := conn.Prep(`
+		UPDATE infos
+		SET image_hash = ?
+		WHERE path_prefix_id == (
+			SELECT id
+			FROM prefix
+			WHERE str == ?
+		) AND filename == ?;`)
+	defer updateHash.Finalize()
+
+	updatePrimary := conn.Prep(`
+		UPDATE infos
+		SET primary_filename = ?
+		WHERE path_prefix_id == (
+			SELECT id
+			FROM prefix
+			WHERE str == ?
+		) AND filename == ?;`)
+	defer updatePrimary.Finalize()
+
 	appendPath := conn.Prep(`
 		INSERT OR IGNORE INTO infos(path_prefix_id, filename)
 		SELECT
@@ -281,7 +367,36 @@ func (source *Database) writePendingInfosSqlite() {
 			updateMeta.BindInt64(4, (int64)(imageInfo.Orientation))
 			updateMeta.BindInt64(5, imageInfo.DateTime.Unix())
 			updateMeta.BindInt64(6, int64(timezoneOffsetSeconds/60))
-			updateMeta.BindText(7, dir)
+			location := imageInfo.Location
+			if location.Valid {
+				updateMeta.BindFloat(7, location.Latitude)
+				updateMeta.BindFloat(8, location.Longitude)
+			} else {
+				updateMeta.BindNull(7)
+				updateMeta.BindNull(8)
+			}
+			if location.Valid && location.AltitudeValid {
+				updateMeta.BindFloat(9, location.Altitude)
+			} else {
+				updateMeta.BindNull(9)
+			}
+			video := imageInfo.Video
+			if video.Duration > 0 {
+				updateMeta.BindInt64(10, video.Duration.Milliseconds())
+			} else {
+				updateMeta.BindNull(10)
+			}
+			if video.Codec != "" {
+				updateMeta.BindText(11, video.Codec)
+			} else {
+				updateMeta.BindNull(11)
+			}
+			if video.FrameRate > 0 {
+				updateMeta.BindFloat(12, video.FrameRate)
+			} else {
+				updateMeta.BindNull(12)
+			}
+			updateMeta.BindText(13, dir)
 
 			_, err := updateMeta.Step()
 			if err != nil {
@@ -309,6 +424,62 @@ func (source *Database) writePendingInfosSqlite() {
 				panic(err)
 			}
 
+		case UpdateStat:
+			dir, file := filepath.Split(imageInfo.Path)
+
+			updateStat.BindText(1, file)
+			updateStat.BindInt64(2, imageInfo.Stat.Size)
+			updateStat.BindInt64(3, imageInfo.Stat.ModTime.Unix())
+			updateStat.BindText(4, dir)
+
+			_, err := updateStat.Step()
+			if err != nil {
+				log.Printf("Unable to insert file stat for %s: %s\n", imageInfo.Path, err.Error())
+				continue
+			}
+			err = updateStat.Reset()
+			if err != nil {
+				panic(err)
+			}
+
+		case UpdateHash:
+			dir, file := filepath.Split(imageInfo.Path)
+
+			updateHash.BindInt64(1, int64(imageInfo.Hash))
+			updateHash.BindText(2, dir)
+			updateHash.BindText(3, file)
+
+			_, err := updateHash.Step()
+			if err != nil {
+				log.Printf("Unable to update image hash for %s: %s\n", imageInfo.Path, err.Error())
+				continue
+			}
+			err = updateHash.Reset()
+			if err != nil {
+				panic(err)
+			}
+
+		case UpdatePrimary:
+			dir, file := filepath.Split(imageInfo.Path)
+
+			if imageInfo.Primary == "" {
+				updatePrimary.BindNull(1)
+			} else {
+				updatePrimary.BindText(1, imageInfo.Primary)
+			}
+			updatePrimary.BindText(2, dir)
+			updatePrimary.BindText(3, file)
+
+			_, err := updatePrimary.Step()
+			if err != nil {
+				log.Printf("Unable to update primary file for %s: %s\n", imageInfo.Path, err.Error())
+				continue
+			}
+			err = updatePrimary.Reset()
+			if err != nil {
+				panic(err)
+			}
+
 		case Delete:
 			dir, file := filepath.Split(imageInfo.Path)
 
@@ -386,13 +557,36 @@ func (source *Database) GetPathFromId(id ImageId) (string, bool) {
 	return stmt.ColumnText(0), true
 }
 
+func (source *Database) GetIdFromPath(path string) (ImageId, bool) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT infos.rowid
+		FROM infos
+		JOIN prefix ON path_prefix_id == prefix.id
+		WHERE str == ? AND filename == ?;`)
+	defer stmt.Finalize()
+
+	dir, file := filepath.Split(path)
+	stmt.BindText(1, dir)
+	stmt.BindText(2, file)
+
+	exists, _ := stmt.Step()
+	if !exists {
+		return 0, false
+	}
+
+	return (ImageId)(stmt.ColumnInt64(0)), true
+}
+
 func (source *Database) Get(id ImageId) (InfoResult, bool) {
 
 	conn := source.pool.Get(nil)
 	defer source.pool.Put(conn)
 
 	stmt := conn.Prep(`
-		SELECT width, height, orientation, color, created_at
+		SELECT width, height, orientation, color, created_at, latitude, longitude, altitude, duration_ms, video_codec, frame_rate
 		FROM infos
 		WHERE rowid == ?;`)
 	defer stmt.Finalize()
@@ -419,9 +613,32 @@ func (source *Database) Get(id ImageId) (InfoResult, bool) {
 	info.DateTime, _ = time.Parse(dateFormat, stmt.ColumnText(4))
 	info.DateTimeNull = stmt.ColumnType(4) == sqlite.TypeNull
 
+	info.Location = columnLocation(stmt, 5)
+
+	info.Video.Duration = time.Duration(stmt.ColumnInt64(8)) * time.Millisecond
+	info.Video.Codec = stmt.ColumnText(9)
+	info.Video.FrameRate = stmt.ColumnFloat(10)
+
 	return info, true
 }
 
+// columnLocation reads the latitude, longitude and altitude columns starting
+// at col
+func columnLocation(stmt *sqlite.Stmt, col int) Location {
+	var location Location
+	if stmt.ColumnType(col) == sqlite.TypeNull || stmt.ColumnType(col+1) == sqlite.TypeNull {
+		return location
+	}
+	location.Latitude = stmt.ColumnFloat(col)
+	location.Longitude = stmt.ColumnFloat(col + 1)
+	location.Valid = true
+	if stmt.ColumnType(col+2) != sqlite.TypeNull {
+		location.Altitude = stmt.ColumnFloat(col + 2)
+		location.AltitudeValid = true
+	}
+	return location
+}
+
 func (source *Database) GetDir(dir string) (InfoResult, bool) {
 
 	conn := source.pool.Get(nil)
@@ -461,17 +678,42 @@ func (source *Database) WaitForCommit() {
 	defer source.transactionMutex.RUnlock()
 }
 
-func (source *Database) DeleteNonexistent(dir string, m map[string]struct{}) {
+func (source *Database) DeleteNonexistent(dir string, m map[string]struct{}, filter *PathFilter) {
 	source.WaitForCommit()
 	// TODO delete prefixes
 	for path := range source.ListPaths([]string{dir}, 0) {
 		_, exists := m[path]
-		if !exists {
+		// Files filtered out were not walked, so they might still exist
+		if !exists && filter.Match(dir, path) {
 			source.Write(path, Info{}, Delete)
 		}
 	}
 }
 
+func (source *Database) WriteStat(path string, stat FileStat) {
+	source.pending <- &InfoWrite{
+		Path: path,
+		Type: UpdateStat,
+		Stat: stat,
+	}
+}
+
+func (source *Database) WriteHash(path string, hash Hash) {
+	source.pending <- &InfoWrite{
+		Path: path,
+		Type: UpdateHash,
+		Hash: hash,
+	}
+}
+
+func (source *Database) WritePrimary(path string, primary string) {
+	source.pending <- &InfoWrite{
+		Path:    path,
+		Type:    UpdatePrimary,
+		Primary: primary,
+	}
+}
+
 func (source *Database) SetIndexed(dir string) {
 	source.Write(dir, Info{
 		DateTime: time.Now(),
@@ -487,8 +729,28 @@ func (source *Database) List(dirs []string, options ListOptions) <-chan InfoList
 		defer source.pool.Put(conn)
 
 		sql := `
-			SELECT rowid, width, height, orientation, color, created_at_unix, created_at_tz_offset
+			SELECT infos.rowid, width, height, orientation, color, created_at_unix, created_at_tz_offset, latitude, longitude, altitude
+		`
+
+		// Paths are only needed to apply the path filter, which also means
+		// that the limit can only be applied after filtering
+		filter := options.PathFilter
+		if filter != nil {
+			sql += `, prefix.str || filename, prefix.str || primary_filename
+			`
+		}
+
+		sql += `
 			FROM infos
+		`
+
+		var queryArgs []interface{}
+		if filter != nil || (options.Query != nil && options.Query.needsPrefix()) {
+			sql += `JOIN prefix ON path_prefix_id == prefix.id
+			`
+		}
+
+		sql += `
 			WHERE path_prefix_id IN (
 				SELECT id
 				FROM prefix
@@ -506,6 +768,20 @@ func (source *Database) List(dirs []string, options ListOptions) <-chan InfoList
 			)
 		`
 
+		// Paired files are shown if their primary file is filtered out, so
+		// that they do not disappear along with it
+		if options.PrimaryOnly && filter == nil {
+			sql += `AND primary_filename IS NULL
+			`
+		}
+
+		if options.Query != nil {
+			var where string
+			where, queryArgs = options.Query.where()
+			sql += `AND ` + where + `
+			`
+		}
+
 		switch options.OrderBy {
 		case DateAsc:
 			sql += `ORDER BY created_at_unix ASC `
@@ -515,7 +791,7 @@ func (source *Database) List(dirs []string, options ListOptions) <-chan InfoList
 			panic("Unsupported listing order")
 		}
 
-		if options.Limit > 0 {
+		if options.Limit > 0 && filter == nil {
 			sql += `LIMIT ? `
 		}
 
@@ -530,16 +806,32 @@ func (source *Database) List(dirs []string, options ListOptions) <-chan InfoList
 			bindIndex++
 		}
 
-		if options.Limit > 0 {
+		bindIndex = bindArgs(stmt, bindIndex, queryArgs)
+
+		if options.Limit > 0 && filter == nil {
 			stmt.BindInt64(bindIndex, (int64)(options.Limit))
 		}
 
+		count := 0
 		for {
 			if exists, err := stmt.Step(); err != nil {
 				log.Printf("Error listing files: %s\n", err.Error())
 			} else if !exists {
 				break
 			}
+			if filter != nil {
+				if !filter.MatchAny(dirs, stmt.ColumnText(10)) {
+					continue
+				}
+				if options.PrimaryOnly && stmt.ColumnType(11) != sqlite.TypeNull &&
+					filter.MatchAny(dirs, stmt.ColumnText(11)) {
+					continue
+				}
+				if options.Limit > 0 && count >= options.Limit {
+					break
+				}
+			}
+			count++
 			var info InfoListResult
 			info.Id = (ImageId)(stmt.ColumnInt64(0))
 
@@ -559,6 +851,8 @@ func (source *Database) List(dirs []string, options ListOptions) <-chan InfoList
 			info.DateTime = time.Unix(unix, 0).In(time.FixedZone("tz_offset", timezoneOffset*60))
 			info.DateTimeNull = stmt.ColumnType(5) == sqlite.TypeNull
 
+			info.Location = columnLocation(stmt, 7)
+
 			out <- info
 		}
 
@@ -629,7 +923,292 @@ func (source *Database) ListPaths(dirs []string, limit int) <-chan string {
 	return out
 }
 
-func (source *Database) ListIds(dirs []string, limit int) <-chan ImageId {
+// bindArgs binds the args in order starting at index and returns the index
+// following the last bound arg
+func bindArgs(stmt *sqlite.Stmt, index int, args []interface{}) int {
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			stmt.BindText(index, v)
+		case int64:
+			stmt.BindInt64(index, v)
+		default:
+			panic(fmt.Sprintf("unsupported argument type %T", arg))
+		}
+		index++
+	}
+	return index
+}
+
+type SearchResult struct {
+	Id   ImageId
+	Path string
+}
+
+// Search returns the files matching the query, most recent first
+func (source *Database) Search(query Query, limit int) ([]SearchResult, error) {
+	defer metrics.Elapsed("search sqlite")()
+
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	where, args := query.where()
+
+	sql := `
+		SELECT infos.rowid, str || filename as path
+		FROM infos
+		JOIN prefix ON path_prefix_id == prefix.id
+		WHERE ` + where + `
+		ORDER BY created_at_unix DESC
+	`
+
+	if limit > 0 {
+		sql += `LIMIT ? `
+		args = append(args, int64(limit))
+	}
+
+	sql += ";"
+
+	stmt, err := conn.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Finalize()
+
+	bindArgs(stmt, 1, args)
+
+	results := make([]SearchResult, 0)
+	for {
+		exists, err := stmt.Step()
+		if err != nil {
+			return results, err
+		}
+		if !exists {
+			break
+		}
+		results = append(results, SearchResult{
+			Id:   (ImageId)(stmt.ColumnInt64(0)),
+			Path: stmt.ColumnText(1),
+		})
+	}
+	return results, nil
+}
+
+// dirPattern returns the LIKE pattern matching the prefixes of the dir and
+// its subdirs, but not of other dirs starting with the same name
+func dirPattern(dir string) string {
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return dir + "%"
+}
+
+// ListStats returns the stored file stats of all files in the dirs keyed by
+// path, with a zero FileStat for files that do not have them stored yet.
+func (source *Database) ListStats(dirs []string) map[string]FileStat {
+	defer metrics.Elapsed("listing stats sqlite")()
+
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	sql := `
+		SELECT str || filename as path, file_size, file_modified_at_unix
+		FROM infos
+		JOIN prefix ON path_prefix_id == prefix.id
+		WHERE path_prefix_id IN (
+			SELECT id
+			FROM prefix
+			WHERE
+	`
+
+	for i := range dirs {
+		sql += `str LIKE ? `
+		if i < len(dirs)-1 {
+			sql += "OR "
+		}
+	}
+
+	sql += `
+		)
+	`
+
+	sql += ";"
+
+	stmt := conn.Prep(sql)
+	defer stmt.Finalize()
+
+	for i, dir := range dirs {
+		stmt.BindText(i+1, dirPattern(dir))
+	}
+
+	stats := make(map[string]FileStat)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			log.Printf("Error listing file stats: %s\n", err.Error())
+			break
+		} else if !exists {
+			break
+		}
+		var stat FileStat
+		if stmt.ColumnType(1) != sqlite.TypeNull && stmt.ColumnType(2) != sqlite.TypeNull {
+			stat.Size = stmt.ColumnInt64(1)
+			stat.ModTime = time.Unix(stmt.ColumnInt64(2), 0)
+		}
+		stats[stmt.ColumnText(0)] = stat
+	}
+	return stats
+}
+
+// ListIdStats returns the stored file stats of all files in the dirs keyed by
+// their id
+func (source *Database) ListIdStats(dirs []string) map[ImageId]FileStat {
+	defer metrics.Elapsed("listing id stats sqlite")()
+
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	sql := `
+		SELECT rowid, file_size, file_modified_at_unix
+		FROM infos
+		WHERE path_prefix_id IN (
+			SELECT id
+			FROM prefix
+			WHERE
+	`
+
+	for i := range dirs {
+		sql += `str LIKE ? `
+		if i < len(dirs)-1 {
+			sql += "OR "
+		}
+	}
+
+	sql += `
+		)
+	`
+
+	sql += ";"
+
+	stmt := conn.Prep(sql)
+	defer stmt.Finalize()
+
+	for i, dir := range dirs {
+		stmt.BindText(i+1, dirPattern(dir))
+	}
+
+	stats := make(map[ImageId]FileStat)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			log.Printf("Error listing file stats: %s\n", err.Error())
+			break
+		} else if !exists {
+			break
+		}
+		var stat FileStat
+		if stmt.ColumnType(1) != sqlite.TypeNull && stmt.ColumnType(2) != sqlite.TypeNull {
+			stat.Size = stmt.ColumnInt64(1)
+			stat.ModTime = time.Unix(stmt.ColumnInt64(2), 0)
+		}
+		stats[ImageId(stmt.ColumnInt64(0))] = stat
+	}
+	return stats
+}
+
+// ListPaired returns all files in the dirs along with their primary files
+func (source *Database) ListPaired(dirs []string) []PairedFile {
+	defer metrics.Elapsed("listing paired sqlite")()
+
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	sql := `
+		SELECT str || filename as path, primary_filename
+		FROM infos
+		JOIN prefix ON path_prefix_id == prefix.id
+		WHERE path_prefix_id IN (
+			SELECT id
+			FROM prefix
+			WHERE
+	`
+
+	for i := range dirs {
+		sql += `str LIKE ? `
+		if i < len(dirs)-1 {
+			sql += "OR "
+		}
+	}
+
+	sql += `
+		)
+	`
+
+	sql += ";"
+
+	stmt := conn.Prep(sql)
+	defer stmt.Finalize()
+
+	for i, dir := range dirs {
+		stmt.BindText(i+1, dirPattern(dir))
+	}
+
+	files := make([]PairedFile, 0)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			log.Printf("Error listing paired files: %s\n", err.Error())
+			break
+		} else if !exists {
+			break
+		}
+		files = append(files, PairedFile{
+			Path:    stmt.ColumnText(0),
+			Primary: stmt.ColumnText(1),
+		})
+	}
+	return files
+}
+
+// ListSiblings returns the files paired with the primary file that pass the
+// filter in the dirs
+func (source *Database) ListSiblings(id ImageId, dirs []string, filter *PathFilter) []SiblingFile {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT siblings.rowid, str || siblings.filename
+		FROM infos AS siblings
+		JOIN infos AS primaries ON
+			siblings.path_prefix_id == primaries.path_prefix_id AND
+			siblings.primary_filename == primaries.filename
+		JOIN prefix ON siblings.path_prefix_id == prefix.id
+		WHERE primaries.rowid == ?
+		ORDER BY siblings.filename;`)
+	defer stmt.Finalize()
+
+	stmt.BindInt64(1, int64(id))
+
+	siblings := make([]SiblingFile, 0)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			log.Printf("Error listing siblings: %s\n", err.Error())
+			break
+		} else if !exists {
+			break
+		}
+		path := stmt.ColumnText(1)
+		if !filter.MatchAny(dirs, path) {
+			continue
+		}
+		siblings = append(siblings, SiblingFile{
+			Id:   (ImageId)(stmt.ColumnInt64(0)),
+			Path: path,
+		})
+	}
+	return siblings
+}
+
+// ListIds returns the ids of the files in the dirs passing the filter, which
+// can be nil to list all of them, in ascending order
+func (source *Database) ListIds(dirs []string, limit int, filter *PathFilter) <-chan ImageId {
 	out := make(chan ImageId, 10000)
 	go func() {
 		defer metrics.Elapsed("listing ids sqlite")()
@@ -638,8 +1217,22 @@ func (source *Database) ListIds(dirs []string, limit int) <-chan ImageId {
 		defer source.pool.Put(conn)
 
 		sql := `
-			SELECT rowid as id
+			SELECT infos.rowid as id
+		`
+
+		// The limit can only be applied after filtering by path
+		if filter != nil {
+			sql += `, prefix.str || filename
 			FROM infos
+			JOIN prefix ON path_prefix_id == prefix.id
+			`
+		} else {
+			sql += `
+			FROM infos
+			`
+		}
+
+		sql += `
 			WHERE path_prefix_id IN (
 				SELECT id
 				FROM prefix
@@ -657,7 +1250,10 @@ func (source *Database) ListIds(dirs []string, limit int) <-chan ImageId {
 			)
 		`
 
-		if limit > 0 {
+		// Ascending, so that tasks can resume after the last processed id
+		sql += `ORDER BY infos.rowid `
+
+		if limit > 0 && filter == nil {
 			sql += `LIMIT ? `
 		}
 
@@ -672,16 +1268,26 @@ func (source *Database) ListIds(dirs []string, limit int) <-chan ImageId {
 			bindIndex++
 		}
 
-		if limit > 0 {
+		if limit > 0 && filter == nil {
 			stmt.BindInt64(bindIndex, (int64)(limit))
 		}
 
+		count := 0
 		for {
 			if exists, err := stmt.Step(); err != nil {
 				log.Printf("Error listing files: %s\n", err.Error())
 			} else if !exists {
 				break
 			}
+			if filter != nil {
+				if !filter.MatchAny(dirs, stmt.ColumnText(1)) {
+					continue
+				}
+				if limit > 0 && count >= limit {
+					break
+				}
+			}
+			count++
 			out <- (ImageId)(stmt.ColumnInt64(0))
 		}
 
@@ -689,3 +1295,600 @@ func (source *Database) ListIds(dirs []string, limit int) <-chan ImageId {
 	}()
 	return out
 }
+
+// ListHashes returns all files in the dirs passing the filter that have their
+// hash computed
+func (source *Database) ListHashes(dirs []string, filter *PathFilter) []HashedFile {
+	defer metrics.Elapsed("listing hashes sqlite")()
+
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	sql := `
+		SELECT infos.rowid, str || filename as path, image_hash
+		FROM infos
+		JOIN prefix ON path_prefix_id == prefix.id
+		WHERE image_hash IS NOT NULL AND path_prefix_id IN (
+			SELECT id
+			FROM prefix
+			WHERE
+	`
+
+	for i := range dirs {
+		sql += `str LIKE ? `
+		if i < len(dirs)-1 {
+			sql += "OR "
+		}
+	}
+
+	sql += `
+		)
+		ORDER BY path
+	`
+
+	sql += ";"
+
+	stmt := conn.Prep(sql)
+	defer stmt.Finalize()
+
+	for i, dir := range dirs {
+		stmt.BindText(i+1, dirPattern(dir))
+	}
+
+	files := make([]HashedFile, 0)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			log.Printf("Error listing hashes: %s\n", err.Error())
+			break
+		} else if !exists {
+			break
+		}
+		path := stmt.ColumnText(1)
+		if !filter.MatchAny(dirs, path) {
+			continue
+		}
+		files = append(files, HashedFile{
+			Id:   (ImageId)(stmt.ColumnInt64(0)),
+			Path: path,
+			Hash: (Hash)(stmt.ColumnInt64(2)),
+		})
+	}
+	return files
+}
+
+// ListIdsWithoutHash returns the ids of files in the dirs passing the filter
+// that do not have their hash computed yet
+func (source *Database) ListIdsWithoutHash(dirs []string, filter *PathFilter) <-chan ImageId {
+	out := make(chan ImageId, 10000)
+	go func() {
+		defer metrics.Elapsed("listing ids without hash sqlite")()
+
+		conn := source.pool.Get(nil)
+		defer source.pool.Put(conn)
+
+		sql := `
+			SELECT infos.rowid as id, str || filename as path
+			FROM infos
+			JOIN prefix ON path_prefix_id == prefix.id
+			WHERE image_hash IS NULL AND path_prefix_id IN (
+				SELECT id
+				FROM prefix
+				WHERE
+		`
+
+		for i := range dirs {
+			sql += `str LIKE ? `
+			if i < len(dirs)-1 {
+				sql += "OR "
+			}
+		}
+
+		sql += `
+			)
+		`
+
+		sql += ";"
+
+		stmt := conn.Prep(sql)
+		defer stmt.Finalize()
+
+		for i, dir := range dirs {
+			stmt.BindText(i+1, dirPattern(dir))
+		}
+
+		for {
+			if exists, err := stmt.Step(); err != nil {
+				log.Printf("Error listing files: %s\n", err.Error())
+				break
+			} else if !exists {
+				break
+			}
+			if !filter.MatchAny(dirs, stmt.ColumnText(1)) {
+				continue
+			}
+			out <- (ImageId)(stmt.ColumnInt64(0))
+		}
+
+		close(out)
+	}()
+	return out
+}
+
+type StoredScene struct {
+	Id           string
+	CollectionId string
+	Config       string
+	CreatedAt    time.Time
+}
+
+// WriteScene stores the scene config, so that the scene can be restored
+// after a restart
+func (source *Database) WriteScene(scene StoredScene) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		INSERT OR REPLACE INTO scenes(id, collection_id, config, created_at_unix)
+		VALUES (?, ?, ?, ?);`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, scene.Id)
+	stmt.BindText(2, scene.CollectionId)
+	stmt.BindText(3, scene.Config)
+	stmt.BindInt64(4, scene.CreatedAt.Unix())
+
+	_, err := stmt.Step()
+	return err
+}
+
+func (source *Database) DeleteScene(id string) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		DELETE FROM scenes
+		WHERE id == ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, id)
+
+	_, err := stmt.Step()
+	return err
+}
+
+func (source *Database) ListScenes() ([]StoredScene, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT id, collection_id, config, created_at_unix
+		FROM scenes
+		ORDER BY created_at_unix;`)
+	defer stmt.Finalize()
+
+	scenes := make([]StoredScene, 0)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			return scenes, err
+		} else if !exists {
+			break
+		}
+		scenes = append(scenes, StoredScene{
+			Id:           stmt.ColumnText(0),
+			CollectionId: stmt.ColumnText(1),
+			Config:       stmt.ColumnText(2),
+			CreatedAt:    time.Unix(stmt.ColumnInt64(3), 0),
+		})
+	}
+	return scenes, nil
+}
+
+// WriteCollection stores the encoded config of a collection added at runtime
+func (source *Database) WriteCollection(id string, config string) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		INSERT OR REPLACE INTO collections(id, config)
+		VALUES (?, ?);`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, id)
+	stmt.BindText(2, config)
+
+	_, err := stmt.Step()
+	return err
+}
+
+func (source *Database) DeleteCollection(id string) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		DELETE FROM collections
+		WHERE id == ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, id)
+
+	_, err := stmt.Step()
+	return err
+}
+
+// ListCollections returns the encoded configs of the collections added at
+// runtime
+func (source *Database) ListCollections() ([]string, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT config
+		FROM collections
+		ORDER BY rowid;`)
+	defer stmt.Finalize()
+
+	configs := make([]string, 0)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			return configs, err
+		} else if !exists {
+			break
+		}
+		configs = append(configs, stmt.ColumnText(0))
+	}
+	return configs, nil
+}
+
+// StoredTask is a task that has not finished yet, so that it can be resumed
+// after a restart
+type StoredTask struct {
+	Id           string
+	Type         string
+	CollectionId string
+	Name         string
+	Done         int
+	// The ids up to and including this one were processed, so that the task
+	// can continue after it
+	ResumeId  int64
+	CreatedAt time.Time
+}
+
+func (source *Database) WriteTask(task StoredTask) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		INSERT OR REPLACE INTO tasks(id, type, collection_id, name, done, created_at_unix, resume_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?);`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, task.Id)
+	stmt.BindText(2, task.Type)
+	stmt.BindText(3, task.CollectionId)
+	stmt.BindText(4, task.Name)
+	stmt.BindInt64(5, int64(task.Done))
+	stmt.BindInt64(6, task.CreatedAt.Unix())
+	stmt.BindInt64(7, task.ResumeId)
+
+	_, err := stmt.Step()
+	return err
+}
+
+func (source *Database) DeleteTask(id string) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		DELETE FROM tasks
+		WHERE id == ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, id)
+
+	_, err := stmt.Step()
+	return err
+}
+
+func (source *Database) ListTasks() ([]StoredTask, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT id, type, collection_id, name, done, created_at_unix, resume_id
+		FROM tasks
+		ORDER BY created_at_unix;`)
+	defer stmt.Finalize()
+
+	tasks := make([]StoredTask, 0)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			return tasks, err
+		} else if !exists {
+			break
+		}
+		tasks = append(tasks, StoredTask{
+			Id:           stmt.ColumnText(0),
+			Type:         stmt.ColumnText(1),
+			CollectionId: stmt.ColumnText(2),
+			Name:         stmt.ColumnText(3),
+			Done:         stmt.ColumnInt(4),
+			CreatedAt:    time.Unix(stmt.ColumnInt64(5), 0),
+			ResumeId:     stmt.ColumnInt64(6),
+		})
+	}
+	return tasks, nil
+}
+
+type StoredUser struct {
+	Id           int64
+	Name         string
+	PasswordHash string
+	Admin        bool
+	CreatedAt    time.Time
+}
+
+// WriteUser adds the user or updates the password and admin flag of the
+// existing user with the same name
+func (source *Database) WriteUser(user StoredUser) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		INSERT INTO users(name, password_hash, admin, created_at_unix)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			password_hash=excluded.password_hash,
+			admin=excluded.admin;`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, user.Name)
+	stmt.BindText(2, user.PasswordHash)
+	stmt.BindBool(3, user.Admin)
+	stmt.BindInt64(4, user.CreatedAt.Unix())
+
+	_, err := stmt.Step()
+	return err
+}
+
+func (source *Database) GetUser(name string) (StoredUser, bool, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+	return source.getUser(conn, name)
+}
+
+// DeleteUser removes the user along with all of their sessions and tokens
+func (source *Database) DeleteUser(name string) (bool, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	user, exists, err := source.getUser(conn, name)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM sessions WHERE user_id == ?;`,
+		`DELETE FROM tokens WHERE user_id == ?;`,
+		`DELETE FROM users WHERE id == ?;`,
+	} {
+		stmt := conn.Prep(query)
+		stmt.BindInt64(1, user.Id)
+		_, err := stmt.Step()
+		stmt.Finalize()
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (source *Database) getUser(conn *sqlite.Conn, name string) (StoredUser, bool, error) {
+	stmt := conn.Prep(`
+		SELECT id, name, password_hash, admin, created_at_unix
+		FROM users
+		WHERE name == ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, name)
+
+	return stepUser(stmt)
+}
+
+func (source *Database) ListUsers() ([]StoredUser, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT id, name, password_hash, admin, created_at_unix
+		FROM users
+		ORDER BY name;`)
+	defer stmt.Finalize()
+
+	users := make([]StoredUser, 0)
+	for {
+		user, exists, err := stepUser(stmt)
+		if err != nil {
+			return users, err
+		} else if !exists {
+			break
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// stepUser reads the next user selected as id, name, password_hash, admin
+// and created_at_unix
+func stepUser(stmt *sqlite.Stmt) (StoredUser, bool, error) {
+	if exists, err := stmt.Step(); err != nil || !exists {
+		return StoredUser{}, false, err
+	}
+	return StoredUser{
+		Id:           stmt.ColumnInt64(0),
+		Name:         stmt.ColumnText(1),
+		PasswordHash: stmt.ColumnText(2),
+		Admin:        stmt.ColumnInt(3) != 0,
+		CreatedAt:    time.Unix(stmt.ColumnInt64(4), 0),
+	}, true, nil
+}
+
+func (source *Database) WriteSession(tokenHash string, userId int64, expiresAt time.Time) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		INSERT OR REPLACE INTO sessions(token_hash, user_id, expires_at_unix)
+		VALUES (?, ?, ?);`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, tokenHash)
+	stmt.BindInt64(2, userId)
+	stmt.BindInt64(3, expiresAt.Unix())
+
+	_, err := stmt.Step()
+	return err
+}
+
+// GetSessionUser returns the user of the session, if it has not expired yet
+func (source *Database) GetSessionUser(tokenHash string, now time.Time) (StoredUser, bool, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT users.id, name, password_hash, admin, created_at_unix
+		FROM sessions
+		JOIN users ON sessions.user_id == users.id
+		WHERE token_hash == ? AND expires_at_unix > ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, tokenHash)
+	stmt.BindInt64(2, now.Unix())
+
+	return stepUser(stmt)
+}
+
+func (source *Database) DeleteSession(tokenHash string) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		DELETE FROM sessions
+		WHERE token_hash == ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, tokenHash)
+
+	_, err := stmt.Step()
+	return err
+}
+
+func (source *Database) DeleteExpiredSessions(now time.Time) error {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		DELETE FROM sessions
+		WHERE expires_at_unix <= ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindInt64(1, now.Unix())
+
+	_, err := stmt.Step()
+	return err
+}
+
+type StoredToken struct {
+	Id        int64
+	UserId    int64
+	Name      string
+	TokenHash string
+	CreatedAt time.Time
+}
+
+// WriteToken adds the API token and returns its id
+func (source *Database) WriteToken(token StoredToken) (int64, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		INSERT INTO tokens(user_id, name, token_hash, created_at_unix)
+		VALUES (?, ?, ?, ?);`)
+	defer stmt.Finalize()
+
+	stmt.BindInt64(1, token.UserId)
+	stmt.BindText(2, token.Name)
+	stmt.BindText(3, token.TokenHash)
+	stmt.BindInt64(4, token.CreatedAt.Unix())
+
+	if _, err := stmt.Step(); err != nil {
+		return 0, err
+	}
+	return conn.LastInsertRowID(), nil
+}
+
+func (source *Database) GetTokenUser(tokenHash string) (StoredUser, bool, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT users.id, users.name, password_hash, admin, users.created_at_unix
+		FROM tokens
+		JOIN users ON tokens.user_id == users.id
+		WHERE token_hash == ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindText(1, tokenHash)
+
+	return stepUser(stmt)
+}
+
+// ListTokens returns the API tokens of the user without their hashes
+func (source *Database) ListTokens(userId int64) ([]StoredToken, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		SELECT id, name, created_at_unix
+		FROM tokens
+		WHERE user_id == ?
+		ORDER BY id;`)
+	defer stmt.Finalize()
+
+	stmt.BindInt64(1, userId)
+
+	tokens := make([]StoredToken, 0)
+	for {
+		if exists, err := stmt.Step(); err != nil {
+			return tokens, err
+		} else if !exists {
+			break
+		}
+		tokens = append(tokens, StoredToken{
+			Id:        stmt.ColumnInt64(0),
+			UserId:    userId,
+			Name:      stmt.ColumnText(1),
+			CreatedAt: time.Unix(stmt.ColumnInt64(2), 0),
+		})
+	}
+	return tokens, nil
+}
+
+// DeleteToken removes the API token of the user and returns false if the user
+// has no token with the id
+func (source *Database) DeleteToken(userId int64, id int64) (bool, error) {
+	conn := source.pool.Get(nil)
+	defer source.pool.Put(conn)
+
+	stmt := conn.Prep(`
+		DELETE FROM tokens
+		WHERE user_id == ? AND id == ?;`)
+	defer stmt.Finalize()
+
+	stmt.BindInt64(1, userId)
+	stmt.BindInt64(2, id)
+
+	if _, err := stmt.Step(); err != nil {
+		return false, err
+	}
+	return conn.Changes() > 0, nil
+}
@@ -2,10 +2,14 @@ package image
 
 import (
 	"bytes"
+	"errors"
 	goimage "image"
 	"image/jpeg"
 	"io"
 	"log"
+	"math"
+	"os"
+	"photofield/internal/codec"
 	"strconv"
 	"time"
 )
@@ -18,6 +22,7 @@ type Decoder struct {
 type metadataLoader interface {
 	DecodeInfo(path string, info *Info) error
 	DecodeBytes(path string, tagName string) ([]byte, error)
+	DecodeBinarySizes(path string, tagNames []string) (map[string]int, error)
 	Close()
 }
 
@@ -71,6 +76,11 @@ func parseDateTime(value string) (t time.Time, hasTimezone bool, hasSubsec bool,
 
 func (decoder *Decoder) DecodeInfo(path string, info *Info) error {
 	err := decoder.loader.DecodeInfo(path, info)
+	if isHeif(path) {
+		// Rotation and mirroring are already applied when decoding, so the
+		// EXIF orientation must not be applied again
+		info.Orientation = Normal
+	}
 	// println(path, info.Width, info.Height, info.DateTime.String())
 	// if info.Width != 0 {
 	// 	println(path, info.String())
@@ -79,6 +89,9 @@ func (decoder *Decoder) DecodeInfo(path string, info *Info) error {
 }
 
 func (decoder *Decoder) DecodeImage(path string, tagName string) (goimage.Image, Info, error) {
+	if isHeif(path) {
+		return decoder.decodeHeifThumbnail(path)
+	}
 	imageBytes, err := decoder.loader.DecodeBytes(path, tagName)
 	if err != nil {
 		return nil, Info{}, err
@@ -92,6 +105,60 @@ func (decoder *Decoder) DecodeImage(path string, tagName string) (goimage.Image,
 	return img, info, err
 }
 
+// decodeHeifThumbnail decodes the thumbnail embedded in HEIF files instead
+// of an EXIF thumbnail, which they usually do not have
+func (decoder *Decoder) decodeHeifThumbnail(path string) (goimage.Image, Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	defer file.Close()
+	img, err := codec.DecodeHeifThumbnail(file)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	size := img.Bounds().Size()
+	info := Info{
+		Width:       size.X,
+		Height:      size.Y,
+		Orientation: Normal,
+	}
+	return img, info, nil
+}
+
+// Tags of JPEG previews embedded in camera RAW files, usually ordered from
+// the largest to the smallest
+var rawPreviewTags = []string{"JpgFromRaw", "PreviewImage", "OtherImage"}
+
+var errRawPreviewNotFound = errors.New("raw preview not found")
+
+// DecodeRawPreview decodes the largest JPEG preview embedded in the camera
+// RAW file, as the RAW data itself is not decoded. Only the sizes of the
+// previews are read at first, so that just the largest one is extracted.
+func (decoder *Decoder) DecodeRawPreview(path string) (goimage.Image, error) {
+	sizes, err := decoder.loader.DecodeBinarySizes(path, rawPreviewTags)
+	if err != nil {
+		return nil, err
+	}
+	largest := ""
+	for _, tag := range rawPreviewTags {
+		if sizes[tag] > sizes[largest] {
+			largest = tag
+		}
+	}
+	if largest == "" {
+		return nil, errRawPreviewNotFound
+	}
+	preview, err := decoder.loader.DecodeBytes(path, largest)
+	if err != nil {
+		return nil, err
+	}
+	if len(preview) == 0 {
+		return nil, errRawPreviewNotFound
+	}
+	return codec.DecodeJpeg(bytes.NewReader(preview))
+}
+
 func parseOrientation(orientation string) Orientation {
 	n, err := strconv.Atoi(orientation)
 	if err != nil || n < 1 || n > 8 {
@@ -100,6 +167,46 @@ func parseOrientation(orientation string) Orientation {
 	return Orientation(n)
 }
 
+// parseLocation parses machine-readable signed decimal degrees and meters,
+// ignoring coordinates out of range and the 0, 0 reported by some devices
+// without a fix.
+func parseLocation(latitude string, longitude string, altitude string) Location {
+	var location Location
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil {
+		return location
+	}
+	lon, err := strconv.ParseFloat(longitude, 64)
+	if err != nil {
+		return location
+	}
+	if !isValidLatLon(lat, lon) {
+		return location
+	}
+	location.Latitude = lat
+	location.Longitude = lon
+	location.Valid = true
+
+	if altitude != "" {
+		alt, err := strconv.ParseFloat(altitude, 64)
+		if err == nil && !math.IsNaN(alt) && !math.IsInf(alt, 0) {
+			location.Altitude = alt
+			location.AltitudeValid = true
+		}
+	}
+	return location
+}
+
+func isValidLatLon(lat float64, lon float64) bool {
+	if math.IsNaN(lat) || math.IsNaN(lon) {
+		return false
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return false
+	}
+	return lat != 0 || lon != 0
+}
+
 func getOrientationFromRotation(rotation string) Orientation {
 	switch rotation {
 	case "0":
@@ -42,6 +42,8 @@ func (decoder *ExifToolMostlyGeekLoader) DecodeInfo(path string, info *Info) err
 		"-Rotation",
 		"-ImageWidth",
 		"-ImageHeight",
+		// Composite size of the developed image, used for RAW files
+		"-ImageSize",
 		// First available will be used
 		"-SubSecDateTimeOriginal",
 		"-DateTimeOriginal",
@@ -54,6 +56,11 @@ func (decoder *ExifToolMostlyGeekLoader) DecodeInfo(path string, info *Info) err
 		"-TimeStamp",
 		"-FileModifyDate",
 		"-FileCreateDate",
+		"-GPSLatitude",
+		"-GPSLongitude",
+		"-GPSAltitude",
+		// QuickTime videos
+		"-GPSCoordinates",
 	)
 	if err != nil {
 		return err
@@ -63,6 +70,11 @@ func (decoder *ExifToolMostlyGeekLoader) DecodeInfo(path string, info *Info) err
 	rotation := ""
 	imageWidth := ""
 	imageHeight := ""
+	imageSize := ""
+	gpsLatitude := ""
+	gpsLongitude := ""
+	gpsAltitude := ""
+	gpsCoordinates := ""
 
 	// var gpsTime time.Time
 
@@ -86,6 +98,16 @@ func (decoder *ExifToolMostlyGeekLoader) DecodeInfo(path string, info *Info) err
 			imageWidth = value
 		case "ImageHeight":
 			imageHeight = value
+		case "ImageSize":
+			imageSize = value
+		case "GPSLatitude":
+			gpsLatitude = value
+		case "GPSLongitude":
+			gpsLongitude = value
+		case "GPSAltitude":
+			gpsAltitude = value
+		case "GPSCoordinates":
+			gpsCoordinates = value
 		// case "GPSDateTime":
 		// 	gpsTime, _ = parseDateTime(value)
 		default:
@@ -124,6 +146,15 @@ func (decoder *ExifToolMostlyGeekLoader) DecodeInfo(path string, info *Info) err
 		}
 	}
 
+	// The width and height of RAW files can belong to one of the embedded
+	// previews or the uncropped sensor data instead
+	if isRaw(path) && imageSize != "" {
+		width, height, ok := parseImageSize(imageSize)
+		if ok {
+			info.Width, info.Height = width, height
+		}
+	}
+
 	if orientation != "" {
 		info.Orientation = parseOrientation(orientation)
 	} else if rotation != "" {
@@ -134,23 +165,88 @@ func (decoder *ExifToolMostlyGeekLoader) DecodeInfo(path string, info *Info) err
 		info.Width, info.Height = info.Height, info.Width
 	}
 
+	if gpsLatitude != "" && gpsLongitude != "" {
+		info.Location = parseLocation(gpsLatitude, gpsLongitude, gpsAltitude)
+	} else if gpsCoordinates != "" {
+		// Space-separated latitude, longitude and optional altitude
+		coords := strings.Fields(strings.ReplaceAll(gpsCoordinates, ",", " "))
+		if len(coords) >= 3 {
+			info.Location = parseLocation(coords[0], coords[1], coords[2])
+		} else if len(coords) == 2 {
+			info.Location = parseLocation(coords[0], coords[1], "")
+		}
+	}
+
 	// println(path, info.Width, info.Height, info.DateTime.String())
 
 	return nil
 }
 
+// parseImageSize parses the machine-readable "W H" or human-readable "WxH"
+// image size
+func parseImageSize(value string) (int, int, bool) {
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return r == 'x' || r == ' '
+	})
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	height, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
 func (decoder *ExifToolMostlyGeekLoader) DecodeBytes(path string, tagName string) ([]byte, error) {
 
 	bytes, err := decoder.exifTool.ExtractFlags(path, "-b", "-"+tagName)
 
 	if err != nil {
-		println(path, tagName, err.Error())
 		return nil, err
 	}
 
 	return bytes, nil
 }
 
+// DecodeBinarySizes returns the sizes of the binary tags present in the file
+// without extracting them, missing tags are left out
+func (decoder *ExifToolMostlyGeekLoader) DecodeBinarySizes(path string, tagNames []string) (map[string]int, error) {
+
+	flags := make([]string, len(tagNames))
+	for i, tagName := range tagNames {
+		flags[i] = "-" + tagName
+	}
+	bytes, err := decoder.exifTool.ExtractFlags(path, flags...)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int)
+	scanner := bufio.NewScanner(strings.NewReader(string(bytes)))
+	for scanner.Scan() {
+		nameValueSplit := strings.SplitN(scanner.Text(), ":", 2)
+		if len(nameValueSplit) < 2 {
+			continue
+		}
+		name := strings.TrimSpace(nameValueSplit[0])
+		match := previewValueMatcher.FindStringSubmatch(nameValueSplit[1])
+		if len(match) < 2 {
+			continue
+		}
+		size, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		sizes[name] = size
+	}
+	return sizes, scanner.Err()
+}
+
 func (decoder *ExifToolMostlyGeekLoader) Close() {
 	if decoder.exifTool != nil {
 		decoder.exifTool.Stop()
@@ -0,0 +1,193 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time into the video to take the poster frame from, as the first frame is
+// often black
+const posterOffset = 1 * time.Second
+
+const ffmpegTimeout = 30 * time.Second
+
+// ffmpeg outputs no frame without failing if seeking past the end of the video
+var errNoVideoFrame = errors.New("no video frame decoded")
+
+// FFmpeg extracts the poster frames and stream properties of videos using the
+// ffmpeg and ffprobe binaries
+type FFmpeg struct {
+	ffmpegPath  string
+	ffprobePath string
+}
+
+// NewFFmpeg returns nil if either of the binaries cannot be found
+func NewFFmpeg(ffmpegPath string, ffprobePath string) *FFmpeg {
+	if ffmpegPath == "" || ffprobePath == "" {
+		return nil
+	}
+	var err error
+	ffmpeg := &FFmpeg{}
+	ffmpeg.ffmpegPath, err = exec.LookPath(ffmpegPath)
+	if err != nil {
+		log.Printf("ffmpeg not found, videos without thumbnails will not be rendered (%s)\n", err.Error())
+		return nil
+	}
+	ffmpeg.ffprobePath, err = exec.LookPath(ffprobePath)
+	if err != nil {
+		log.Printf("ffprobe not found, videos without thumbnails will not be rendered (%s)\n", err.Error())
+		return nil
+	}
+	return ffmpeg
+}
+
+func (ffmpeg *FFmpeg) run(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// DecodePoster decodes a frame from the start of the video. The frame is not
+// rotated, so that the orientation of the video applies to it the same way as
+// to the EXIF orientation of photos.
+func (ffmpeg *FFmpeg) DecodePoster(path string) (image.Image, error) {
+	img, err := ffmpeg.decodeFrame(path, posterOffset)
+	if errors.Is(err, errNoVideoFrame) {
+		// Videos shorter than the offset
+		return ffmpeg.decodeFrame(path, 0)
+	}
+	return img, err
+}
+
+func (ffmpeg *FFmpeg) decodeFrame(path string, offset time.Duration) (image.Image, error) {
+	output, err := ffmpeg.run(ffmpeg.ffmpegPath,
+		"-v", "error",
+		"-noautorotate",
+		"-ss", strconv.FormatFloat(offset.Seconds(), 'f', 3, 64),
+		"-i", path,
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-c:v", "png",
+		"-",
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(output) == 0 {
+		return nil, errNoVideoFrame
+	}
+	return png.Decode(bytes.NewReader(output))
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		Tags         struct {
+			Rotate string `json:"rotate"`
+		} `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+		Tags     struct {
+			CreationTime string `json:"creation_time"`
+		} `json:"tags"`
+	} `json:"format"`
+}
+
+// DecodeInfo reads the duration, codec and frame rate of the video. The size,
+// orientation and date are only set if they are missing, as exiftool is
+// preferred for those.
+func (ffmpeg *FFmpeg) DecodeInfo(path string, info *Info) error {
+	output, err := ffmpeg.run(ffmpeg.ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,width,height,avg_frame_rate:stream_tags=rotate:stream_side_data=rotation:format=duration:format_tags=creation_time",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return err
+	}
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return err
+	}
+	if len(probe.Streams) == 0 {
+		return errors.New("no video stream found")
+	}
+	stream := probe.Streams[0]
+
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err == nil && seconds > 0 && !math.IsInf(seconds, 0) {
+		info.Video.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	info.Video.Codec = stream.CodecName
+	info.Video.FrameRate = parseFrameRate(stream.AvgFrameRate)
+
+	if info.Width == 0 || info.Height == 0 {
+		rotation := stream.Tags.Rotate
+		if rotation == "" && len(stream.SideDataList) > 0 {
+			// The display matrix rotation is counter-clockwise
+			degrees := int(math.Round(-stream.SideDataList[0].Rotation))
+			rotation = strconv.Itoa((degrees%360 + 360) % 360)
+		}
+		info.Orientation = getOrientationFromRotation(rotation)
+		info.Width, info.Height = stream.Width, stream.Height
+		if info.Orientation.SwapsDimensions() {
+			info.Width, info.Height = info.Height, info.Width
+		}
+	}
+
+	if info.DateTime.IsZero() && probe.Format.Tags.CreationTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, probe.Format.Tags.CreationTime)
+		if err == nil {
+			info.DateTime = t
+		}
+	}
+	return nil
+}
+
+// parseFrameRate parses the rational frame rate, e.g. 30000/1001
+func parseFrameRate(value string) float64 {
+	parts := strings.SplitN(value, "/", 2)
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(parts) == 1 {
+		return num
+	}
+	denom, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || denom == 0 {
+		return 0
+	}
+	return num / denom
+}
@@ -0,0 +1,22 @@
+package image
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseFrameRate(t *testing.T) {
+	tests := map[string]float64{
+		"30/1":       30,
+		"30000/1001": 29.97002997002997,
+		"59.94":      59.94,
+		"0/0":        0,
+		"":           0,
+		"30/fast":    0,
+	}
+	for value, want := range tests {
+		if got := parseFrameRate(value); math.Abs(got-want) > 1e-9 {
+			t.Errorf("parseFrameRate(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"photofield/internal/codec"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+func (source *Source) generateThumbnails(ids <-chan ImageId) {
+	for id := range ids {
+		path, err := source.GetImagePath(id)
+		if err != nil {
+			fmt.Println("Unable to find image path", err, path)
+			continue
+		}
+		err = source.GenerateThumbnails(path)
+		if err != nil {
+			fmt.Println("Unable to generate thumbnails", err, path)
+			continue
+		}
+	}
+}
+
+func (source *Source) QueueThumbnailGenerations(ids <-chan ImageId) {
+	if source.generateQueueThumbnails != nil {
+		for id := range ids {
+			source.generateQueueThumbnails.Append(id)
+		}
+	}
+}
+
+// ProcessThumbnailGenerations generates the thumbnails of the ids right away
+// instead of queueing them, stopping early if the context is cancelled
+func (source *Source) ProcessThumbnailGenerations(ctx context.Context, ids []ImageId, done func(id ImageId)) error {
+	return process(ctx, ids, source.generateThumbnails, source.thumbnailSlots, done)
+}
+
+func (source *Source) getGeneratedThumbnails() []*Thumbnail {
+	all := source.GetImages().Thumbnails
+	thumbnails := make([]*Thumbnail, 0)
+	for i := range all {
+		thumbnail := &all[i]
+		if thumbnail.Generate {
+			thumbnails = append(thumbnails, thumbnail)
+		}
+	}
+	return thumbnails
+}
+
+// GenerateThumbnails writes all the generated thumbnails of the image that do
+// not exist yet. The thumbnails are rotated according to the orientation of
+// the original, so that they can be used the same way as third party ones.
+// Videos get thumbnails of their poster frame if ffmpeg is available.
+func (source *Source) GenerateThumbnails(path string) error {
+	return source.writeGeneratedThumbnails(path, nil)
+}
+
+// writeGeneratedThumbnails writes the missing generated thumbnails from the
+// already decoded image or poster frame, decoding it only if it is nil
+func (source *Source) writeGeneratedThumbnails(path string, img image.Image) error {
+	isVideo := source.ffmpeg != nil && source.IsSupportedVideo(path)
+	if !source.IsSupportedImage(path) && !isVideo {
+		return ErrNotAnImage
+	}
+
+	missing := make([]*Thumbnail, 0)
+	for _, thumbnail := range source.getGeneratedThumbnails() {
+		if !source.Exists(thumbnail.GetPath(path)) {
+			missing = append(missing, thumbnail)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	info, err := source.LoadInfoMeta(path)
+	if err != nil {
+		return err
+	}
+
+	// Originals smaller than a thumbnail do not get one, so they are skipped
+	// by their known size instead of being decoded again every time
+	thumbnails := make([]*Thumbnail, 0, len(missing))
+	for _, thumbnail := range missing {
+		size := image.Point{X: info.Width, Y: info.Height}
+		if info.Width > 0 && info.Height > 0 && !isDownscale(thumbnail.Fit(size), size) {
+			continue
+		}
+		thumbnails = append(thumbnails, thumbnail)
+	}
+	if len(thumbnails) == 0 {
+		return nil
+	}
+
+	if img == nil {
+		if isVideo {
+			// LoadImage would write the thumbnails of the poster frame as well
+			img, err = source.ffmpeg.DecodePoster(path)
+		} else {
+			img, err = source.LoadImage(path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	orientation := info.Orientation
+	bounds := img.Bounds()
+	originalSize := bounds.Size()
+	if orientation.SwapsDimensions() {
+		originalSize = image.Point{X: originalSize.Y, Y: originalSize.X}
+	}
+
+	// Each thumbnail is scaled down from the previous larger one, which is a
+	// lot faster than scaling from the original every time. Catmull-Rom is
+	// used as it does not alias on large reductions, unlike bilinear.
+	sizes := make([]image.Point, len(thumbnails))
+	for i, thumbnail := range thumbnails {
+		sizes[i] = thumbnail.Fit(originalSize)
+	}
+	sort.Sort(thumbnailsBySize{thumbnails, sizes})
+
+	var src image.Image = img
+	for i, thumbnail := range thumbnails {
+		size := sizes[i]
+		// Scaling up would only waste space
+		if !isDownscale(size, originalSize) {
+			continue
+		}
+		if orientation.SwapsDimensions() {
+			size = image.Point{X: size.Y, Y: size.X}
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+		src = dst
+
+		thumbnailPath := thumbnail.GetPath(path)
+		err := writeThumbnail(thumbnailPath, orientImage(dst, orientation))
+		if err != nil {
+			return err
+		}
+		source.fileExistsCache.Del(thumbnailPath)
+		source.imageCache.DeleteThumbnail(path, thumbnail)
+	}
+	return nil
+}
+
+func isDownscale(size image.Point, originalSize image.Point) bool {
+	return size.X < originalSize.X && size.Y < originalSize.Y
+}
+
+// removeGeneratedThumbnails removes the generated thumbnails of the file,
+// returning true if there were any
+func (source *Source) removeGeneratedThumbnails(path string) bool {
+	removed := false
+	for _, thumbnail := range source.getGeneratedThumbnails() {
+		thumbnailPath := thumbnail.GetPath(path)
+		if err := os.Remove(thumbnailPath); err == nil {
+			removed = true
+		}
+		source.fileExistsCache.Del(thumbnailPath)
+		source.imageCache.DeleteThumbnail(path, thumbnail)
+	}
+	return removed
+}
+
+type thumbnailsBySize struct {
+	thumbnails []*Thumbnail
+	sizes      []image.Point
+}
+
+func (s thumbnailsBySize) Len() int {
+	return len(s.thumbnails)
+}
+
+func (s thumbnailsBySize) Less(i, j int) bool {
+	return s.sizes[i].X*s.sizes[i].Y > s.sizes[j].X*s.sizes[j].Y
+}
+
+func (s thumbnailsBySize) Swap(i, j int) {
+	s.thumbnails[i], s.thumbnails[j] = s.thumbnails[j], s.thumbnails[i]
+	s.sizes[i], s.sizes[j] = s.sizes[j], s.sizes[i]
+}
+
+const thumbnailQuality = 80
+
+// Writes to a temporary file first, so that partially written thumbnails are
+// never picked up for rendering
+func writeThumbnail(path string, img image.Image) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, ".thumbnail-*")
+	if err != nil {
+		return err
+	}
+	err = codec.EncodeJpeg(file, img, thumbnailQuality)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Returns the image transformed according to the EXIF orientation so that it
+// displays upright
+func orientImage(img *image.RGBA, orientation Orientation) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	var src func(x, y int) (int, int)
+	switch orientation {
+	case MirrorHorizontal:
+		src = func(x, y int) (int, int) { return w - 1 - x, y }
+	case Rotate180:
+		src = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case MirrorVertical:
+		src = func(x, y int) (int, int) { return x, h - 1 - y }
+	case MirrorHorizontalRotate270:
+		src = func(x, y int) (int, int) { return y, x }
+	case Rotate90:
+		src = func(x, y int) (int, int) { return y, h - 1 - x }
+	case MirrorHorizontalRotate90:
+		src = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case Rotate270:
+		src = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return img
+	}
+
+	dw, dh := w, h
+	if orientation.SwapsDimensions() {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := src(x, y)
+			si := img.PixOffset(bounds.Min.X+sx, bounds.Min.Y+sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], img.Pix[si:si+4])
+		}
+	}
+	return dst
+}
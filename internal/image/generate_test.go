@@ -0,0 +1,46 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestOrientImage(t *testing.T) {
+	// Pixels are named by the letters stored in the red channel
+	//   abc
+	//   def
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i, letter := range "abcdef" {
+		src.Set(i%3, i/3, color.RGBA{R: uint8(letter), A: 0xFF})
+	}
+	tests := []struct {
+		orientation Orientation
+		want        string
+	}{
+		{Normal, "abc def"},
+		{MirrorHorizontal, "cba fed"},
+		{Rotate180, "fed cba"},
+		{MirrorVertical, "def abc"},
+		{MirrorHorizontalRotate270, "ad be cf"},
+		{Rotate90, "da eb fc"},
+		{MirrorHorizontalRotate90, "fc eb da"},
+		{Rotate270, "cf be ad"},
+	}
+	for _, test := range tests {
+		img := orientImage(src, test.orientation)
+		bounds := img.Bounds()
+		rows := make([]string, 0, bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			var row strings.Builder
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				row.WriteByte(img.RGBAAt(x, y).R)
+			}
+			rows = append(rows, row.String())
+		}
+		if got := strings.Join(rows, " "); got != test.want {
+			t.Errorf("orientImage(%d) = %q, want %q", test.orientation, got, test.want)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package image
 
 import (
+	"bytes"
 	"image"
 	"io"
 	"os"
+	"photofield/internal/codec"
 
 	"github.com/rwcarlsen/goexif/exif"
 )
@@ -28,19 +30,72 @@ func getOrientationFromExif(x *exif.Exif) string {
 	return "1"
 }
 
+func getLocationFromExif(x *exif.Exif) Location {
+	var location Location
+	lat, lon, err := x.LatLong()
+	if err != nil || !isValidLatLon(lat, lon) {
+		return location
+	}
+	location.Latitude = lat
+	location.Longitude = lon
+	location.Valid = true
+
+	alt, err := x.Get(exif.GPSAltitude)
+	if err != nil || alt.Count == 0 {
+		return location
+	}
+	num, denom, err := alt.Rat2(0)
+	if err != nil || denom == 0 {
+		return location
+	}
+	location.Altitude = float64(num) / float64(denom)
+	location.AltitudeValid = true
+
+	// Reference 1 means below sea level
+	ref, err := x.Get(exif.GPSAltitudeRef)
+	if err == nil && ref.Count > 0 {
+		if v, err := ref.Int(0); err == nil && v == 1 {
+			location.Altitude = -location.Altitude
+		}
+	}
+	return location
+}
+
 func (decoder *GoExifRwcarlsenLoader) DecodeInfo(path string, info *Info) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	if isHeif(path) {
+		return decoder.decodeHeifInfoReader(file, info)
+	}
 	return decoder.DecodeInfoReader(file, info)
 }
 
+func (decoder *GoExifRwcarlsenLoader) decodeHeifInfoReader(r io.Reader, info *Info) error {
+	conf, err := codec.DecodeHeifConfig(r)
+	if err != nil {
+		return err
+	}
+	if len(conf.Exif) > 0 {
+		x, err := exif.Decode(bytes.NewReader(conf.Exif))
+		if err == nil {
+			info.DateTime, _ = x.DateTime()
+			info.Location = getLocationFromExif(x)
+		}
+	}
+	// The size already has the rotation applied
+	info.Width, info.Height = conf.Width, conf.Height
+	info.Orientation = Normal
+	return nil
+}
+
 func (decoder *GoExifRwcarlsenLoader) DecodeInfoReader(r io.ReadSeeker, info *Info) error {
 	x, err := exif.Decode(r)
 	if err == nil {
 		info.DateTime, _ = x.DateTime()
+		info.Location = getLocationFromExif(x)
 	}
 
 	orientation := parseOrientation(getOrientationFromExif(x))
@@ -81,4 +136,29 @@ func (decoder *GoExifRwcarlsenLoader) DecodeBytes(path string, tagName string) (
 	return tag.Val, nil
 }
 
+// DecodeBinarySizes returns the sizes of the binary tags present in the file,
+// missing tags are left out
+func (decoder *GoExifRwcarlsenLoader) DecodeBinarySizes(path string, tagNames []string) (map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	x, err := exif.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int)
+	for _, tagName := range tagNames {
+		tag, err := x.Get(exif.FieldName(tagName))
+		if err != nil {
+			continue
+		}
+		sizes[tagName] = len(tag.Val)
+	}
+	return sizes, nil
+}
+
 func (decoder *GoExifRwcarlsenLoader) Close() {}
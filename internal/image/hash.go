@@ -0,0 +1,164 @@
+package image
+
+import (
+	"image"
+	"math/bits"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// Hash is a 64-bit perceptual difference hash (dHash) of an image. Similar
+// looking images have hashes with a small Hamming distance, regardless of
+// their size, format or compression.
+type Hash uint64
+
+const hashSize = 8
+
+// NewHash computes the difference hash of the image by scaling it down to
+// 9x8 grayscale pixels and comparing the brightness of neighboring pixels.
+func NewHash(img image.Image) Hash {
+	small := image.NewGray(image.Rect(0, 0, hashSize+1, hashSize))
+	draw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash Hash
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y < small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func (hash Hash) Distance(other Hash) int {
+	return bits.OnesCount64(uint64(hash ^ other))
+}
+
+type HashedFile struct {
+	Id   ImageId
+	Path string
+	Hash Hash
+}
+
+type DuplicateFile struct {
+	HashedFile
+	// Hamming distance to the first file of the group
+	Distance int
+}
+
+// MaxDuplicateDistance limits the distance for near-duplicates, as looser
+// thresholds produce mostly false positives for a 64-bit hash and make the
+// search a lot slower.
+const MaxDuplicateDistance = 8
+
+// The hashes are split into this many bands of 16 bits for the search
+const hashBands = 4
+const hashBandBits = 64 / hashBands
+
+// FindDuplicates groups files with a hash distance of at most maxDistance to
+// at least one other file in the group. Groups are ordered by size, largest
+// first, and only groups with more than one file are returned.
+//
+// Comparing all pairs would be quadratic, so the files are indexed by each
+// band of their hash instead (multi-index hashing). Two hashes within the
+// distance have at least one band within maxDistance/hashBands bits of each
+// other, so only the files in the buckets of those nearby band values are
+// compared.
+func FindDuplicates(files []HashedFile, maxDistance int) [][]DuplicateFile {
+	if maxDistance < 0 {
+		maxDistance = 0
+	}
+	if maxDistance > MaxDuplicateDistance {
+		maxDistance = MaxDuplicateDistance
+	}
+
+	parents := make([]int, len(files))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+
+	masks := bandMasks(maxDistance / hashBands)
+	for band := 0; band < hashBands; band++ {
+		shift := band * hashBandBits
+		buckets := make(map[uint16][]int)
+		for i, file := range files {
+			key := uint16(file.Hash >> shift)
+			buckets[key] = append(buckets[key], i)
+		}
+
+		for i, file := range files {
+			key := uint16(file.Hash >> shift)
+			for _, mask := range masks {
+				for _, j := range buckets[key^mask] {
+					// Each pair is only compared from one side
+					if j <= i {
+						continue
+					}
+					if file.Hash.Distance(files[j].Hash) > maxDistance {
+						continue
+					}
+					ri, rj := find(i), find(j)
+					if ri != rj {
+						parents[rj] = ri
+					}
+				}
+			}
+		}
+	}
+
+	groupIndices := make(map[int]int)
+	groups := make([][]DuplicateFile, 0)
+	for i, file := range files {
+		root := find(i)
+		index, ok := groupIndices[root]
+		if !ok {
+			index = len(groups)
+			groupIndices[root] = index
+			groups = append(groups, nil)
+		}
+		groups[index] = append(groups[index], DuplicateFile{HashedFile: file})
+	}
+
+	duplicates := make([][]DuplicateFile, 0)
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		for i := range group {
+			group[i].Distance = group[0].Hash.Distance(group[i].Hash)
+		}
+		duplicates = append(duplicates, group)
+	}
+
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return len(duplicates[i]) > len(duplicates[j])
+	})
+	return duplicates
+}
+
+// bandMasks returns all the band values with at most distance bits set,
+// flipping the bits of a band value with them results in all the values
+// within the distance
+func bandMasks(distance int) []uint16 {
+	masks := []uint16{0}
+	for bit := 0; bit < hashBandBits; bit++ {
+		count := len(masks)
+		for i := 0; i < count; i++ {
+			mask := masks[i] | 1<<bit
+			if bits.OnesCount16(mask) <= distance {
+				masks = append(masks, mask)
+			}
+		}
+	}
+	return masks
+}
@@ -0,0 +1,61 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+// lowBits returns a hash with the lowest count bits set
+func lowBits(count int) Hash {
+	return Hash(1)<<count - 1
+}
+
+func TestFindDuplicates(t *testing.T) {
+	tests := []struct {
+		hashes      []Hash
+		maxDistance int
+		want        [][]ImageId
+	}{
+		{[]Hash{1}, 4, [][]ImageId{}},
+		{[]Hash{42, 42}, 0, [][]ImageId{{1, 2}}},
+		{[]Hash{0, lowBits(3)}, 4, [][]ImageId{{1, 2}}},
+		{[]Hash{0, lowBits(5)}, 4, [][]ImageId{}},
+		{[]Hash{0, 0xff00}, 8, [][]ImageId{{1, 2}}},
+		{[]Hash{0, lowBits(13)}, 20, [][]ImageId{}},
+		// 1 and 3 are too far apart, but both are close to 2
+		{[]Hash{0, lowBits(4), lowBits(8)}, 4, [][]ImageId{{1, 2, 3}}},
+		{[]Hash{0, 0, ^Hash(0), ^Hash(0), ^Hash(0)}, 2, [][]ImageId{{3, 4, 5}, {1, 2}}},
+	}
+	for _, test := range tests {
+		files := make([]HashedFile, len(test.hashes))
+		for i, hash := range test.hashes {
+			files[i] = HashedFile{Id: ImageId(i + 1), Hash: hash}
+		}
+		groups := FindDuplicates(files, test.maxDistance)
+		got := make([][]ImageId, len(groups))
+		for i, group := range groups {
+			for _, file := range group {
+				got[i] = append(got[i], file.Id)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("FindDuplicates(%x, %d) = %v, want %v", test.hashes, test.maxDistance, got, test.want)
+		}
+	}
+}
+
+func TestFindDuplicatesDistance(t *testing.T) {
+	groups := FindDuplicates([]HashedFile{
+		{Id: 1, Hash: 0},
+		{Id: 2, Hash: lowBits(2)},
+		{Id: 3, Hash: lowBits(4)},
+	}, 2)
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("FindDuplicates() = %v, want one group of 3", groups)
+	}
+	for i, want := range []int{0, 2, 4} {
+		if got := groups[0][i].Distance; got != want {
+			t.Errorf("file %d distance = %d, want %d", groups[0][i].Id, got, want)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"image"
 	"image/color"
 	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"photofield/internal/codec"
 	"strings"
 	"time"
@@ -37,12 +39,33 @@ func (source *Source) Exists(path string) bool {
 	return exists
 }
 
+// Camera RAW files are rendered from their embedded JPEG previews
+var rawExtensions = []string{".arw", ".cr2", ".cr3", ".dng", ".nef", ".orf", ".pef", ".raf", ".rw2"}
+
+func isRaw(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, rawExt := range rawExtensions {
+		if ext == rawExt {
+			return true
+		}
+	}
+	return false
+}
+
+func isHeif(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".heic") || strings.HasSuffix(lower, ".heif")
+}
+
 func (source *Source) decode(path string, reader io.ReadSeeker) (image.Image, error) {
 	lower := strings.ToLower(path)
 	if strings.HasSuffix(lower, "jpg") || strings.HasSuffix(lower, "jpeg") {
 		image, err := codec.DecodeJpeg(reader)
 		return image, err
 	}
+	if isHeif(path) {
+		return codec.DecodeHeif(reader)
+	}
 
 	image, _, err := image.Decode(reader)
 	return image, err
@@ -50,6 +73,12 @@ func (source *Source) decode(path string, reader io.ReadSeeker) (image.Image, er
 
 func (source *Source) LoadImage(path string) (image.Image, error) {
 	// fmt.Printf("loading %s\n", path)
+	if isRaw(path) {
+		return source.decoder.DecodeRawPreview(path)
+	}
+	if source.ffmpeg != nil && source.IsSupportedVideo(path) {
+		return source.loadPoster(path)
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -58,6 +87,24 @@ func (source *Source) LoadImage(path string) (image.Image, error) {
 	return source.decode(path, file)
 }
 
+// loadPoster decodes the poster frame of the video and writes the generated
+// thumbnails from it in the background, so that ffmpeg does not need to run
+// again the next time the video is rendered
+func (source *Source) loadPoster(path string) (image.Image, error) {
+	img, err := source.ffmpeg.DecodePoster(path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		source.thumbnailSlots <- struct{}{}
+		defer func() { <-source.thumbnailSlots }()
+		if err := source.writeGeneratedThumbnails(path, img); err != nil {
+			log.Printf("Unable to generate poster thumbnails %s: %s\n", path, err.Error())
+		}
+	}()
+	return img, nil
+}
+
 func (source *Source) Acquire(key string, path string, thumbnail *Thumbnail) (image.Image, Info, error) {
 	// log.Printf("%v acquire, %v\n", key, source.imagesLoadingCount)
 	source.imagesLoadingCount++
@@ -116,8 +163,9 @@ func (source *Source) GetImageOrThumbnail(path string, thumbnail *Thumbnail) (im
 }
 
 func (source *Source) GetSmallestThumbnail(path string) string {
-	for i := range source.Images.Thumbnails {
-		thumbnail := &source.Images.Thumbnails[i]
+	thumbnails := source.GetImages().Thumbnails
+	for i := range thumbnails {
+		thumbnail := &thumbnails[i]
 		thumbnailPath := thumbnail.GetPath(path)
 		if source.Exists(thumbnailPath) {
 			return thumbnailPath
@@ -127,8 +175,9 @@ func (source *Source) GetSmallestThumbnail(path string) string {
 }
 
 func (source *Source) LoadSmallestImage(path string) (image.Image, error) {
-	for i := range source.Images.Thumbnails {
-		thumbnail := &source.Images.Thumbnails[i]
+	thumbnails := source.GetImages().Thumbnails
+	for i := range thumbnails {
+		thumbnail := &thumbnails[i]
 		thumbnailPath := thumbnail.GetPath(path)
 		file, err := os.Open(thumbnailPath)
 		if err != nil {
@@ -140,6 +189,20 @@ func (source *Source) LoadSmallestImage(path string) (image.Image, error) {
 	return source.LoadImage(path)
 }
 
+// LoadImageHash computes the perceptual hash from the smallest thumbnail
+// available, falling back to the original image
+func (source *Source) LoadImageHash(path string) (Hash, error) {
+	hashPath := source.GetSmallestThumbnail(path)
+	if hashPath == "" {
+		hashPath = path
+	}
+	img, err := source.LoadImage(hashPath)
+	if err != nil {
+		return 0, err
+	}
+	return NewHash(img), nil
+}
+
 func (source *Source) LoadImageColor(path string) (color.RGBA, error) {
 	colorImage, err := source.LoadSmallestImage(path)
 	if err != nil {
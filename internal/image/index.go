@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -14,7 +15,9 @@ import (
 
 var ErrSkip = errors.New("skipping the rest")
 
-func walkFiles(dir string, extensions []string, maxFiles int) <-chan string {
+// walkFiles lists the files with the extensions in the dir passing the filter,
+// until maxFiles are found or the context is cancelled
+func walkFiles(ctx context.Context, dir string, extensions []string, filter *PathFilter, maxFiles int) <-chan string {
 	out := make(chan string)
 	go func() {
 		finished := metrics.Elapsed(fmt.Sprintf("index %s", dir))
@@ -29,14 +32,14 @@ func walkFiles(dir string, extensions []string, maxFiles int) <-chan string {
 					return filepath.SkipDir
 				}
 
-				suffix := ""
-				for _, ext := range extensions {
-					if strings.HasSuffix(strings.ToLower(path), ext) {
-						suffix = ext
-						break
+				if walk_dir.IsDir() {
+					if path != dir && !filter.MatchDir(dir, path) {
+						return filepath.SkipDir
 					}
+					return nil
 				}
-				if suffix == "" {
+
+				if !hasExtension(path, extensions) || !filter.Match(dir, path) {
 					return nil
 				}
 
@@ -46,14 +49,18 @@ func walkFiles(dir string, extensions []string, maxFiles int) <-chan string {
 					lastLogTime = now
 					log.Printf("indexing %s %d files\n", dir, files)
 				}
-				out <- path
+				select {
+				case out <- path:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 				if maxFiles > 0 && files >= maxFiles {
 					return ErrSkip
 				}
 				return nil
 			},
 		})
-		if err != nil && err != ErrSkip {
+		if err != nil && err != ErrSkip && err != ctx.Err() {
 			log.Printf("Error indexing files: %s\n", err.Error())
 		}
 
@@ -61,3 +68,13 @@ func walkFiles(dir string, extensions []string, maxFiles int) <-chan string {
 	}()
 	return out
 }
+
+func hasExtension(path string, extensions []string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range extensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
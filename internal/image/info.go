@@ -11,6 +11,37 @@ type Info struct {
 	DateTime      time.Time
 	Color         uint32
 	Orientation   Orientation
+	Location      Location
+	Video         VideoInfo
+	// Placeholder is set if the metadata or the color of the file have not
+	// been loaded yet and the info is only a guess
+	Placeholder bool
+}
+
+// VideoInfo holds the properties of the video stream, it is zero for images
+// and if ffprobe is not available
+type VideoInfo struct {
+	Duration  time.Duration
+	Codec     string
+	FrameRate float64
+}
+
+// Location in WGS 84 degrees with the altitude in meters above sea level
+type Location struct {
+	Latitude, Longitude float64
+	Altitude            float64
+	Valid               bool
+	AltitudeValid       bool
+}
+
+func (location Location) String() string {
+	if !location.Valid {
+		return "none"
+	}
+	if !location.AltitudeValid {
+		return fmt.Sprintf("%.6f, %.6f", location.Latitude, location.Longitude)
+	}
+	return fmt.Sprintf("%.6f, %.6f, %.1fm", location.Latitude, location.Longitude, location.Altitude)
 }
 
 func (info *Info) Size() Size {
@@ -18,12 +49,13 @@ func (info *Info) Size() Size {
 }
 
 func (info *Info) String() string {
-	return fmt.Sprintf("width: %v, height: %v, date: %v, color: %08x, orientation: %s",
+	return fmt.Sprintf("width: %v, height: %v, date: %v, color: %08x, orientation: %s, location: %s",
 		info.Width,
 		info.Height,
 		info.DateTime.String(),
 		info.Color,
 		info.Orientation,
+		info.Location,
 	)
 }
 
@@ -0,0 +1,118 @@
+package image
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathFilter narrows down the files in dirs by glob patterns and depth.
+//
+// Patterns are matched against the slash separated path relative to the dir.
+// Patterns without a slash match any element of the path, e.g. ".thumbnails"
+// or "*.tmp". Patterns with a slash match the leading elements of the path,
+// e.g. "*/exports" or "*/exports/*" for exports one level down.
+type PathFilter struct {
+	Include []string
+	Exclude []string
+	// Files directly in the dir are at depth 1, 0 for no limit
+	MaxDepth int
+}
+
+func (filter *PathFilter) Validate() error {
+	for _, patterns := range [][]string{filter.Include, filter.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %s: %w", pattern, err)
+			}
+		}
+	}
+	if filter.MaxDepth < 0 {
+		return fmt.Errorf("invalid max depth %d", filter.MaxDepth)
+	}
+	return nil
+}
+
+// Match returns true if the file at the path in the dir passes the filter
+func (filter *PathFilter) Match(dir string, path string) bool {
+	if filter == nil {
+		return true
+	}
+	elements := relativeElements(dir, path)
+	if filter.MaxDepth > 0 && len(elements) > filter.MaxDepth {
+		return false
+	}
+	if matchAnyPattern(filter.Exclude, elements) {
+		return false
+	}
+	if len(filter.Include) > 0 && !matchAnyPattern(filter.Include, elements) {
+		return false
+	}
+	return true
+}
+
+// MatchDir returns false if none of the files in the subdir of the dir can
+// pass the filter, so that it does not need to be walked
+func (filter *PathFilter) MatchDir(dir string, subdir string) bool {
+	if filter == nil {
+		return true
+	}
+	elements := relativeElements(dir, subdir)
+	if filter.MaxDepth > 0 && len(elements) >= filter.MaxDepth {
+		return false
+	}
+	return !matchAnyPattern(filter.Exclude, elements)
+}
+
+// MatchAny returns true if the file passes the filter in the dir it belongs
+// to, the longest one if the dirs are nested
+func (filter *PathFilter) MatchAny(dirs []string, path string) bool {
+	if filter == nil {
+		return true
+	}
+	dir := ""
+	for _, d := range dirs {
+		prefix := strings.TrimSuffix(d, string(filepath.Separator)) + string(filepath.Separator)
+		if strings.HasPrefix(path, prefix) && len(d) > len(dir) {
+			dir = d
+		}
+	}
+	return filter.Match(dir, path)
+}
+
+func relativeElements(dir string, p string) []string {
+	rel := strings.TrimPrefix(p, dir)
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	if rel == "" {
+		return nil
+	}
+	return strings.Split(rel, "/")
+}
+
+func matchAnyPattern(patterns []string, elements []string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, elements) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern string, elements []string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		for _, element := range elements {
+			if ok, _ := path.Match(pattern, element); ok {
+				return true
+			}
+		}
+		return false
+	}
+	count := strings.Count(pattern, "/") + 1
+	if count > len(elements) {
+		return false
+	}
+	ok, _ := path.Match(pattern, strings.Join(elements[:count], "/"))
+	return ok
+}
@@ -0,0 +1,77 @@
+package image
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPathFilterMatch(t *testing.T) {
+	dir := filepath.FromSlash("/photos")
+	tests := []struct {
+		filter *PathFilter
+		path   string
+		want   bool
+	}{
+		{nil, "/photos/a/b.jpg", true},
+		{&PathFilter{Exclude: []string{".thumbnails"}}, "/photos/a/.thumbnails/b.jpg", false},
+		{&PathFilter{Exclude: []string{".thumbnails"}}, "/photos/a/b.jpg", true},
+		{&PathFilter{Exclude: []string{"*.tmp"}}, "/photos/a/b.tmp", false},
+		{&PathFilter{Exclude: []string{"*/exports"}}, "/photos/2021/exports/b.jpg", false},
+		{&PathFilter{Exclude: []string{"*/exports"}}, "/photos/2021/x/exports/b.jpg", true},
+		{&PathFilter{Exclude: []string{"photos"}}, "/photos/b.jpg", true},
+		{&PathFilter{Include: []string{"2021"}}, "/photos/2021/b.jpg", true},
+		{&PathFilter{Include: []string{"2021"}}, "/photos/2020/b.jpg", false},
+		{&PathFilter{MaxDepth: 1}, "/photos/b.jpg", true},
+		{&PathFilter{MaxDepth: 1}, "/photos/a/b.jpg", false},
+	}
+	for _, test := range tests {
+		if got := test.filter.Match(dir, filepath.FromSlash(test.path)); got != test.want {
+			t.Errorf("%+v Match(%q) = %v, want %v", test.filter, test.path, got, test.want)
+		}
+	}
+}
+
+func TestPathFilterMatchDir(t *testing.T) {
+	dir := filepath.FromSlash("/photos")
+	tests := []struct {
+		filter *PathFilter
+		subdir string
+		want   bool
+	}{
+		{&PathFilter{Exclude: []string{"@eaDir"}}, "/photos/a/@eaDir", false},
+		{&PathFilter{Exclude: []string{"*/exports"}}, "/photos/2021", true},
+		// Files in subdirs of the included dirs could still match
+		{&PathFilter{Include: []string{"*.jpg"}}, "/photos/a", true},
+		{&PathFilter{MaxDepth: 1}, "/photos/a", false},
+		{&PathFilter{MaxDepth: 2}, "/photos/a", true},
+	}
+	for _, test := range tests {
+		if got := test.filter.MatchDir(dir, filepath.FromSlash(test.subdir)); got != test.want {
+			t.Errorf("%+v MatchDir(%q) = %v, want %v", test.filter, test.subdir, got, test.want)
+		}
+	}
+}
+
+func TestPathFilterMatchAny(t *testing.T) {
+	filter := &PathFilter{Exclude: []string{"2021"}, MaxDepth: 2}
+	tests := []struct {
+		dirs []string
+		path string
+		want bool
+	}{
+		{[]string{"/photos"}, "/photos/2021/b.jpg", false},
+		{[]string{"/photos"}, "/photos/a/b/c.jpg", false},
+		// The longest dir containing the file applies
+		{[]string{"/photos/a", "/photos"}, "/photos/a/b/c.jpg", true},
+		{[]string{"/photos", "/photos/20"}, "/photos/2021/b.jpg", false},
+	}
+	for _, test := range tests {
+		dirs := make([]string, len(test.dirs))
+		for i := range test.dirs {
+			dirs[i] = filepath.FromSlash(test.dirs[i])
+		}
+		if got := filter.MatchAny(dirs, filepath.FromSlash(test.path)); got != test.want {
+			t.Errorf("MatchAny(%v, %q) = %v, want %v", test.dirs, test.path, got, test.want)
+		}
+	}
+}
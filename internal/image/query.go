@@ -0,0 +1,389 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query is a parsed search query, all of the specified conditions have to
+// match for a file to be included.
+//
+// The query language consists of space-separated terms, each term is either
+// plain text matching a part of the filename or a qualifier in the form of
+// key:value. Values containing spaces can be quoted, e.g. path:"My Photos".
+//
+//   IMG_4821                 filename contains "IMG_4821"
+//   name:IMG_48              same as above
+//   path:vacation            path of the containing dir contains "vacation"
+//   path:*/2021/*.mp4        full path matches the glob pattern (* ? [...]),
+//                            ignoring case
+//   after:2021-06            taken on or after June 2021
+//   before:2021-06-15        taken before June 15, 2021
+//   date:2021                taken in 2021
+//   width:>=1920             width of at least 1920 pixels (also >, <, <=, =)
+//   height:1000..2000        height between 1000 and 2000 pixels inclusive
+//   orientation:portrait     portrait, landscape or square
+//   type:video               image, video or a file extension like jpg
+//   collection:vacation      in the collection with the specified id
+type Query struct {
+	Names        []string
+	Paths        []string
+	Globs        []string
+	After        time.Time
+	Before       time.Time
+	Width        IntRange
+	Height       IntRange
+	Orientations []string
+	Types        []string
+	Extensions   []string
+	Collections  []string
+
+	// Resolved from collections by the caller, files in any of the dirs match
+	Dirs []string
+
+	// Queries that have to match as well, e.g. to narrow down a query to
+	// a collection without the conditions of both replacing each other
+	And []Query
+}
+
+type IntRange struct {
+	Min *int
+	Max *int
+}
+
+func (r IntRange) IsZero() bool {
+	return r.Min == nil && r.Max == nil
+}
+
+func (query *Query) IsZero() bool {
+	return len(query.Names) == 0 &&
+		len(query.Paths) == 0 &&
+		len(query.Globs) == 0 &&
+		query.After.IsZero() &&
+		query.Before.IsZero() &&
+		query.Width.IsZero() &&
+		query.Height.IsZero() &&
+		len(query.Orientations) == 0 &&
+		len(query.Types) == 0 &&
+		len(query.Extensions) == 0 &&
+		len(query.Collections) == 0 &&
+		len(query.Dirs) == 0 &&
+		len(query.And) == 0
+}
+
+func ParseQuery(str string) (Query, error) {
+	query := Query{}
+	terms, err := splitQueryTerms(str)
+	if err != nil {
+		return query, err
+	}
+	for _, term := range terms {
+		key := ""
+		value := term.value
+		if term.key != "" {
+			key = strings.ToLower(term.key)
+		}
+		if value == "" {
+			return query, fmt.Errorf("%w: missing value for %s", ErrInvalidQuery, key)
+		}
+		switch key {
+		case "", "name", "filename":
+			query.Names = append(query.Names, value)
+		case "path", "dir":
+			if strings.ContainsAny(value, "*?[") {
+				query.Globs = append(query.Globs, value)
+			} else {
+				query.Paths = append(query.Paths, value)
+			}
+		case "after":
+			start, _, err := parseQueryDate(value)
+			if err != nil {
+				return query, err
+			}
+			query.After = start
+		case "before":
+			start, _, err := parseQueryDate(value)
+			if err != nil {
+				return query, err
+			}
+			query.Before = start
+		case "date":
+			start, end, err := parseQueryDate(value)
+			if err != nil {
+				return query, err
+			}
+			query.After = start
+			query.Before = end
+		case "width", "w":
+			query.Width, err = parseQueryRange(key, value)
+			if err != nil {
+				return query, err
+			}
+		case "height", "h":
+			query.Height, err = parseQueryRange(key, value)
+			if err != nil {
+				return query, err
+			}
+		case "orientation":
+			value = strings.ToLower(value)
+			switch value {
+			case "landscape", "portrait", "square":
+				query.Orientations = append(query.Orientations, value)
+			default:
+				return query, fmt.Errorf("%w: unknown orientation %s", ErrInvalidQuery, value)
+			}
+		case "type", "ext", "extension":
+			value = strings.ToLower(value)
+			switch value {
+			case "image", "video":
+				query.Types = append(query.Types, value)
+			default:
+				query.Extensions = append(query.Extensions, "."+strings.TrimPrefix(value, "."))
+			}
+		case "collection":
+			query.Collections = append(query.Collections, value)
+		default:
+			return query, fmt.Errorf("%w: unknown qualifier %s", ErrInvalidQuery, key)
+		}
+	}
+	return query, nil
+}
+
+type queryTerm struct {
+	key   string
+	value string
+}
+
+func splitQueryTerms(str string) ([]queryTerm, error) {
+	terms := make([]queryTerm, 0)
+	runes := []rune(str)
+	i := 0
+	for i < len(runes) {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		term := queryTerm{}
+		var sb strings.Builder
+		quoted := false
+		for ; i < len(runes); i++ {
+			r := runes[i]
+			if r == '"' {
+				quoted = !quoted
+				continue
+			}
+			if !quoted && unicode.IsSpace(r) {
+				break
+			}
+			if !quoted && r == ':' && term.key == "" && sb.Len() > 0 {
+				term.key = sb.String()
+				sb.Reset()
+				continue
+			}
+			sb.WriteRune(r)
+		}
+		if quoted {
+			return nil, fmt.Errorf("%w: unterminated quote", ErrInvalidQuery)
+		}
+		term.value = sb.String()
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+// Returns the start of the date and the start of the next one at the same
+// precision, e.g. 2021-06 results in 2021-06-01 and 2021-07-01
+func parseQueryDate(value string) (time.Time, time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.Parse("2006-01", value); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	if t, err := time.Parse("2006", value); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("%w: invalid date %s, expected YYYY, YYYY-MM or YYYY-MM-DD", ErrInvalidQuery, value)
+}
+
+func parseQueryRange(key string, value string) (IntRange, error) {
+	r := IntRange{}
+	parse := func(s string) (*int, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number for %s: %s", ErrInvalidQuery, key, s)
+		}
+		return &n, nil
+	}
+	var err error
+	switch {
+	case strings.Contains(value, ".."):
+		bounds := strings.SplitN(value, "..", 2)
+		if bounds[0] != "" {
+			if r.Min, err = parse(bounds[0]); err != nil {
+				return r, err
+			}
+		}
+		if bounds[1] != "" {
+			if r.Max, err = parse(bounds[1]); err != nil {
+				return r, err
+			}
+		}
+	case strings.HasPrefix(value, ">="):
+		r.Min, err = parse(value[2:])
+	case strings.HasPrefix(value, "<="):
+		r.Max, err = parse(value[2:])
+	case strings.HasPrefix(value, ">"):
+		if r.Min, err = parse(value[1:]); err == nil {
+			*r.Min++
+		}
+	case strings.HasPrefix(value, "<"):
+		if r.Max, err = parse(value[1:]); err == nil {
+			*r.Max--
+		}
+	default:
+		r.Min, err = parse(strings.TrimPrefix(value, "="))
+		r.Max = r.Min
+	}
+	return r, err
+}
+
+// Returns an FTS5 string matching the value literally within the column
+func ftsPhrase(column string, value string) string {
+	return fmt.Sprintf(`%s : "%s"`, column, strings.ReplaceAll(value, `"`, `""`))
+}
+
+func likeEscape(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `%`, `\%`)
+	value = strings.ReplaceAll(value, `_`, `\_`)
+	return value
+}
+
+// Trigram matching needs at least three characters, shorter values fall back
+// to a full scan
+const ftsMinLength = 3
+
+// needsPrefix returns true if the conditions refer to the prefix table
+func (query *Query) needsPrefix() bool {
+	for _, path := range query.Paths {
+		if len([]rune(path)) < ftsMinLength {
+			return true
+		}
+	}
+	for i := range query.And {
+		if query.And[i].needsPrefix() {
+			return true
+		}
+	}
+	return len(query.Globs) > 0 || len(query.Dirs) > 0
+}
+
+// where returns the SQL conditions and their arguments for a SELECT on infos
+// joined with prefix
+func (query *Query) where() (string, []interface{}) {
+	conds := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	for _, name := range query.Names {
+		if len([]rune(name)) < ftsMinLength {
+			conds = append(conds, `filename LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeEscape(name)+"%")
+			continue
+		}
+		conds = append(conds, `infos.rowid IN (SELECT rowid FROM infos_fts WHERE infos_fts MATCH ?)`)
+		args = append(args, ftsPhrase("filename", name))
+	}
+
+	for _, path := range query.Paths {
+		if len([]rune(path)) < ftsMinLength {
+			conds = append(conds, `str LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeEscape(path)+"%")
+			continue
+		}
+		conds = append(conds, `infos.rowid IN (SELECT rowid FROM infos_fts WHERE infos_fts MATCH ?)`)
+		args = append(args, ftsPhrase("path", path))
+	}
+
+	for _, glob := range query.Globs {
+		conds = append(conds, `lower(str || filename) GLOB lower(?)`)
+		args = append(args, glob)
+	}
+
+	// Dates are compared in the local time of the photo
+	if !query.After.IsZero() {
+		conds = append(conds, `created_at_unix + created_at_tz_offset*60 >= ?`)
+		args = append(args, query.After.Unix())
+	}
+	if !query.Before.IsZero() {
+		conds = append(conds, `created_at_unix + created_at_tz_offset*60 < ?`)
+		args = append(args, query.Before.Unix())
+	}
+
+	if query.Width.Min != nil {
+		conds = append(conds, `width >= ?`)
+		args = append(args, int64(*query.Width.Min))
+	}
+	if query.Width.Max != nil {
+		conds = append(conds, `width <= ?`)
+		args = append(args, int64(*query.Width.Max))
+	}
+	if query.Height.Min != nil {
+		conds = append(conds, `height >= ?`)
+		args = append(args, int64(*query.Height.Min))
+	}
+	if query.Height.Max != nil {
+		conds = append(conds, `height <= ?`)
+		args = append(args, int64(*query.Height.Max))
+	}
+
+	if len(query.Orientations) > 0 {
+		or := make([]string, 0, len(query.Orientations))
+		for _, orientation := range query.Orientations {
+			switch orientation {
+			case "landscape":
+				or = append(or, `width > height`)
+			case "portrait":
+				or = append(or, `width < height`)
+			case "square":
+				or = append(or, `width == height`)
+			}
+		}
+		conds = append(conds, "("+strings.Join(or, " OR ")+")")
+	}
+
+	if len(query.Extensions) > 0 {
+		or := make([]string, 0, len(query.Extensions))
+		for _, ext := range query.Extensions {
+			or = append(or, `filename LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeEscape(ext))
+		}
+		conds = append(conds, "("+strings.Join(or, " OR ")+")")
+	}
+
+	if len(query.Dirs) > 0 {
+		or := make([]string, 0, len(query.Dirs))
+		for _, dir := range query.Dirs {
+			or = append(or, `str LIKE ?`)
+			args = append(args, dirPattern(dir))
+		}
+		conds = append(conds, "("+strings.Join(or, " OR ")+")")
+	}
+
+	for i := range query.And {
+		where, andArgs := query.And[i].where()
+		conds = append(conds, "("+where+")")
+		args = append(args, andArgs...)
+	}
+
+	if len(conds) == 0 {
+		return "true", args
+	}
+	return strings.Join(conds, " AND "), args
+}
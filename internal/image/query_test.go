@@ -0,0 +1,123 @@
+package image
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestParseQuery(t *testing.T) {
+	june := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	july := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query string
+		want  Query
+	}{
+		{"", Query{}},
+		{"IMG_4821", Query{Names: []string{"IMG_4821"}}},
+		{"NAME:a b", Query{Names: []string{"a", "b"}}},
+		{`path:"My Photos"`, Query{Paths: []string{"My Photos"}}},
+		{"path:C:/photos", Query{Paths: []string{"C:/photos"}}},
+		{"path:*/2021/*.mp4", Query{Globs: []string{"*/2021/*.mp4"}}},
+		{"after:2021-06 before:2021-07-01", Query{After: june, Before: july}},
+		{"date:2021-06", Query{After: june, Before: july}},
+		{"width:>1920", Query{Width: IntRange{Min: intPtr(1921)}}},
+		{"w:640", Query{Width: IntRange{Min: intPtr(640), Max: intPtr(640)}}},
+		{"height:..2000", Query{Height: IntRange{Max: intPtr(2000)}}},
+		{"orientation:Portrait", Query{Orientations: []string{"portrait"}}},
+		{"type:VIDEO ext:.MP4", Query{Types: []string{"video"}, Extensions: []string{".mp4"}}},
+	}
+	for _, test := range tests {
+		got, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) error = %v", test.query, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", test.query, got, test.want)
+		}
+	}
+
+	for _, query := range []string{
+		"path:",
+		"color:red",
+		`path:"My Photos`,
+		"after:2021-13-01",
+		"width:>wide",
+		"orientation:diagonal",
+	} {
+		if _, err := ParseQuery(query); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ParseQuery(%q) error = %v, want ErrInvalidQuery", query, err)
+		}
+	}
+}
+
+func TestQueryWhere(t *testing.T) {
+	tests := []struct {
+		query Query
+		where string
+		args  []interface{}
+	}{
+		{Query{}, "true", []interface{}{}},
+		{
+			Query{Names: []string{`a"bc`}},
+			`infos.rowid IN (SELECT rowid FROM infos_fts WHERE infos_fts MATCH ?)`,
+			[]interface{}{`filename : "a""bc"`},
+		},
+		{
+			Query{Names: []string{"a_"}},
+			`filename LIKE ? ESCAPE '\'`,
+			[]interface{}{`%a\_%`},
+		},
+		{
+			Query{Width: IntRange{Min: intPtr(10)}, Height: IntRange{Max: intPtr(30)}},
+			`width >= ? AND height <= ?`,
+			[]interface{}{int64(10), int64(30)},
+		},
+		{
+			Query{Orientations: []string{"landscape", "square"}},
+			`(width > height OR width == height)`,
+			[]interface{}{},
+		},
+		{
+			Query{Extensions: []string{".jpg", ".mp4"}},
+			`(filename LIKE ? ESCAPE '\' OR filename LIKE ? ESCAPE '\')`,
+			[]interface{}{"%.jpg", "%.mp4"},
+		},
+	}
+	for _, test := range tests {
+		where, args := test.query.where()
+		if where != test.where || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%+v where() = %q %v, want %q %v", test.query, where, args, test.where, test.args)
+		}
+	}
+}
+
+// Conditions on the dir of the file need the prefix table to be joined
+func TestQueryWherePrefix(t *testing.T) {
+	tests := []struct {
+		query Query
+		where string
+		args  []interface{}
+	}{
+		{Query{Paths: []string{"%"}}, `str LIKE ? ESCAPE '\'`, []interface{}{`%\%%`}},
+		{Query{Globs: []string{"*/2021/*"}}, `lower(str || filename) GLOB lower(?)`, []interface{}{"*/2021/*"}},
+		{Query{Dirs: []string{"photos"}}, `(str LIKE ?)`, []interface{}{dirPattern("photos")}},
+	}
+	for _, test := range tests {
+		where, args := test.query.where()
+		if where != test.where || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%+v where() = %q %v, want %q %v", test.query, where, args, test.where, test.args)
+		}
+		if !test.query.needsPrefix() {
+			t.Errorf("%+v needsPrefix() = false, want true", test.query)
+		}
+	}
+	name := Query{Names: []string{"a"}}
+	if name.needsPrefix() {
+		t.Errorf("needsPrefix() = true for a name, want false")
+	}
+}
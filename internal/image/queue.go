@@ -1,8 +1,10 @@
 package image
 
 import (
+	"context"
 	"log"
 	"photofield/internal/metrics"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -30,6 +32,15 @@ func (source *Source) LoadInfo(path string) (Info, error) {
 func (source *Source) LoadInfoMeta(path string) (Info, error) {
 	var info Info
 	err := source.decoder.DecodeInfo(path, &info)
+	if source.ffmpeg != nil && source.IsSupportedVideo(path) {
+		// Without exiftool, ffprobe is the only source of video metadata
+		probeErr := source.ffmpeg.DecodeInfo(path, &info)
+		if probeErr != nil {
+			log.Printf("Unable to probe video %s: %s\n", path, probeErr.Error())
+		} else {
+			err = nil
+		}
+	}
 	if err != nil {
 		return info, err
 	}
@@ -46,7 +57,7 @@ func (source *Source) LoadInfoColor(path string) (Info, error) {
 	return info, nil
 }
 
-func (source *Source) processQueue(name string, id string, queue *queue.Queue, workerFn func(<-chan ImageId), workerCount int) {
+func (source *Source) processQueue(name string, id string, queue *queue.Queue, workerFn func(<-chan ImageId), slots chan struct{}) {
 
 	loadCount := 0
 	lastLoadCount := 0
@@ -60,13 +71,6 @@ func (source *Source) processQueue(name string, id string, queue *queue.Queue, w
 
 	logging := false
 
-	ids := make(chan ImageId)
-	defer close(ids)
-
-	for i := 0; i < workerCount; i++ {
-		go workerFn(ids)
-	}
-
 	for {
 		id := queue.Pop().(ImageId)
 		if id == 0 {
@@ -74,7 +78,11 @@ func (source *Source) processQueue(name string, id string, queue *queue.Queue, w
 			return
 		}
 
-		ids <- id
+		slots <- struct{}{}
+		go func(id ImageId) {
+			workerFn(idsFromSlice([]ImageId{id}))
+			<-slots
+		}(id)
 		doneCounter.Inc()
 
 		now := time.Now()
@@ -99,3 +107,38 @@ func (source *Source) processQueue(name string, id string, queue *queue.Queue, w
 		}
 	}
 }
+
+// newSlots returns a semaphore allowing up to count ids to be processed at
+// the same time, at least one
+func newSlots(count int) chan struct{} {
+	if count < 1 {
+		count = 1
+	}
+	return make(chan struct{}, count)
+}
+
+// process runs the worker on each of the ids, as many at the same time as
+// there are free slots, until all of them are processed or the context is
+// cancelled. Done is called with each id after it was processed.
+func process(ctx context.Context, ids []ImageId, workerFn func(<-chan ImageId), slots chan struct{}, done func(id ImageId)) error {
+	wg := &sync.WaitGroup{}
+	var err error
+loop:
+	for _, id := range ids {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+		wg.Add(1)
+		go func(id ImageId) {
+			workerFn(idsFromSlice([]ImageId{id}))
+			<-slots
+			done(id)
+			wg.Done()
+		}(id)
+	}
+	wg.Wait()
+	return err
+}
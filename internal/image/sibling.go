@@ -0,0 +1,106 @@
+package image
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SiblingFile is a file paired with a primary file sharing its dir and
+// basename, e.g. the RAW file of a JPEG or the video of a Live Photo
+type SiblingFile struct {
+	Id   ImageId
+	Path string
+}
+
+// PairedFile is an indexed file along with the filename of its current
+// primary file, which is empty if the file is not paired
+type PairedFile struct {
+	Path    string
+	Primary string
+}
+
+// Extensions of the images and videos making up Live Photos
+var livePhotoImageExtensions = []string{".heic", ".heif", ".jpg", ".jpeg"}
+var livePhotoVideoExtensions = []string{".mov"}
+
+// isLivePhoto returns true if the video is the motion clip of the image
+func isLivePhoto(image string, video string) bool {
+	return hasExtension(image, livePhotoImageExtensions) &&
+		hasExtension(video, livePhotoVideoExtensions)
+}
+
+func siblingKey(path string) string {
+	ext := filepath.Ext(path)
+	return strings.ToLower(path[:len(path)-len(ext)])
+}
+
+// FindPrimaries returns the filename of the primary file for each of the
+// files that share their dir and basename with an image, ignoring case.
+// The image is the primary file of RAW files and, for Live Photos, of MOV
+// videos. Groups with more than one regular image are ambiguous, e.g. a JPEG
+// and a PNG, so none of their files are paired. Files that are not paired
+// are not included.
+func (source *Source) FindPrimaries(paths []string) map[string]string {
+	groups := make(map[string][]string)
+	for _, path := range paths {
+		key := siblingKey(path)
+		groups[key] = append(groups[key], path)
+	}
+
+	primaries := make(map[string]string)
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		image := ""
+		images := 0
+		for _, path := range group {
+			if source.IsSupportedImage(path) && !isRaw(path) {
+				image = path
+				images++
+			}
+		}
+		if images != 1 {
+			continue
+		}
+		primary := filepath.Base(image)
+		for _, path := range group {
+			if isRaw(path) || (source.IsSupportedVideo(path) && isLivePhoto(image, path)) {
+				primaries[path] = primary
+			}
+		}
+	}
+	return primaries
+}
+
+// PairSiblings updates the primary files of the files in the dirs, so that
+// layouts only show the primary file of each group of siblings
+func (source *Source) PairSiblings(dirs []string) {
+	for i := range dirs {
+		dirs[i] = filepath.FromSlash(dirs[i])
+	}
+	source.database.WaitForCommit()
+	files := source.database.ListPaired(dirs)
+	paths := make([]string, len(files))
+	for i := range files {
+		paths[i] = files[i].Path
+	}
+	primaries := source.FindPrimaries(paths)
+	for _, file := range files {
+		primary := primaries[file.Path]
+		if primary != file.Primary {
+			source.database.WritePrimary(file.Path, primary)
+		}
+	}
+	source.database.WaitForCommit()
+}
+
+// ListSiblings returns the files paired with the primary file that pass the
+// filter in the dirs, the filter can be nil to list all of them
+func (source *Source) ListSiblings(id ImageId, dirs []string, filter *PathFilter) []SiblingFile {
+	dirs = append([]string(nil), dirs...)
+	for i := range dirs {
+		dirs[i] = filepath.FromSlash(dirs[i])
+	}
+	return source.database.ListSiblings(id, dirs, filter)
+}
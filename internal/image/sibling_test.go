@@ -0,0 +1,31 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindPrimaries(t *testing.T) {
+	source := &Source{}
+	source.Images.Extensions = []string{".jpg", ".jpeg", ".png", ".heic", ".cr2", ".dng"}
+	source.Videos.Extensions = []string{".mp4", ".mov"}
+
+	tests := []struct {
+		paths []string
+		want  map[string]string
+	}{
+		{[]string{"/p/a.jpg"}, map[string]string{}},
+		{[]string{"/p/IMG_1.CR2", "/p/img_1.Jpg"}, map[string]string{"/p/IMG_1.CR2": "img_1.Jpg"}},
+		{[]string{"/p/a.cr2", "/p/a.dng", "/p/a.png"}, map[string]string{"/p/a.cr2": "a.png", "/p/a.dng": "a.png"}},
+		{[]string{"/p/a.heic", "/p/a.mov"}, map[string]string{"/p/a.mov": "a.heic"}},
+		{[]string{"/p/a.png", "/p/a.mov"}, map[string]string{}},
+		{[]string{"/p/a.jpg", "/p/a.mp4"}, map[string]string{}},
+		{[]string{"/p/a.jpg", "/p/a.png", "/p/a.cr2"}, map[string]string{}},
+		{[]string{"/p/a.jpg", "/q/a.cr2", "/p/b.cr2"}, map[string]string{}},
+	}
+	for _, test := range tests {
+		if got := source.FindPrimaries(test.paths); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("FindPrimaries(%v) = %v, want %v", test.paths, got, test.want)
+		}
+	}
+}
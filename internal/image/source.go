@@ -1,12 +1,16 @@
 package image
 
 import (
+	"context"
 	"embed"
 	"errors"
 	"log"
+	"os"
 	"path/filepath"
+	"photofield/internal/metrics"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/dgraph-io/ristretto"
 	"github.com/docker/go-units"
@@ -35,8 +39,13 @@ func (config *CacheConfig) MaxSizeBytes() int64 {
 	return value
 }
 
+type ThumbnailCacheConfig struct {
+	Dir string `json:"dir"`
+}
+
 type Caches struct {
-	Image CacheConfig
+	Image      CacheConfig
+	Thumbnails ThumbnailCacheConfig `json:"thumbnails"`
 }
 
 type Config struct {
@@ -46,6 +55,12 @@ type Config struct {
 	SkipLoadInfo         bool `json:"skip_load_info"`
 	ConcurrentMetaLoads  int  `json:"concurrent_meta_loads"`
 	ConcurrentColorLoads int  `json:"concurrent_color_loads"`
+	ConcurrentHashLoads  int  `json:"concurrent_hash_loads"`
+	ConcurrentThumbnails int  `json:"concurrent_thumbnail_generations"`
+	Watch                bool `json:"watch"`
+
+	FFmpegPath  string `json:"ffmpeg_path"`
+	FFprobePath string `json:"ffprobe_path"`
 
 	ListExtensions []string   `json:"extensions"`
 	DateFormats    []string   `json:"date_formats"`
@@ -62,9 +77,12 @@ type FileConfig struct {
 
 type Source struct {
 	Config
+	// Guards the parts of the config that can be changed with Reconfigure
+	configMutex sync.RWMutex
 
 	decoder  *Decoder
 	database *Database
+	ffmpeg   *FFmpeg
 
 	imageInfoCache  InfoCache
 	imageCache      ImageCache
@@ -76,17 +94,36 @@ type Source struct {
 
 	loadQueueMeta  *queue.Queue
 	loadQueueColor *queue.Queue
+	loadQueueHash  *queue.Queue
+
+	generateQueueThumbnails *queue.Queue
+
+	// Shared by the queues and the tasks doing the same work, so that they
+	// do not exceed the configured concurrency together
+	metaSlots      chan struct{}
+	colorSlots     chan struct{}
+	hashSlots      chan struct{}
+	thumbnailSlots chan struct{}
 }
 
 func NewSource(config Config, migrations embed.FS) *Source {
 	source := Source{}
 	source.Config = config
 	source.decoder = NewDecoder(config.ExifToolCount)
+	source.ffmpeg = NewFFmpeg(config.FFmpegPath, config.FFprobePath)
 	source.database = NewDatabase(config.DatabasePath, migrations)
 	source.imageInfoCache = newInfoCache()
 	source.imageCache = newImageCache(config.Caches)
 	source.fileExistsCache = newFileExistsCache()
 	source.pathCache = newPathCache()
+	source.metaSlots = newSlots(config.ConcurrentMetaLoads)
+	source.colorSlots = newSlots(config.ConcurrentColorLoads)
+	source.hashSlots = newSlots(config.ConcurrentHashLoads)
+	source.thumbnailSlots = newSlots(config.ConcurrentThumbnails)
+
+	for i := range source.Images.Thumbnails {
+		source.Images.Thumbnails[i].CacheDir = config.Caches.Thumbnails.Dir
+	}
 
 	if config.SkipLoadInfo {
 		log.Printf("skipping load info")
@@ -97,7 +134,7 @@ func NewSource(config Config, migrations embed.FS) *Source {
 			"load_meta",
 			source.loadQueueMeta,
 			source.loadInfosMeta,
-			source.ConcurrentMetaLoads,
+			source.metaSlots,
 		)
 
 		source.loadQueueColor = queue.New()
@@ -106,7 +143,25 @@ func NewSource(config Config, migrations embed.FS) *Source {
 			"load_color",
 			source.loadQueueColor,
 			source.loadInfosColor,
-			source.ConcurrentColorLoads,
+			source.colorSlots,
+		)
+
+		source.loadQueueHash = queue.New()
+		go source.processQueue(
+			"load hash",
+			"load_hash",
+			source.loadQueueHash,
+			source.loadInfosHash,
+			source.hashSlots,
+		)
+
+		source.generateQueueThumbnails = queue.New()
+		go source.processQueue(
+			"generate thumbnails",
+			"generate_thumbnails",
+			source.generateQueueThumbnails,
+			source.generateThumbnails,
+			source.thumbnailSlots,
 		)
 	}
 
@@ -121,10 +176,61 @@ func (source *Source) Close() {
 	source.decoder.Close()
 }
 
+// Reconfigure applies the extensions, date formats and thumbnail definitions
+// of the config without a restart. Cached images and thumbnails are dropped if
+// the thumbnails changed, in which case true is returned.
+func (source *Source) Reconfigure(config Config) bool {
+	images := config.Images
+	images.Thumbnails = append([]Thumbnail(nil), images.Thumbnails...)
+	for i := range images.Thumbnails {
+		images.Thumbnails[i].CacheDir = source.Caches.Thumbnails.Dir
+	}
+
+	source.configMutex.Lock()
+	thumbnailsChanged := !thumbnailsEqual(source.Images.Thumbnails, images.Thumbnails) ||
+		!thumbnailsEqual(source.Videos.Thumbnails, config.Videos.Thumbnails)
+	source.ListExtensions = config.ListExtensions
+	source.DateFormats = config.DateFormats
+	source.Images = images
+	source.Videos = config.Videos
+	source.configMutex.Unlock()
+
+	if thumbnailsChanged {
+		log.Println("thumbnails changed, clearing caches")
+		source.imageCache.Clear()
+		source.fileExistsCache.Clear()
+	}
+	return thumbnailsChanged
+}
+
+func (source *Source) GetImages() FileConfig {
+	source.configMutex.RLock()
+	defer source.configMutex.RUnlock()
+	return source.Images
+}
+
+func (source *Source) GetVideos() FileConfig {
+	source.configMutex.RLock()
+	defer source.configMutex.RUnlock()
+	return source.Videos
+}
+
+func (source *Source) GetListExtensions() []string {
+	source.configMutex.RLock()
+	defer source.configMutex.RUnlock()
+	return source.ListExtensions
+}
+
+func (source *Source) GetDateFormats() []string {
+	source.configMutex.RLock()
+	defer source.configMutex.RUnlock()
+	return source.DateFormats
+}
+
 func (source *Source) IsSupportedImage(path string) bool {
 	supportedImage := false
 	pathExt := strings.ToLower(filepath.Ext(path))
-	for _, ext := range source.Images.Extensions {
+	for _, ext := range source.GetImages().Extensions {
 		if pathExt == ext {
 			supportedImage = true
 			break
@@ -135,7 +241,7 @@ func (source *Source) IsSupportedImage(path string) bool {
 
 func (source *Source) IsSupportedVideo(path string) bool {
 	pathExt := strings.ToLower(filepath.Ext(path))
-	for _, ext := range source.Videos.Extensions {
+	for _, ext := range source.GetVideos().Extensions {
 		if pathExt == ext {
 			return true
 		}
@@ -150,17 +256,132 @@ func (source *Source) ListImages(dirs []string, maxPhotos int) <-chan string {
 	return source.database.ListPaths(dirs, maxPhotos)
 }
 
-func (source *Source) ListImageIds(dirs []string, maxPhotos int) <-chan ImageId {
+func (source *Source) ListImageIds(dirs []string, maxPhotos int, filter *PathFilter) <-chan ImageId {
 	for i := range dirs {
 		dirs[i] = filepath.FromSlash(dirs[i])
 	}
-	return source.database.ListIds(dirs, maxPhotos)
+	return source.database.ListIds(dirs, maxPhotos, filter)
+}
+
+func (source *Source) ListImageIdsWithoutHash(dirs []string, filter *PathFilter) <-chan ImageId {
+	for i := range dirs {
+		dirs[i] = filepath.FromSlash(dirs[i])
+	}
+	return source.database.ListIdsWithoutHash(dirs, filter)
+}
+
+// ListFileStats returns the size and modification time of the files in the
+// dirs as of the last time they were indexed
+func (source *Source) ListFileStats(dirs []string) map[ImageId]FileStat {
+	for i := range dirs {
+		dirs[i] = filepath.FromSlash(dirs[i])
+	}
+	return source.database.ListIdStats(dirs)
+}
+
+// ListDuplicates returns groups of files in the dirs that look the same or
+// similar, see FindDuplicates
+func (source *Source) ListDuplicates(dirs []string, maxDistance int, filter *PathFilter) [][]DuplicateFile {
+	for i := range dirs {
+		dirs[i] = filepath.FromSlash(dirs[i])
+	}
+	files := source.database.ListHashes(dirs, filter)
+	defer metrics.Elapsed("find duplicates")()
+	return FindDuplicates(files, maxDistance)
+}
+
+func (source *Source) WriteScene(scene StoredScene) error {
+	return source.database.WriteScene(scene)
+}
+
+func (source *Source) DeleteScene(id string) error {
+	return source.database.DeleteScene(id)
+}
+
+func (source *Source) ListScenes() ([]StoredScene, error) {
+	return source.database.ListScenes()
+}
+
+func (source *Source) WriteCollection(id string, config string) error {
+	return source.database.WriteCollection(id, config)
+}
+
+func (source *Source) DeleteCollection(id string) error {
+	return source.database.DeleteCollection(id)
+}
+
+func (source *Source) ListCollections() ([]string, error) {
+	return source.database.ListCollections()
+}
+
+func (source *Source) WriteTask(task StoredTask) error {
+	return source.database.WriteTask(task)
+}
+
+func (source *Source) DeleteTask(id string) error {
+	return source.database.DeleteTask(id)
+}
+
+func (source *Source) ListTasks() ([]StoredTask, error) {
+	return source.database.ListTasks()
+}
+
+func (source *Source) WriteUser(user StoredUser) error {
+	return source.database.WriteUser(user)
+}
+
+func (source *Source) GetUser(name string) (StoredUser, bool, error) {
+	return source.database.GetUser(name)
+}
+
+func (source *Source) DeleteUser(name string) (bool, error) {
+	return source.database.DeleteUser(name)
+}
+
+func (source *Source) ListUsers() ([]StoredUser, error) {
+	return source.database.ListUsers()
+}
+
+func (source *Source) WriteSession(tokenHash string, userId int64, expiresAt time.Time) error {
+	return source.database.WriteSession(tokenHash, userId, expiresAt)
+}
+
+func (source *Source) GetSessionUser(tokenHash string, now time.Time) (StoredUser, bool, error) {
+	return source.database.GetSessionUser(tokenHash, now)
+}
+
+func (source *Source) DeleteSession(tokenHash string) error {
+	return source.database.DeleteSession(tokenHash)
+}
+
+func (source *Source) DeleteExpiredSessions(now time.Time) error {
+	return source.database.DeleteExpiredSessions(now)
+}
+
+func (source *Source) WriteToken(token StoredToken) (int64, error) {
+	return source.database.WriteToken(token)
+}
+
+func (source *Source) GetTokenUser(tokenHash string) (StoredUser, bool, error) {
+	return source.database.GetTokenUser(tokenHash)
+}
+
+func (source *Source) ListTokens(userId int64) ([]StoredToken, error) {
+	return source.database.ListTokens(userId)
+}
+
+func (source *Source) DeleteToken(userId int64, id int64) (bool, error) {
+	return source.database.DeleteToken(userId, id)
 }
 
 func (source *Source) ListInfos(dirs []string, options ListOptions) <-chan SourcedInfo {
 	for i := range dirs {
 		dirs[i] = filepath.FromSlash(dirs[i])
 	}
+	if options.Query != nil {
+		query := source.resolveQuery(*options.Query)
+		options.Query = &query
+	}
 	out := make(chan SourcedInfo, 10000)
 	go func() {
 		infos := source.database.List(dirs, options)
@@ -175,6 +396,36 @@ func (source *Source) ListInfos(dirs []string, options ListOptions) <-chan Sourc
 	return out
 }
 
+// resolveQuery returns a copy of the query with the file types expanded to
+// the configured extensions
+func (source *Source) resolveQuery(query Query) Query {
+	dirs := make([]string, len(query.Dirs))
+	for i := range query.Dirs {
+		dirs[i] = filepath.FromSlash(query.Dirs[i])
+	}
+	query.Dirs = dirs
+	extensions := append([]string{}, query.Extensions...)
+	for _, t := range query.Types {
+		switch t {
+		case "image":
+			extensions = append(extensions, source.GetImages().Extensions...)
+		case "video":
+			extensions = append(extensions, source.GetVideos().Extensions...)
+		}
+	}
+	query.Extensions = extensions
+	and := make([]Query, len(query.And))
+	for i := range query.And {
+		and[i] = source.resolveQuery(query.And[i])
+	}
+	query.And = and
+	return query
+}
+
+func (source *Source) Search(query Query, limit int) ([]SearchResult, error) {
+	return source.database.Search(source.resolveQuery(query), limit)
+}
+
 // Prefer using ImageId over this unless you absolutely need the path
 func (source *Source) GetImagePath(id ImageId) (string, error) {
 	path, ok := source.pathCache.Get(id)
@@ -190,19 +441,80 @@ func (source *Source) GetImagePath(id ImageId) (string, error) {
 	return path, nil
 }
 
-func (source *Source) IndexImages(dir string, maxPhotos int, counter chan<- int) {
+// IndexImages adds new files in the dir to the database, removes the ones that
+// no longer exist and reloads the info of files whose size or modification
+// time changed since they were last indexed. Files not passing the filter are
+// skipped, but kept in the database if they were indexed before. If the
+// context is cancelled, nothing is removed and the dir is not marked as
+// indexed.
+func (source *Source) IndexImages(ctx context.Context, dir string, filter *PathFilter, maxPhotos int, counter chan<- int) error {
 	dir = filepath.FromSlash(dir)
+	stats := source.database.ListStats([]string{dir})
 	indexed := make(map[string]struct{})
-	for path := range walkFiles(dir, source.ListExtensions, maxPhotos) {
-		source.database.Write(path, Info{}, AppendPath)
+	changed := make([]string, 0)
+	for path := range walkFiles(ctx, dir, source.GetListExtensions(), filter, maxPhotos) {
 		indexed[path] = struct{}{}
 		// Uncomment to test slow indexing
 		// time.Sleep(10 * time.Millisecond)
 		counter <- 1
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Unable to stat %s: %s\n", path, err.Error())
+			continue
+		}
+		stat := NewFileStat(fileInfo)
+		stored, exists := stats[path]
+		if !exists {
+			source.database.Write(path, Info{}, AppendPath)
+			source.database.WriteStat(path, stat)
+			changed = append(changed, path)
+			continue
+		}
+		if stored.Equal(stat) {
+			continue
+		}
+		source.database.WriteStat(path, stat)
+		// Files indexed before stats were stored are assumed unchanged
+		if !stored.IsZero() {
+			changed = append(changed, path)
+		}
 	}
-	source.database.DeleteNonexistent(dir, indexed)
+	if ctx.Err() != nil {
+		source.database.WaitForCommit()
+		log.Printf("indexing %s cancelled, %d files, %d new or changed\n", dir, len(indexed), len(changed))
+		source.reload(changed)
+		return ctx.Err()
+	}
+	source.database.DeleteNonexistent(dir, indexed, filter)
 	source.database.SetIndexed(dir)
-	source.database.WaitForCommit()
+	source.PairSiblings([]string{dir})
+	log.Printf("indexed %s, %d files, %d new or changed\n", dir, len(indexed), len(changed))
+	source.reload(changed)
+	return nil
+}
+
+// reload drops cached data of the files and queues loading their info again,
+// previously generated thumbnails are generated again as well
+func (source *Source) reload(paths []string) {
+	ids := make([]ImageId, 0, len(paths))
+	regenerate := make([]ImageId, 0)
+	for _, path := range paths {
+		source.fileExistsCache.Del(path)
+		source.imageCache.Delete(path)
+		removed := source.removeGeneratedThumbnails(path)
+		id, ok := source.database.GetIdFromPath(path)
+		if !ok {
+			continue
+		}
+		source.imageInfoCache.Delete(id)
+		ids = append(ids, id)
+		if removed {
+			regenerate = append(regenerate, id)
+		}
+	}
+	source.QueueMetaLoads(idsFromSlice(ids))
+	source.QueueColorLoads(idsFromSlice(ids))
+	source.QueueThumbnailGenerations(idsFromSlice(regenerate))
 }
 
 func (source *Source) GetDir(dir string) Info {
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -19,6 +20,7 @@ func (source *Source) loadInfosMeta(ids <-chan ImageId) {
 		info, err := source.LoadInfoMeta(path)
 		if err != nil {
 			fmt.Println("Unable to load image info meta", err, path)
+			source.settleInfo(id)
 			continue
 		}
 		source.database.Write(path, info, UpdateMeta)
@@ -36,6 +38,7 @@ func (source *Source) loadInfosColor(ids <-chan ImageId) {
 		info, err := source.LoadInfoColor(path)
 		if err != nil {
 			fmt.Println("Unable to load image info color", err, path)
+			source.settleInfo(id)
 			continue
 		}
 		source.database.Write(path, info, UpdateColor)
@@ -43,6 +46,31 @@ func (source *Source) loadInfosColor(ids <-chan ImageId) {
 	}
 }
 
+// settleInfo keeps the guessed info of a file that failed to load, but no
+// longer as a placeholder, as loading it again would fail the same way
+func (source *Source) settleInfo(id ImageId) {
+	if info, found := source.imageInfoCache.Get(id); found {
+		info.Placeholder = false
+		source.imageInfoCache.Set(id, info)
+	}
+}
+
+func (source *Source) loadInfosHash(ids <-chan ImageId) {
+	for id := range ids {
+		path, err := source.GetImagePath(id)
+		if err != nil {
+			fmt.Println("Unable to find image path", err, path)
+			continue
+		}
+		hash, err := source.LoadImageHash(path)
+		if err != nil {
+			fmt.Println("Unable to load image hash", err, path)
+			continue
+		}
+		source.database.WriteHash(path, hash)
+	}
+}
+
 func (source *Source) QueueMetaLoads(ids <-chan ImageId) {
 	if source.loadQueueMeta != nil {
 		for id := range ids {
@@ -59,6 +87,28 @@ func (source *Source) QueueColorLoads(ids <-chan ImageId) {
 	}
 }
 
+func (source *Source) QueueHashLoads(ids <-chan ImageId) {
+	if source.loadQueueHash != nil {
+		for id := range ids {
+			source.loadQueueHash.Append(id)
+		}
+	}
+}
+
+// ProcessMetaLoads loads the metadata of the ids right away instead of
+// queueing them, stopping early if the context is cancelled
+func (source *Source) ProcessMetaLoads(ctx context.Context, ids []ImageId, done func(id ImageId)) error {
+	return process(ctx, ids, source.loadInfosMeta, source.metaSlots, done)
+}
+
+func (source *Source) ProcessColorLoads(ctx context.Context, ids []ImageId, done func(id ImageId)) error {
+	return process(ctx, ids, source.loadInfosColor, source.colorSlots, done)
+}
+
+func (source *Source) ProcessHashLoads(ctx context.Context, ids []ImageId, done func(id ImageId)) error {
+	return process(ctx, ids, source.loadInfosHash, source.hashSlots, done)
+}
+
 func (source *Source) heuristicFromPath(path string) (Info, error) {
 	var info Info
 
@@ -69,7 +119,7 @@ func (source *Source) heuristicFromPath(path string) (Info, error) {
 	baseName := filepath.Base(path)
 	name := strings.TrimSuffix(baseName, filepath.Ext(baseName))
 
-	for _, format := range source.DateFormats {
+	for _, format := range source.GetDateFormats() {
 		date, err := time.Parse(format, name)
 		if err == nil {
 			info.DateTime = date
@@ -108,37 +158,11 @@ func (source *Source) GetInfo(id ImageId) Info {
 	info = result.Info
 	dbGetMs := time.Since(startTime).Milliseconds()
 	needsMeta := result.NeedsMeta()
-	if found && !needsMeta {
-		startTime = time.Now()
-		source.imageInfoCache.Set(id, info)
-		cacheSetMs := time.Since(startTime).Milliseconds()
-		if logging {
-			log.Printf("image info %5d ms get cache, %5d ms get db, %5d ms set cache\n", cacheGetMs, dbGetMs, cacheSetMs)
-		}
-	}
-
-	startTime = time.Now()
 	needsColor := result.NeedsColor()
-	if needsMeta || needsColor {
-		if needsMeta {
-			if source.loadQueueMeta != nil {
-				source.loadQueueMeta.Append(id)
-			}
-		}
-		if needsColor {
-			if source.loadQueueColor != nil {
-				source.loadQueueColor.Append(id)
-			}
-		}
-	}
-	addPendingMs := time.Since(startTime).Milliseconds()
-
-	if found && !needsMeta {
-		return info
-	}
+	info.Placeholder = needsColor
 
 	startTime = time.Now()
-	{
+	if !found || needsMeta {
 		path, err := source.GetImagePath(id)
 		if err == nil {
 			info, err = source.heuristicFromPath(path)
@@ -148,13 +172,31 @@ func (source *Source) GetInfo(id ImageId) Info {
 		} else {
 			fmt.Println("Unable to get path from image id", err, id)
 		}
+		info.Placeholder = true
 	}
 	heuristicGetMs := time.Since(startTime).Milliseconds()
 
+	// Cached before the loads are queued, so that loads failing right away
+	// can settle the placeholder
 	startTime = time.Now()
 	source.imageInfoCache.Set(id, info)
 	cacheSetMs := time.Since(startTime).Milliseconds()
 
+	startTime = time.Now()
+	if needsMeta || needsColor {
+		if needsMeta {
+			if source.loadQueueMeta != nil {
+				source.loadQueueMeta.Append(id)
+			}
+		}
+		if needsColor {
+			if source.loadQueueColor != nil {
+				source.loadQueueColor.Append(id)
+			}
+		}
+	}
+	addPendingMs := time.Since(startTime).Milliseconds()
+
 	totalMs := time.Since(totalStartTime).Milliseconds()
 
 	logging = totalMs > 1000
@@ -2,6 +2,9 @@ package image
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
 	"image"
 	"math"
 	"path/filepath"
@@ -11,6 +14,13 @@ import (
 type PhotoTemplateData struct {
 	Dir      string
 	Filename string
+	CacheDir string
+}
+
+// PathHash returns a hex encoded hash of the original path, used to derive
+// unique paths for generated thumbnails
+func (data PhotoTemplateData) PathHash() string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(data.Dir+data.Filename)))
 }
 
 type Thumbnail struct {
@@ -20,6 +30,10 @@ type Thumbnail struct {
 
 	Exif string `json:"exif"`
 
+	// Generated by photofield at the path instead of by a third party
+	Generate bool   `json:"generate"`
+	CacheDir string `json:"-"`
+
 	SizeTypeRaw string `json:"fit"`
 	SizeType    ThumbnailSizeType
 
@@ -36,17 +50,45 @@ const (
 	OriginalSize ThumbnailSizeType = iota
 )
 
-func (thumbnail *Thumbnail) Init() {
+// Equal returns true if the thumbnails are configured the same way
+func (thumbnail *Thumbnail) Equal(other *Thumbnail) bool {
+	return thumbnail.Name == other.Name &&
+		thumbnail.PathTemplateRaw == other.PathTemplateRaw &&
+		thumbnail.Exif == other.Exif &&
+		thumbnail.Generate == other.Generate &&
+		thumbnail.SizeTypeRaw == other.SizeTypeRaw &&
+		thumbnail.Width == other.Width &&
+		thumbnail.Height == other.Height &&
+		thumbnail.ExtraCost == other.ExtraCost
+}
+
+func thumbnailsEqual(a []Thumbnail, b []Thumbnail) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(&b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (thumbnail *Thumbnail) Init() error {
 	if thumbnail.PathTemplateRaw != "" {
 		var err error
 		thumbnail.PathTemplate, err = template.New("").Parse(thumbnail.PathTemplateRaw)
 		if err != nil {
-			panic(err)
+			return err
 		}
 	} else if thumbnail.Exif != "" {
 		// No setup required
 	} else {
-		panic("thumbnail path or exif name must be specified")
+		return errors.New("thumbnail path or exif name must be specified")
+	}
+
+	if thumbnail.Generate && thumbnail.PathTemplate == nil {
+		return errors.New("generated thumbnail path must be specified")
 	}
 
 	switch thumbnail.SizeTypeRaw {
@@ -57,8 +99,13 @@ func (thumbnail *Thumbnail) Init() {
 	case "ORIGINAL":
 		thumbnail.SizeType = OriginalSize
 	default:
-		panic("Unsupported thumbnail fit: " + thumbnail.SizeTypeRaw)
+		return errors.New("Unsupported thumbnail fit: " + thumbnail.SizeTypeRaw)
+	}
+
+	if thumbnail.Generate && thumbnail.SizeType == OriginalSize {
+		return errors.New("Unsupported generated thumbnail fit: " + thumbnail.SizeTypeRaw)
 	}
+	return nil
 }
 
 func (thumbnail *Thumbnail) GetPath(originalPath string) string {
@@ -70,6 +117,7 @@ func (thumbnail *Thumbnail) GetPath(originalPath string) string {
 	err := thumbnail.PathTemplate.Execute(&rendered, PhotoTemplateData{
 		Dir:      dir,
 		Filename: filename,
+		CacheDir: thumbnail.CacheDir,
 	})
 	if err != nil {
 		panic(err)
@@ -0,0 +1,281 @@
+package image
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/karrick/godirwalk"
+)
+
+// WatchedDir is a dir to watch for changes along with the filter of the
+// files to index in it, nil to index all of them
+type WatchedDir struct {
+	Dir    string
+	Filter *PathFilter
+}
+
+// Watcher follows the file system for changes in the watched dirs and keeps
+// the database up to date without requiring a full re-index.
+type Watcher struct {
+	// Called with the dirs of changed files after the changes were committed
+	onChange func(dirs []string)
+
+	source  *Source
+	watcher *fsnotify.Watcher
+	roots   []WatchedDir
+
+	dirs      map[string]struct{}
+	dirsMutex sync.Mutex
+
+	pending      map[string]struct{}
+	pendingMutex sync.Mutex
+	flushDelay   time.Duration
+	flushTimer   *time.Timer
+}
+
+// NewWatcher starts watching the dirs and their subdirs, onChange is called
+// with the dirs of changed files after the changes were committed. Files are
+// only indexed if they pass the filter of any of the dirs they are in.
+func (source *Source) NewWatcher(dirs []WatchedDir, onChange func(dirs []string)) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	watcher := &Watcher{
+		onChange:   onChange,
+		source:     source,
+		watcher:    fsw,
+		roots:      make([]WatchedDir, 0, len(dirs)),
+		dirs:       make(map[string]struct{}),
+		pending:    make(map[string]struct{}),
+		flushDelay: 2 * time.Second,
+	}
+	for _, dir := range dirs {
+		watcher.roots = append(watcher.roots, WatchedDir{
+			Dir:    filepath.Clean(filepath.FromSlash(dir.Dir)),
+			Filter: dir.Filter,
+		})
+	}
+	for _, root := range watcher.roots {
+		watcher.addRecursive(root.Dir)
+	}
+	log.Printf("watching %d dirs for changes\n", watcher.dirCount())
+	go watcher.run()
+	return watcher, nil
+}
+
+// Close stops watching for changes, changes that are pending are dropped
+func (watcher *Watcher) Close() error {
+	watcher.pendingMutex.Lock()
+	if watcher.flushTimer != nil {
+		watcher.flushTimer.Stop()
+		watcher.flushTimer = nil
+	}
+	watcher.pending = make(map[string]struct{})
+	watcher.pendingMutex.Unlock()
+	return watcher.watcher.Close()
+}
+
+func (watcher *Watcher) dirCount() int {
+	watcher.dirsMutex.Lock()
+	defer watcher.dirsMutex.Unlock()
+	return len(watcher.dirs)
+}
+
+// match returns true if the file passes the filter of any of the watched dirs
+// it is in
+func (watcher *Watcher) match(path string) bool {
+	for _, root := range watcher.roots {
+		if isInDir(root.Dir, path) && root.Filter.Match(root.Dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDir returns true if any files in the subdir can pass the filter of any
+// of the watched dirs it is in
+func (watcher *Watcher) matchDir(subdir string) bool {
+	for _, root := range watcher.roots {
+		if subdir == root.Dir {
+			return true
+		}
+		if isInDir(root.Dir, subdir) && root.Filter.MatchDir(root.Dir, subdir) {
+			return true
+		}
+	}
+	return false
+}
+
+func isInDir(dir string, path string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func (watcher *Watcher) addDir(dir string) {
+	watcher.dirsMutex.Lock()
+	_, exists := watcher.dirs[dir]
+	watcher.dirs[dir] = struct{}{}
+	watcher.dirsMutex.Unlock()
+	if exists {
+		return
+	}
+	if err := watcher.watcher.Add(dir); err != nil {
+		log.Printf("unable to watch %s: %s\n", dir, err.Error())
+	}
+}
+
+func (watcher *Watcher) removeDir(dir string) bool {
+	watcher.dirsMutex.Lock()
+	defer watcher.dirsMutex.Unlock()
+	prefix := dir + string(filepath.Separator)
+	removed := false
+	for watched := range watcher.dirs {
+		if watched == dir || strings.HasPrefix(watched, prefix) {
+			delete(watcher.dirs, watched)
+			removed = true
+		}
+	}
+	return removed
+}
+
+func (watcher *Watcher) addRecursive(dir string) {
+	err := godirwalk.Walk(dir, &godirwalk.Options{
+		Unsorted: true,
+		Callback: func(path string, dirent *godirwalk.Dirent) error {
+			if !dirent.IsDir() {
+				return nil
+			}
+			if strings.Contains(path, "@eaDir") || !watcher.matchDir(path) {
+				return filepath.SkipDir
+			}
+			watcher.addDir(path)
+			return nil
+		},
+	})
+	if err != nil {
+		log.Printf("unable to watch %s: %s\n", dir, err.Error())
+	}
+}
+
+func (watcher *Watcher) run() {
+	for {
+		select {
+		case event, ok := <-watcher.watcher.Events:
+			if !ok {
+				return
+			}
+			watcher.handle(event)
+		case err, ok := <-watcher.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("watcher error: %s\n", err.Error())
+		}
+	}
+}
+
+func (watcher *Watcher) handle(event fsnotify.Event) {
+	path := event.Name
+	if strings.Contains(path, "@eaDir") {
+		return
+	}
+
+	switch {
+	case event.Op&fsnotify.Create == fsnotify.Create:
+		stat, err := os.Stat(path)
+		if err != nil {
+			return
+		}
+		if stat.IsDir() {
+			// Files moved in together with the dir don't emit their own events
+			watcher.addRecursive(path)
+			for file := range walkFiles(context.Background(), path, watcher.source.GetListExtensions(), nil, 0) {
+				watcher.queue(file)
+			}
+			return
+		}
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		if watcher.removeDir(path) {
+			for file := range watcher.source.database.ListPaths([]string{path + string(filepath.Separator)}, 0) {
+				watcher.queue(file)
+			}
+			return
+		}
+	case event.Op&fsnotify.Write == fsnotify.Write:
+	default:
+		return
+	}
+
+	if !hasExtension(path, watcher.source.GetListExtensions()) {
+		return
+	}
+	watcher.queue(path)
+}
+
+func (watcher *Watcher) queue(path string) {
+	watcher.pendingMutex.Lock()
+	defer watcher.pendingMutex.Unlock()
+	watcher.pending[path] = struct{}{}
+	if watcher.flushTimer == nil {
+		watcher.flushTimer = time.AfterFunc(watcher.flushDelay, watcher.flush)
+	} else {
+		// Wait for writes to settle, e.g. while a large file is being copied
+		watcher.flushTimer.Reset(watcher.flushDelay)
+	}
+}
+
+func (watcher *Watcher) flush() {
+	watcher.pendingMutex.Lock()
+	pending := watcher.pending
+	watcher.pending = make(map[string]struct{})
+	watcher.flushTimer = nil
+	watcher.pendingMutex.Unlock()
+
+	source := watcher.source
+	updated := make([]string, 0, len(pending))
+	dirs := make(map[string]struct{})
+	for path := range pending {
+		// The last event is not reliable on its own, e.g. a file might have
+		// been removed and recreated in the meantime
+		// Files excluded by the filters are removed in case they were indexed
+		// before, e.g. a file renamed to an excluded name
+		if fileInfo, err := os.Stat(path); err == nil && watcher.match(path) {
+			source.database.Write(path, Info{}, AppendPath)
+			source.database.WriteStat(path, NewFileStat(fileInfo))
+			updated = append(updated, path)
+		} else {
+			source.database.Write(path, Info{}, Delete)
+			source.fileExistsCache.Del(path)
+			source.imageCache.Delete(path)
+		}
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+	changed := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		changed = append(changed, dir)
+	}
+	// Adding or removing a file can pair or unpair its siblings
+	source.PairSiblings(changed)
+	source.reload(updated)
+
+	log.Printf("watcher %d files updated, %d removed\n", len(updated), len(pending)-len(updated))
+
+	if watcher.onChange != nil {
+		watcher.onChange(changed)
+	}
+}
+
+func idsFromSlice(ids []ImageId) <-chan ImageId {
+	out := make(chan ImageId, len(ids))
+	for _, id := range ids {
+		out <- id
+	}
+	close(out)
+	return out
+}
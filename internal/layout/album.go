@@ -78,8 +78,9 @@ func LayoutAlbum(layout Layout, collection collection.Collection, scene *render.
 	limit := collection.Limit
 
 	infos := collection.GetInfos(source, image.ListOptions{
-		OrderBy: image.DateAsc,
-		Limit:   limit,
+		OrderBy:     image.DateAsc,
+		Limit:       limit,
+		PrimaryOnly: true,
 	})
 
 	layout.ImageSpacing = 0.02 * layout.ImageHeight
@@ -154,7 +155,9 @@ func LayoutAlbum(layout Layout, collection collection.Collection, scene *render.
 
 	scene.Bounds.H = rect.Y + sceneMargin
 	scene.RegionSource = PhotoRegionSource{
-		Source: source,
+		Source:     source,
+		Dirs:       collection.Dirs,
+		PathFilter: collection.GetPathFilter(),
 	}
 
 }
@@ -3,9 +3,13 @@ package layout
 import (
 	"image/color"
 	"log"
+	"photofield/internal/collection"
 	"photofield/internal/image"
+	"photofield/internal/metrics"
 	"photofield/internal/render"
 	"time"
+
+	"github.com/tdewolff/canvas"
 )
 
 type Hour struct {
@@ -15,16 +19,15 @@ type Hour struct {
 }
 
 type Day struct {
-	Section
 	Number int
 	Bounds render.Rect
 	Hours  map[int]*Hour
 }
 
 type Week struct {
-	Number int
+	Start  time.Time
 	Bounds render.Rect
-	Days   map[int]Day
+	Days   map[int]*Day
 }
 
 func getColumnBounds(value int, total int, spacing float64, bounds render.Rect) render.Rect {
@@ -45,180 +48,264 @@ func getRowBounds(value int, total int, spacing float64, bounds render.Rect) ren
 	}
 }
 
-func LayoutCalendar(config *render.Render, scene *render.Scene, source *image.Source) {
-
-	// imageWidth := 120.
-	photoCount := len(scene.Photos)
-
-	// sort.Slice(scene.Photos, func(i, j int) bool {
-	// 	a := source.GetInfo(scene.Photos[i].GetPath(source))
-	// 	b := source.GetInfo(scene.Photos[i].GetPath(source))
-	// 	return a.DateTime.Before(b.DateTime)
-	// })
-
-	// imageWidth := 200.
-	// imageHeight := 160.
-	imageHeight := 300.
-	// imageHeight := 50.
-	// imageSpacing := 6.
-	// lineSpacing := 6.
-	sceneMargin := 10.
-
-	scene.Bounds.W = 2000
+// Returns the local date of the Monday starting the week of the provided time
+func getWeekStart(t time.Time) time.Time {
+	year, month, day := t.Date()
+	weekday := (int(t.Weekday()) + 6) % 7
+	return time.Date(year, month, day-weekday, 0, 0, 0, 0, time.UTC)
+}
 
-	// cols := int(scene.size.width/(imageWidth+margin)) - 2
+func getAspectRatio(info image.SourcedInfo) float64 {
+	if info.Width <= 0 || info.Height <= 0 {
+		return 1
+	}
+	return float64(info.Width) / float64(info.Height)
+}
 
-	log.Println("layout")
-	lastLogTime := time.Now()
+// Scales the photos down to fit into a single row of the hour, keeping them
+// at full hour height if there is enough space.
+func layoutCalendarHour(hour *Hour, scene *render.Scene, spacing float64) {
+	totalWidth := 0.
+	for _, info := range hour.infos {
+		totalWidth += hour.Bounds.H * getAspectRatio(info)
+	}
+	totalSpacing := float64(len(hour.infos)-1) * spacing
+	scale := 1.
+	if totalWidth+totalSpacing > hour.Bounds.W {
+		scale = (hour.Bounds.W - totalSpacing) / totalWidth
+	}
+	x := hour.Bounds.X
+	for _, info := range hour.infos {
+		height := hour.Bounds.H * scale
+		width := height * getAspectRatio(info)
+		scene.Photos = append(scene.Photos, render.Photo{
+			Id: info.Id,
+			Sprite: render.Sprite{
+				Rect: render.Rect{
+					X: x,
+					Y: hour.Bounds.Y + (hour.Bounds.H-height)*0.5,
+					W: width,
+					H: height,
+				},
+			},
+		})
+		x += width + spacing
+	}
+}
 
-	// weeks := make(map[int]Week)
+func LayoutCalendar(layout Layout, collection collection.Collection, scene *render.Scene, source *image.Source) {
 
-	// var lastHour *Hour
+	infos := collection.GetInfos(source, image.ListOptions{
+		OrderBy:     image.DateAsc,
+		Limit:       collection.Limit,
+		PrimaryOnly: true,
+	})
 
-	var week *Week
-	var day *Day
-	var hour *Hour
+	sceneMargin := 10.
+	daySpacing := 20.
+	weekSpacing := 4.
+
+	scene.Bounds.W = layout.SceneWidth
+	rowWidth := scene.Bounds.W - sceneMargin*2
+
+	// Days are roughly square, with the top reserved for the day number and
+	// the rest split into 24 hours
+	weekHeight := rowWidth / 7
+	dayHeaderHeight := weekHeight * 0.12
+	hourIndent := weekHeight * 0.12
+	hourHeight := (weekHeight - dayHeaderHeight) / 24
+
+	yearFont := scene.Fonts.Main.Face(70, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+	monthFont := scene.Fonts.Main.Face(50, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+	dayFont := scene.Fonts.Main.Face(dayHeaderHeight*2.5, canvas.Gray, canvas.FontRegular, canvas.FontNormal)
+	hourFont := scene.Fonts.Main.Face(hourHeight*2, canvas.Gray, canvas.FontRegular, canvas.FontNormal)
+
+	loadCounter := metrics.Counter{
+		Name:     "load infos",
+		Interval: 1 * time.Second,
+	}
 
-	// x := sceneMargin
-	y := sceneMargin
-	for i := range scene.Photos {
-		photo := &scene.Photos[i]
-		info := source.GetInfo(scene.Photos[i].Id)
-		dateTime := info.DateTime
+	weeks := make(map[time.Time]*Week)
+	var first, last time.Time
+	undated := Section{}
 
-		_, weekNum := info.DateTime.ISOWeek()
+	index := 0
+	for info := range infos {
+		loadCounter.Set(index)
+		index++
 
-		// lastWeek := week
+		if info.DateTime.Year() <= 1 {
+			undated.infos = append(undated.infos, info)
+			continue
+		}
 
-		if week == nil || week.Number != weekNum {
+		weekStart := getWeekStart(info.DateTime)
+		week, ok := weeks[weekStart]
+		if !ok {
 			week = &Week{
-				Number: weekNum,
-				Bounds: getColumnBounds(weekNum, 1, 30, render.Rect{
-					X: sceneMargin,
-					Y: sceneMargin,
-					W: scene.Bounds.W - sceneMargin*2,
-					H: imageHeight,
-				}),
-				// Bounds: render.Rect{
-				// 	X: sceneMargin,
-				// 	Y: -1,
-				// 	W: scene.Bounds.Width - sceneMargin*2,
-				// 	H: imageHeight,
-				// },
+				Start: weekStart,
+				Days:  make(map[int]*Day),
 			}
-			// y += imageHeight
-			// scene.Solids = append(scene.Solids, NewSolidFromRect(week.Bounds, color.Gray{Y: 0xFA}))
-			// scene.Texts = append(scene.Texts, NewHeaderFromRect(week.Bounds, &headerFont,
-			// 	fmt.Sprintf("CW %d", weekNum),
-			// ))
+			weeks[weekStart] = week
+		}
+		if first.IsZero() || weekStart.Before(first) {
+			first = weekStart
+		}
+		if last.IsZero() || weekStart.After(last) {
+			last = weekStart
 		}
 
-		dayNum := int(dateTime.Day())
-		if day == nil || day.Number != dayNum {
-			weekdayNum := int(dateTime.Weekday()+6) % 7
-
-			// if day != nil {
-			// 	sectionEnd := layoutSection(&day.Section, day.Bounds, 20, source)
-			// 	if sectionEnd.Y > lastWeek.Bounds.Y+lastWeek.Bounds.H {
-			// 		lastWeek.Bounds.H = sectionEnd.Y - lastWeek.Bounds.Y
-			// 	}
-			// }
-			// if week.Bounds.Y == -1 {
-			// 	if lastWeek != nil {
-			// 		week.Bounds.Y = lastWeek.Bounds.Y + lastWeek.Bounds.H
-			// 	} else {
-			// 		week.Bounds.Y = sceneMargin
-			// 	}
-			// }
-
+		weekday := (int(info.DateTime.Weekday()) + 6) % 7
+		day, ok := week.Days[weekday]
+		if !ok {
 			day = &Day{
-				Number: dayNum,
-				Bounds: getRowBounds(weekdayNum, 7, 20, week.Bounds),
-			}
-
-			scene.Solids = append(scene.Solids, render.NewSolidFromRect(day.Bounds, color.Gray{Y: 0xF0}))
-			dayNum := dateTime.Day()
-			dayFormat := "2"
-			if dayNum == 1 {
-				dayFormat = "2 Jan"
+				Number: weekday,
+				Hours:  make(map[int]*Hour),
 			}
-			scene.Texts = append(scene.Texts, render.NewTextFromRect(day.Bounds, &scene.Fonts.Header,
-				dateTime.Format(dayFormat),
-			))
+			week.Days[weekday] = day
 		}
-		// day.photos = append(day.photos, photo)
-
-		hourNum := dateTime.Hour()
-		if hour == nil || hour.Number != hourNum {
 
+		hourNum := info.DateTime.Hour()
+		hour, ok := day.Hours[hourNum]
+		if !ok {
 			hour = &Hour{
 				Number: hourNum,
-				Bounds: getColumnBounds(hourNum, 24, 10, day.Bounds),
 			}
+			day.Hours[hourNum] = hour
+		}
+		hour.infos = append(hour.infos, info)
+	}
 
-			hourBoundsIndent := 24.
-			hourBoundsOriginal := hour.Bounds
-			hour.Bounds.X += hourBoundsIndent
-			hour.Bounds.W -= hourBoundsIndent
+	log.Printf("layout calendar %d photos in %d weeks, %d without date\n", index, len(weeks), len(undated.infos))
 
-			scene.Solids = append(scene.Solids, render.NewSolidFromRect(hour.Bounds, color.Gray{Y: 0xE0}))
-			scene.Texts = append(scene.Texts, render.NewTextFromRect(hourBoundsOriginal.Move(render.Point{X: 2, Y: 0}), &scene.Fonts.Hour,
-				dateTime.Format("15:00"),
+	layoutFinished := metrics.Elapsed("layout")
+
+	y := sceneMargin
+	weekCount := 0
+	var prevEnd time.Time
+	for start := first; !first.IsZero() && !start.After(last); start = start.AddDate(0, 0, 7) {
+		// Headers are based on the last day, so that a week shows up under
+		// the month it ends in
+		end := start.AddDate(0, 0, 6)
+
+		if prevEnd.IsZero() || end.Year() != prevEnd.Year() {
+			if !prevEnd.IsZero() {
+				y += 30
+			}
+			scene.Texts = append(scene.Texts, render.NewTextFromRect(
+				render.Rect{X: sceneMargin, Y: y, W: rowWidth, H: 30},
+				&yearFont,
+				end.Format("2006"),
 			))
+			y += 30 + 15
+		}
+
+		if prevEnd.IsZero() || end.Month() != prevEnd.Month() {
+			scene.Texts = append(scene.Texts, render.NewTextFromRect(
+				render.Rect{X: sceneMargin, Y: y, W: rowWidth, H: 30},
+				&monthFont,
+				end.Format("January"),
+			))
+			y += 30 + 10
+		}
+		prevEnd = end
 
-			// if hour != nil {
-			// 	layoutSection(&hour.Section, hour.Bounds, hour.Bounds.H/3, source)
-			// }
+		week, ok := weeks[start]
+		if !ok {
+			// Empty weeks are still shown to make gaps obvious
+			week = &Week{
+				Start: start,
+			}
 		}
-		// hour.photos = append(hour.photos, photo)
-
-		minuteBounds := getRowBounds(dateTime.Minute(), 60, 0, hour.Bounds)
-		// secondBounds := getColumnBounds(dateTime.Second()/10, 60/10, 0, minuteBounds)
-
-		// secondBounds := getRowBounds(dateTime.Minute()*60+dateTime.Second(), 60*60, 0, hour.Bounds)
-
-		photoBounds := minuteBounds
-
-		photo.Sprite.PlaceFit(
-			photoBounds.X,
-			photoBounds.Y,
-			photoBounds.W,
-			photoBounds.H,
-			float64(info.Width),
-			float64(info.Height),
-		)
-
-		// photo.Original.Sprite.PlaceFitHeight(
-		// 	weekdayBounds.X,
-		// 	weekdayBounds.Y,
-		// 	weekdayBounds.H,
-		// 	float64(size.X),
-		// 	float64(size.Y),
-		// )
-
-		if day.Bounds.Y+week.Bounds.H > y {
-			y = day.Bounds.Y + week.Bounds.H
+		week.Bounds = render.Rect{
+			X: sceneMargin,
+			Y: y,
+			W: rowWidth,
+			H: weekHeight,
 		}
 
-		// photo.Place(x, y, imageWidth, imageHeight, source)
-		// photo.Original.Sprite.PlaceFitHeight(x, y, imageHeight, float64(size.X), float64(size.Y))
+		for weekday := 0; weekday < 7; weekday++ {
+			date := start.AddDate(0, 0, weekday)
+			dayBounds := getRowBounds(weekday, 7, daySpacing, week.Bounds)
+			scene.Solids = append(scene.Solids, render.NewSolidFromRect(dayBounds, color.Gray{Y: 0xF0}))
 
-		// x += imageWidth + imageSpacing
-		// if x+imageWidth+imageSpacing > scene.Size.Width {
-		// 	x = sceneMargin
-		// 	y += imageHeight + lineSpacing
-		// }
+			dayFormat := "2"
+			if date.Day() == 1 {
+				dayFormat = "2 Jan"
+			}
+			scene.Texts = append(scene.Texts, render.NewTextFromRect(
+				render.Rect{
+					X: dayBounds.X + dayHeaderHeight*0.2,
+					Y: dayBounds.Y,
+					W: dayBounds.W,
+					H: dayHeaderHeight,
+				},
+				&dayFont,
+				date.Format(dayFormat),
+			))
 
-		now := time.Now()
-		if now.Sub(lastLogTime) > 10000*time.Second {
-			lastLogTime = now
-			log.Printf("layout %d / %d\n", i, photoCount)
+			day, ok := week.Days[weekday]
+			if !ok {
+				continue
+			}
+			day.Bounds = dayBounds
+
+			hoursBounds := render.Rect{
+				X: dayBounds.X,
+				Y: dayBounds.Y + dayHeaderHeight,
+				W: dayBounds.W,
+				H: dayBounds.H - dayHeaderHeight,
+			}
+			for hourNum := 0; hourNum < 24; hourNum++ {
+				hour, ok := day.Hours[hourNum]
+				if !ok {
+					continue
+				}
+				hour.Bounds = getColumnBounds(hourNum, 24, hourHeight*0.1, hoursBounds)
+
+				scene.Texts = append(scene.Texts, render.NewTextFromRect(
+					hour.Bounds.Move(render.Point{X: hourHeight * 0.2, Y: 0}),
+					&hourFont,
+					date.Add(time.Duration(hourNum)*time.Hour).Format("15"),
+				))
+
+				hour.Bounds.X += hourIndent
+				hour.Bounds.W -= hourIndent
+				scene.Solids = append(scene.Solids, render.NewSolidFromRect(hour.Bounds, color.Gray{Y: 0xE0}))
+
+				layoutCalendarHour(hour, scene, hourHeight*0.05)
+			}
 		}
+
+		y += weekHeight + weekSpacing
+		weekCount++
+	}
+
+	if len(undated.infos) > 0 {
+		y += 30
+		scene.Texts = append(scene.Texts, render.NewTextFromRect(
+			render.Rect{X: sceneMargin, Y: y, W: rowWidth, H: 30},
+			&yearFont,
+			"Unknown date",
+		))
+		y += 30 + 15
+
+		layout.ImageSpacing = 0.02 * layout.ImageHeight
+		layout.LineSpacing = 0.02 * layout.ImageHeight
+
+		photos := addSectionPhotos(&undated, scene, source)
+		newBounds := layoutSectionPhotos(photos, render.Rect{
+			X: sceneMargin,
+			Y: y,
+			W: rowWidth,
+			H: 0,
+		}, layout, scene, source)
+		y = newBounds.Y + newBounds.H
 	}
 
-	// x = sceneMargin
-	// y += imageHeight + lineSpacing
+	layoutFinished()
 
-	scene.Bounds.H = y + sceneMargin
+	log.Printf("layout calendar %d weeks\n", weekCount)
 
+	scene.Bounds.H = y + sceneMargin
 }
@@ -16,6 +16,8 @@ const (
 	Timeline Type = "TIMELINE"
 	Square   Type = "SQUARE"
 	Wall     Type = "WALL"
+	Map      Type = "MAP"
+	Calendar Type = "CALENDAR"
 )
 
 type Layout struct {
@@ -45,6 +47,9 @@ type Photo struct {
 
 type PhotoRegionSource struct {
 	Source *image.Source
+	// Siblings are only listed if they pass the filter of the collection
+	Dirs       []string
+	PathFilter *image.PathFilter
 }
 
 type RegionThumbnail struct {
@@ -53,16 +58,33 @@ type RegionThumbnail struct {
 	Height int    `json:"height"`
 }
 
+type RegionLocation struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+type RegionSibling struct {
+	Id        int    `json:"id"`
+	Filename  string `json:"filename"`
+	Extension string `json:"extension"`
+	Video     bool   `json:"video"`
+}
+
 type PhotoRegionData struct {
 	Id         int               `json:"id"`
 	Path       string            `json:"path"`
 	Filename   string            `json:"filename"`
 	Extension  string            `json:"extension"`
 	Video      bool              `json:"video"`
+	VideoCodec string            `json:"video_codec,omitempty"`
 	Width      int               `json:"width"`
 	Height     int               `json:"height"`
 	CreatedAt  string            `json:"created_at"`
+	Location   *RegionLocation   `json:"location,omitempty"`
 	Thumbnails []RegionThumbnail `json:"thumbnails"`
+	// Files paired with this one, e.g. RAW files or Live Photo videos
+	Siblings []RegionSibling `json:"siblings,omitempty"`
 	// SmallestThumbnail     string   `json:"smallest_thumbnail"`
 }
 
@@ -80,9 +102,9 @@ func (regionSource PhotoRegionSource) getRegionFromPhoto(id int, photo *render.P
 
 	var thumbnailTemplates []image.Thumbnail
 	if isVideo {
-		thumbnailTemplates = source.Videos.Thumbnails
+		thumbnailTemplates = source.GetVideos().Thumbnails
 	} else {
-		thumbnailTemplates = source.Images.Thumbnails
+		thumbnailTemplates = source.GetImages().Thumbnails
 	}
 
 	var thumbnails []RegionThumbnail
@@ -99,6 +121,28 @@ func (regionSource PhotoRegionSource) getRegionFromPhoto(id int, photo *render.P
 		}
 	}
 
+	var siblings []RegionSibling
+	for _, sibling := range source.ListSiblings(photo.Id, regionSource.Dirs, regionSource.PathFilter) {
+		siblings = append(siblings, RegionSibling{
+			Id:        int(sibling.Id),
+			Filename:  filepath.Base(sibling.Path),
+			Extension: strings.ToLower(filepath.Ext(sibling.Path)),
+			Video:     source.IsSupportedVideo(sibling.Path),
+		})
+	}
+
+	var location *RegionLocation
+	if info.Location.Valid {
+		location = &RegionLocation{
+			Latitude:  info.Location.Latitude,
+			Longitude: info.Location.Longitude,
+		}
+		if info.Location.AltitudeValid {
+			altitude := info.Location.Altitude
+			location.Altitude = &altitude
+		}
+	}
+
 	return render.Region{
 		Id:     id,
 		Bounds: photo.Sprite.Rect,
@@ -108,10 +152,13 @@ func (regionSource PhotoRegionSource) getRegionFromPhoto(id int, photo *render.P
 			Filename:   filepath.Base(originalPath),
 			Extension:  strings.ToLower(filepath.Ext(originalPath)),
 			Video:      isVideo,
+			VideoCodec: info.Video.Codec,
 			Width:      info.Width,
 			Height:     info.Height,
 			CreatedAt:  info.DateTime.Format(time.RFC3339),
+			Location:   location,
 			Thumbnails: thumbnails,
+			Siblings:   siblings,
 		},
 	}
 }
@@ -0,0 +1,178 @@
+package layout
+
+import (
+	"image/color"
+	"log"
+	"math"
+	"time"
+
+	"github.com/tdewolff/canvas"
+
+	"photofield/internal/collection"
+	"photofield/internal/image"
+	"photofield/internal/metrics"
+	"photofield/internal/render"
+)
+
+// Number of cells across the width of the map, photos within the same cell
+// are clustered together. At the equator a cell is roughly 10 km wide.
+const mapCellCount = 4096
+
+// Web Mercator is only defined up to this latitude, the map is square as a
+// result
+const mapMaxLatitude = 85.05112878
+
+type mapCell struct {
+	X, Y int
+}
+
+type mapCluster struct {
+	Cell  mapCell
+	infos []image.SourcedInfo
+}
+
+// Projects the location to Web Mercator coordinates in the range of [0, 1]
+func projectWebMercator(latitude float64, longitude float64) (x float64, y float64) {
+	latitude = math.Max(-mapMaxLatitude, math.Min(mapMaxLatitude, latitude))
+	x = (longitude + 180) / 360
+	lat := latitude * math.Pi / 180
+	y = (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2
+	return
+}
+
+func layoutMapCluster(cluster *mapCluster, rect render.Rect, scene *render.Scene) {
+	count := len(cluster.infos)
+	cols := int(math.Ceil(math.Sqrt(float64(count))))
+	rows := int(math.Ceil(float64(count) / float64(cols)))
+	slot := rect.W / float64(cols)
+	spacing := slot * 0.05
+
+	// Center the rows vertically for clusters that do not fill the cell
+	y := rect.Y + (rect.H-float64(rows)*slot)*0.5
+
+	for i, info := range cluster.infos {
+		col := i % cols
+		row := i / cols
+		photo := render.Photo{
+			Id: info.Id,
+		}
+		width := float64(info.Width)
+		height := float64(info.Height)
+		if width <= 0 || height <= 0 {
+			width, height = 1, 1
+		}
+		size := slot - spacing*2
+		photo.Sprite.PlaceFit(0, 0, size, size, width, height)
+		photo.Sprite.Rect.X = rect.X + float64(col)*slot + (slot-photo.Sprite.Rect.W)*0.5
+		photo.Sprite.Rect.Y = y + float64(row)*slot + (slot-photo.Sprite.Rect.H)*0.5
+		scene.Photos = append(scene.Photos, photo)
+	}
+}
+
+func LayoutMap(layout Layout, collection collection.Collection, scene *render.Scene, source *image.Source) {
+
+	infos := collection.GetInfos(source, image.ListOptions{
+		OrderBy:     image.DateAsc,
+		Limit:       collection.Limit,
+		PrimaryOnly: true,
+	})
+
+	loadCounter := metrics.Counter{
+		Name:     "load infos",
+		Interval: 1 * time.Second,
+	}
+
+	sceneMargin := 10.
+	scene.Bounds.W = layout.SceneWidth
+	mapRect := render.Rect{
+		X: sceneMargin,
+		Y: sceneMargin,
+		W: layout.SceneWidth - sceneMargin*2,
+		H: layout.SceneWidth - sceneMargin*2,
+	}
+	cellSize := mapRect.W / mapCellCount
+
+	clusters := make([]mapCluster, 0)
+	clusterIndices := make(map[mapCell]int)
+	unknown := Section{}
+
+	index := 0
+	for info := range infos {
+		loadCounter.Set(index)
+		index++
+
+		if !info.Location.Valid {
+			unknown.infos = append(unknown.infos, info)
+			continue
+		}
+
+		x, y := projectWebMercator(info.Location.Latitude, info.Location.Longitude)
+		cell := mapCell{
+			X: int(math.Min(x*mapCellCount, mapCellCount-1)),
+			Y: int(math.Min(y*mapCellCount, mapCellCount-1)),
+		}
+		clusterIndex, ok := clusterIndices[cell]
+		if !ok {
+			clusterIndex = len(clusters)
+			clusterIndices[cell] = clusterIndex
+			clusters = append(clusters, mapCluster{Cell: cell})
+		}
+		cluster := &clusters[clusterIndex]
+		cluster.infos = append(cluster.infos, info)
+	}
+
+	log.Printf("layout map %d located in %d clusters, %d without location\n", index-len(unknown.infos), len(clusters), len(unknown.infos))
+
+	layoutFinished := metrics.Elapsed("layout")
+
+	scene.Solids = append(scene.Solids, render.NewSolidFromRect(mapRect, color.Gray{Y: 0xF0}))
+
+	// Clusters never overlap as each is confined to its own cell, so
+	// overlapping photos turn into a stack that splits up when zoomed in
+	cellMargin := cellSize * 0.1
+	for i := range clusters {
+		cluster := &clusters[i]
+		layoutMapCluster(cluster, render.Rect{
+			X: mapRect.X + float64(cluster.Cell.X)*cellSize + cellMargin,
+			Y: mapRect.Y + float64(cluster.Cell.Y)*cellSize + cellMargin,
+			W: cellSize - cellMargin*2,
+			H: cellSize - cellMargin*2,
+		}, scene)
+	}
+
+	scene.Bounds.H = mapRect.Y + mapRect.H + sceneMargin
+
+	if len(unknown.infos) > 0 {
+		rect := render.Rect{
+			X: sceneMargin,
+			Y: scene.Bounds.H + sceneMargin,
+			W: layout.SceneWidth - sceneMargin*2,
+			H: 0,
+		}
+
+		textHeight := 30.
+		font := scene.Fonts.Main.Face(40, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+		scene.Texts = append(scene.Texts,
+			render.NewTextFromRect(
+				render.Rect{
+					X: rect.X,
+					Y: rect.Y,
+					W: rect.W,
+					H: textHeight,
+				},
+				&font,
+				"Unknown location",
+			),
+		)
+		rect.Y += textHeight + 15
+
+		layout.ImageSpacing = 0.02 * layout.ImageHeight
+		layout.LineSpacing = 0.02 * layout.ImageHeight
+
+		photos := addSectionPhotos(&unknown, scene, source)
+		newBounds := layoutSectionPhotos(photos, rect, layout, scene, source)
+		scene.Bounds.H = newBounds.Y + newBounds.H + sceneMargin
+	}
+
+	layoutFinished()
+}
@@ -73,8 +73,9 @@ func LayoutTimeline(layout Layout, collection collection.Collection, scene *rend
 	limit := collection.Limit
 
 	infos := collection.GetInfos(source, image.ListOptions{
-		OrderBy: image.DateDesc,
-		Limit:   limit,
+		OrderBy:     image.DateDesc,
+		Limit:       limit,
+		PrimaryOnly: true,
 	})
 
 	layout.ImageSpacing = 0.02 * layout.ImageHeight
@@ -139,7 +140,9 @@ func LayoutTimeline(layout Layout, collection collection.Collection, scene *rend
 
 	scene.Bounds.H = rect.Y + sceneMargin
 	scene.RegionSource = PhotoRegionSource{
-		Source: source,
+		Source:     source,
+		Dirs:       collection.Dirs,
+		PathFilter: collection.GetPathFilter(),
 	}
 
 }
@@ -13,8 +13,9 @@ import (
 func LayoutWall(layout Layout, collection collection.Collection, scene *render.Scene, source *image.Source) {
 
 	infos := collection.GetInfos(source, image.ListOptions{
-		OrderBy: image.DateAsc,
-		Limit:   collection.Limit,
+		OrderBy:     image.DateAsc,
+		Limit:       collection.Limit,
+		PrimaryOnly: true,
 	})
 
 	section := Section{}
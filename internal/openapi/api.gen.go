@@ -16,6 +16,10 @@ import (
 const (
 	LayoutTypeALBUM LayoutType = "ALBUM"
 
+	LayoutTypeCALENDAR LayoutType = "CALENDAR"
+
+	LayoutTypeMAP LayoutType = "MAP"
+
 	LayoutTypeSQUARE LayoutType = "SQUARE"
 
 	LayoutTypeTIMELINE LayoutType = "TIMELINE"
@@ -23,8 +27,25 @@ const (
 	LayoutTypeWALL LayoutType = "WALL"
 )
 
+// Defines values for TaskState.
+const (
+	TaskStateCANCELLED TaskState = "CANCELLED"
+
+	TaskStateDONE TaskState = "DONE"
+
+	TaskStateFAILED TaskState = "FAILED"
+
+	TaskStateQUEUED TaskState = "QUEUED"
+
+	TaskStateRUNNING TaskState = "RUNNING"
+)
+
 // Defines values for TaskType.
 const (
+	TaskTypeDEDUPE TaskType = "DEDUPE"
+
+	TaskTypeGENERATETHUMBNAILS TaskType = "GENERATE_THUMBNAILS"
+
 	TaskTypeINDEX TaskType = "INDEX"
 
 	TaskTypeLOADCOLOR TaskType = "LOAD_COLOR"
@@ -32,6 +53,15 @@ const (
 	TaskTypeLOADMETA TaskType = "LOAD_META"
 )
 
+// Defines values for TileFormat.
+const (
+	TileFormatJpeg TileFormat = "jpeg"
+
+	TileFormatPng TileFormat = "png"
+
+	TileFormatWebp TileFormat = "webp"
+)
+
 // Bounds defines model for Bounds.
 type Bounds struct {
 	H    *float32 `json:"h,omitempty"`
@@ -54,12 +84,59 @@ type Collection struct {
 // CollectionId defines model for CollectionId.
 type CollectionId string
 
+// CollectionParams defines model for CollectionParams.
+type CollectionParams struct {
+	// Existing directories on the server to index
+	Dirs []string `json:"dirs"`
+
+	// Exclude files with paths relative to the dirs matching any of the glob patterns
+	Exclude *[]string `json:"exclude,omitempty"`
+
+	// Only include files matching the search query, using the same syntax as `/search`, except for the `collection` qualifier.
+	Filter *Filter `json:"filter,omitempty"`
+
+	// Only include files with paths relative to the dirs matching any of the glob patterns
+	Include *[]string `json:"include,omitempty"`
+
+	// Maximum number of files indexed, 0 for no limit
+	IndexLimit *int        `json:"index_limit,omitempty"`
+	Layout     *LayoutType `json:"layout,omitempty"`
+
+	// Maximum number of files shown, 0 for no limit
+	Limit *int `json:"limit,omitempty"`
+
+	// Maximum depth of included files, 1 for files directly in the dirs, 0 for no limit
+	MaxDepth *int `json:"max_depth,omitempty"`
+
+	// User-friendly name, also used to generate the id
+	Name string `json:"name"`
+
+	// Names of the users allowed to read the collection if authentication is enabled, admins can read all collections
+	Users *[]string `json:"users,omitempty"`
+}
+
+// DuplicateFile defines model for DuplicateFile.
+type DuplicateFile struct {
+	// Number of differing bits between the perceptual hash of this file and the first file in the group.
+	Distance int    `json:"distance"`
+	Id       FileId `json:"id"`
+	Path     string `json:"path"`
+}
+
+// DuplicateGroup defines model for DuplicateGroup.
+type DuplicateGroup struct {
+	Files []DuplicateFile `json:"files"`
+}
+
 // File defines model for File.
 type File string
 
 // FileId defines model for FileId.
 type FileId int
 
+// Only include files matching the search query, using the same syntax as `/search`, except for the `collection` qualifier.
+type Filter string
+
 // ImageHeight defines model for ImageHeight.
 type ImageHeight float32
 
@@ -101,37 +178,80 @@ type SceneId string
 // SceneParams defines model for SceneParams.
 type SceneParams struct {
 	CollectionId CollectionId `json:"collection_id"`
-	ImageHeight  ImageHeight  `json:"image_height"`
-	Layout       LayoutType   `json:"layout"`
-	SceneWidth   SceneWidth   `json:"scene_width"`
+
+	// Only include files matching the search query, using the same syntax as `/search`, except for the `collection` qualifier.
+	Filter      *Filter     `json:"filter,omitempty"`
+	ImageHeight ImageHeight `json:"image_height"`
+	Layout      LayoutType  `json:"layout"`
+	SceneWidth  SceneWidth  `json:"scene_width"`
 }
 
 // SceneWidth defines model for SceneWidth.
 type SceneWidth float32
 
+// SearchResult defines model for SearchResult.
+type SearchResult struct {
+	Id   FileId `json:"id"`
+	Path string `json:"path"`
+}
+
 // Task defines model for Task.
 type Task struct {
 	CollectionId *CollectionId `json:"collection_id,omitempty"`
+	CreatedAt    *time.Time    `json:"created_at,omitempty"`
 
 	// Number of items already processed.
-	Done *int   `json:"done,omitempty"`
-	Id   TaskId `json:"id"`
-	Name string `json:"name"`
+	Done *int `json:"done,omitempty"`
+
+	// Reason the task failed
+	Error      *string    `json:"error,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Id         TaskId     `json:"id"`
+	Name       string     `json:"name"`
 
 	// Number of items pending as part of the task.
-	Pending *int      `json:"pending,omitempty"`
-	Type    *TaskType `json:"type,omitempty"`
+	Pending   *int       `json:"pending,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	State     *TaskState `json:"state,omitempty"`
+	Type      *TaskType  `json:"type,omitempty"`
 }
 
 // TaskId defines model for TaskId.
 type TaskId string
 
+// TaskState defines model for TaskState.
+type TaskState string
+
 // TaskType defines model for TaskType.
 type TaskType string
 
 // TileCoord defines model for TileCoord.
 type TileCoord int
 
+// TileFormat defines model for TileFormat.
+type TileFormat string
+
+// Token defines model for Token.
+type Token struct {
+	CreatedAt time.Time `json:"created_at"`
+	Id        TokenId   `json:"id"`
+	Name      string    `json:"name"`
+
+	// The token itself, only returned when it is created
+	Token *string `json:"token,omitempty"`
+}
+
+// TokenId defines model for TokenId.
+type TokenId int64
+
+// User defines model for User.
+type User struct {
+	// Admins can read all collections and manage collections and tasks
+	Admin bool   `json:"admin"`
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+}
+
 // FileIdPathParam defines model for FileIdPathParam.
 type FileIdPathParam FileId
 
@@ -141,6 +261,36 @@ type FilenamePathParam string
 // SizePathParam defines model for SizePathParam.
 type SizePathParam string
 
+// PostAuthLoginJSONBody defines parameters for PostAuthLogin.
+type PostAuthLoginJSONBody struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// PostAuthTokensJSONBody defines parameters for PostAuthTokens.
+type PostAuthTokensJSONBody struct {
+	// User-friendly name of the token
+	Name string `json:"name"`
+}
+
+// PostCollectionsJSONBody defines parameters for PostCollections.
+type PostCollectionsJSONBody CollectionParams
+
+// PutCollectionsIdJSONBody defines parameters for PutCollectionsId.
+type PutCollectionsIdJSONBody CollectionParams
+
+// GetCollectionsIdDuplicatesParams defines parameters for GetCollectionsIdDuplicates.
+type GetCollectionsIdDuplicatesParams struct {
+	// Maximum number of differing bits between the perceptual hashes of images in the same group, 0 only finds images that look the same.
+	Distance *int `json:"distance,omitempty"`
+}
+
+// GetEventsParams defines parameters for GetEvents.
+type GetEventsParams struct {
+	// Only emit events related to the collection.
+	CollectionId *CollectionId `json:"collection_id,omitempty"`
+}
+
 // GetScenesParams defines parameters for GetScenes.
 type GetScenesParams struct {
 	// Collection ID
@@ -148,6 +298,7 @@ type GetScenesParams struct {
 	SceneWidth   *SceneWidth  `json:"scene_width,omitempty"`
 	ImageHeight  *ImageHeight `json:"image_height,omitempty"`
 	Layout       *LayoutType  `json:"layout,omitempty"`
+	Filter       *Filter      `json:"filter,omitempty"`
 }
 
 // PostScenesJSONBody defines parameters for PostScenes.
@@ -164,12 +315,25 @@ type GetScenesSceneIdRegionsParams struct {
 
 // GetScenesSceneIdTilesParams defines parameters for GetScenesSceneIdTiles.
 type GetScenesSceneIdTilesParams struct {
-	TileSize        int       `json:"tile_size"`
-	Zoom            int       `json:"zoom"`
-	X               TileCoord `json:"x"`
-	Y               TileCoord `json:"y"`
-	DebugOverdraw   *bool     `json:"debug_overdraw,omitempty"`
-	DebugThumbnails *bool     `json:"debug_thumbnails,omitempty"`
+	TileSize int       `json:"tile_size"`
+	Zoom     int       `json:"zoom"`
+	X        TileCoord `json:"x"`
+	Y        TileCoord `json:"y"`
+
+	// Image format of the tile, if not specified, it is negotiated based on the Accept header, falling back to the configured `tile_format`.
+	Format          *TileFormat `json:"format,omitempty"`
+	DebugOverdraw   *bool       `json:"debug_overdraw,omitempty"`
+	DebugThumbnails *bool       `json:"debug_thumbnails,omitempty"`
+}
+
+// GetSearchParams defines parameters for GetSearch.
+type GetSearchParams struct {
+	// Search query
+	Q string `json:"q"`
+
+	// Only search within this collection
+	CollectionId *CollectionId `json:"collection_id,omitempty"`
+	Limit        *int          `json:"limit,omitempty"`
 }
 
 // GetTasksParams defines parameters for GetTasks.
@@ -187,6 +351,18 @@ type PostTasksJSONBody struct {
 	Type         TaskType     `json:"type"`
 }
 
+// PostAuthLoginJSONRequestBody defines body for PostAuthLogin for application/json ContentType.
+type PostAuthLoginJSONRequestBody PostAuthLoginJSONBody
+
+// PostAuthTokensJSONRequestBody defines body for PostAuthTokens for application/json ContentType.
+type PostAuthTokensJSONRequestBody PostAuthTokensJSONBody
+
+// PostCollectionsJSONRequestBody defines body for PostCollections for application/json ContentType.
+type PostCollectionsJSONRequestBody PostCollectionsJSONBody
+
+// PutCollectionsIdJSONRequestBody defines body for PutCollectionsId for application/json ContentType.
+type PutCollectionsIdJSONRequestBody PutCollectionsIdJSONBody
+
 // PostScenesJSONRequestBody defines body for PostScenes for application/json ContentType.
 type PostScenesJSONRequestBody PostScenesJSONBody
 
@@ -196,12 +372,45 @@ type PostTasksJSONRequestBody PostTasksJSONBody
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
 
+	// (POST /auth/login)
+	PostAuthLogin(w http.ResponseWriter, r *http.Request)
+
+	// (POST /auth/logout)
+	PostAuthLogout(w http.ResponseWriter, r *http.Request)
+
+	// (GET /auth/tokens)
+	GetAuthTokens(w http.ResponseWriter, r *http.Request)
+
+	// (POST /auth/tokens)
+	PostAuthTokens(w http.ResponseWriter, r *http.Request)
+
+	// (DELETE /auth/tokens/{id})
+	DeleteAuthTokensId(w http.ResponseWriter, r *http.Request, id TokenId)
+
+	// (GET /auth/user)
+	GetAuthUser(w http.ResponseWriter, r *http.Request)
+
 	// (GET /collections)
 	GetCollections(w http.ResponseWriter, r *http.Request)
 
+	// (POST /collections)
+	PostCollections(w http.ResponseWriter, r *http.Request)
+
+	// (DELETE /collections/{id})
+	DeleteCollectionsId(w http.ResponseWriter, r *http.Request, id CollectionId)
+
 	// (GET /collections/{id})
 	GetCollectionsId(w http.ResponseWriter, r *http.Request, id CollectionId)
 
+	// (PUT /collections/{id})
+	PutCollectionsId(w http.ResponseWriter, r *http.Request, id CollectionId)
+
+	// (GET /collections/{id}/duplicates)
+	GetCollectionsIdDuplicates(w http.ResponseWriter, r *http.Request, id CollectionId, params GetCollectionsIdDuplicatesParams)
+
+	// (GET /events)
+	GetEvents(w http.ResponseWriter, r *http.Request, params GetEventsParams)
+
 	// (GET /files/{id})
 	GetFilesId(w http.ResponseWriter, r *http.Request, id FileIdPathParam)
 
@@ -232,11 +441,20 @@ type ServerInterface interface {
 	// (GET /scenes/{scene_id}/tiles)
 	GetScenesSceneIdTiles(w http.ResponseWriter, r *http.Request, sceneId SceneId, params GetScenesSceneIdTilesParams)
 
+	// (GET /search)
+	GetSearch(w http.ResponseWriter, r *http.Request, params GetSearchParams)
+
 	// (GET /tasks)
 	GetTasks(w http.ResponseWriter, r *http.Request, params GetTasksParams)
 
 	// (POST /tasks)
 	PostTasks(w http.ResponseWriter, r *http.Request)
+
+	// (DELETE /tasks/{id})
+	DeleteTasksId(w http.ResponseWriter, r *http.Request, id TaskId)
+
+	// (GET /tasks/{id})
+	GetTasksId(w http.ResponseWriter, r *http.Request, id TaskId)
 }
 
 // ServerInterfaceWrapper converts contexts to parameters.
@@ -247,6 +465,107 @@ type ServerInterfaceWrapper struct {
 
 type MiddlewareFunc func(http.HandlerFunc) http.HandlerFunc
 
+// PostAuthLogin operation middleware
+func (siw *ServerInterfaceWrapper) PostAuthLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostAuthLogin(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// PostAuthLogout operation middleware
+func (siw *ServerInterfaceWrapper) PostAuthLogout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostAuthLogout(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// GetAuthTokens operation middleware
+func (siw *ServerInterfaceWrapper) GetAuthTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetAuthTokens(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// PostAuthTokens operation middleware
+func (siw *ServerInterfaceWrapper) PostAuthTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostAuthTokens(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// DeleteAuthTokensId operation middleware
+func (siw *ServerInterfaceWrapper) DeleteAuthTokensId(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id TokenId
+
+	err = runtime.BindStyledParameter("simple", false, "id", chi.URLParam(r, "id"), &id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteAuthTokensId(w, r, id)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// GetAuthUser operation middleware
+func (siw *ServerInterfaceWrapper) GetAuthUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetAuthUser(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
 // GetCollections operation middleware
 func (siw *ServerInterfaceWrapper) GetCollections(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -262,6 +581,47 @@ func (siw *ServerInterfaceWrapper) GetCollections(w http.ResponseWriter, r *http
 	handler(w, r.WithContext(ctx))
 }
 
+// PostCollections operation middleware
+func (siw *ServerInterfaceWrapper) PostCollections(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostCollections(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// DeleteCollectionsId operation middleware
+func (siw *ServerInterfaceWrapper) DeleteCollectionsId(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id CollectionId
+
+	err = runtime.BindStyledParameter("simple", false, "id", chi.URLParam(r, "id"), &id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteCollectionsId(w, r, id)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
 // GetCollectionsId operation middleware
 func (siw *ServerInterfaceWrapper) GetCollectionsId(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -288,6 +648,103 @@ func (siw *ServerInterfaceWrapper) GetCollectionsId(w http.ResponseWriter, r *ht
 	handler(w, r.WithContext(ctx))
 }
 
+// PutCollectionsId operation middleware
+func (siw *ServerInterfaceWrapper) PutCollectionsId(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id CollectionId
+
+	err = runtime.BindStyledParameter("simple", false, "id", chi.URLParam(r, "id"), &id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PutCollectionsId(w, r, id)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// GetCollectionsIdDuplicates operation middleware
+func (siw *ServerInterfaceWrapper) GetCollectionsIdDuplicates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id CollectionId
+
+	err = runtime.BindStyledParameter("simple", false, "id", chi.URLParam(r, "id"), &id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetCollectionsIdDuplicatesParams
+
+	// ------------- Optional query parameter "distance" -------------
+	if paramValue := r.URL.Query().Get("distance"); paramValue != "" {
+
+	}
+
+	err = runtime.BindQueryParameter("form", true, false, "distance", r.URL.Query(), &params.Distance)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter distance: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetCollectionsIdDuplicates(w, r, id, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// GetEvents operation middleware
+func (siw *ServerInterfaceWrapper) GetEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetEventsParams
+
+	// ------------- Optional query parameter "collection_id" -------------
+	if paramValue := r.URL.Query().Get("collection_id"); paramValue != "" {
+
+	}
+
+	err = runtime.BindQueryParameter("form", true, false, "collection_id", r.URL.Query(), &params.CollectionId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter collection_id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetEvents(w, r, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
 // GetFilesId operation middleware
 func (siw *ServerInterfaceWrapper) GetFilesId(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -493,6 +950,17 @@ func (siw *ServerInterfaceWrapper) GetScenes(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// ------------- Optional query parameter "filter" -------------
+	if paramValue := r.URL.Query().Get("filter"); paramValue != "" {
+
+	}
+
+	err = runtime.BindQueryParameter("form", true, false, "filter", r.URL.Query(), &params.Filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter filter: %s", err), http.StatusBadRequest)
+		return
+	}
+
 	var handler = func(w http.ResponseWriter, r *http.Request) {
 		siw.Handler.GetScenes(w, r, params)
 	}
@@ -750,6 +1218,17 @@ func (siw *ServerInterfaceWrapper) GetScenesSceneIdTiles(w http.ResponseWriter,
 		return
 	}
 
+	// ------------- Optional query parameter "format" -------------
+	if paramValue := r.URL.Query().Get("format"); paramValue != "" {
+
+	}
+
+	err = runtime.BindQueryParameter("form", true, false, "format", r.URL.Query(), &params.Format)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter format: %s", err), http.StatusBadRequest)
+		return
+	}
+
 	// ------------- Optional query parameter "debug_overdraw" -------------
 	if paramValue := r.URL.Query().Get("debug_overdraw"); paramValue != "" {
 
@@ -783,6 +1262,62 @@ func (siw *ServerInterfaceWrapper) GetScenesSceneIdTiles(w http.ResponseWriter,
 	handler(w, r.WithContext(ctx))
 }
 
+// GetSearch operation middleware
+func (siw *ServerInterfaceWrapper) GetSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetSearchParams
+
+	// ------------- Required query parameter "q" -------------
+	if paramValue := r.URL.Query().Get("q"); paramValue != "" {
+
+	} else {
+		http.Error(w, "Query argument q is required, but not found", http.StatusBadRequest)
+		return
+	}
+
+	err = runtime.BindQueryParameter("form", true, true, "q", r.URL.Query(), &params.Q)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter q: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// ------------- Optional query parameter "collection_id" -------------
+	if paramValue := r.URL.Query().Get("collection_id"); paramValue != "" {
+
+	}
+
+	err = runtime.BindQueryParameter("form", true, false, "collection_id", r.URL.Query(), &params.CollectionId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter collection_id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+	if paramValue := r.URL.Query().Get("limit"); paramValue != "" {
+
+	}
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", r.URL.Query(), &params.Limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter limit: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetSearch(w, r, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
 // GetTasks operation middleware
 func (siw *ServerInterfaceWrapper) GetTasks(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -840,6 +1375,58 @@ func (siw *ServerInterfaceWrapper) PostTasks(w http.ResponseWriter, r *http.Requ
 	handler(w, r.WithContext(ctx))
 }
 
+// DeleteTasksId operation middleware
+func (siw *ServerInterfaceWrapper) DeleteTasksId(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id TaskId
+
+	err = runtime.BindStyledParameter("simple", false, "id", chi.URLParam(r, "id"), &id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteTasksId(w, r, id)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// GetTasksId operation middleware
+func (siw *ServerInterfaceWrapper) GetTasksId(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id TaskId
+
+	err = runtime.BindStyledParameter("simple", false, "id", chi.URLParam(r, "id"), &id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid format for parameter id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetTasksId(w, r, id)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
 // Handler creates http.Handler with routing matching OpenAPI spec.
 func Handler(si ServerInterface) http.Handler {
 	return HandlerWithOptions(si, ChiServerOptions{})
@@ -877,12 +1464,45 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 		HandlerMiddlewares: options.Middlewares,
 	}
 
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/auth/login", wrapper.PostAuthLogin)
+	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/auth/logout", wrapper.PostAuthLogout)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/auth/tokens", wrapper.GetAuthTokens)
+	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/auth/tokens", wrapper.PostAuthTokens)
+	})
+	r.Group(func(r chi.Router) {
+		r.Delete(options.BaseURL+"/auth/tokens/{id}", wrapper.DeleteAuthTokensId)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/auth/user", wrapper.GetAuthUser)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/collections", wrapper.GetCollections)
 	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/collections", wrapper.PostCollections)
+	})
+	r.Group(func(r chi.Router) {
+		r.Delete(options.BaseURL+"/collections/{id}", wrapper.DeleteCollectionsId)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/collections/{id}", wrapper.GetCollectionsId)
 	})
+	r.Group(func(r chi.Router) {
+		r.Put(options.BaseURL+"/collections/{id}", wrapper.PutCollectionsId)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/collections/{id}/duplicates", wrapper.GetCollectionsIdDuplicates)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/events", wrapper.GetEvents)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/files/{id}", wrapper.GetFilesId)
 	})
@@ -913,12 +1533,21 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/scenes/{scene_id}/tiles", wrapper.GetScenesSceneIdTiles)
 	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/search", wrapper.GetSearch)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/tasks", wrapper.GetTasks)
 	})
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/tasks", wrapper.PostTasks)
 	})
+	r.Group(func(r chi.Router) {
+		r.Delete(options.BaseURL+"/tasks/{id}", wrapper.DeleteTasksId)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/tasks/{id}", wrapper.GetTasksId)
+	})
 
 	return r
 }
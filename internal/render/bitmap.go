@@ -1,10 +1,12 @@
 package render
 
 import (
+	"fmt"
 	goimage "image"
 	"image/color"
 	"math"
 	"photofield/internal/image"
+	"time"
 
 	"github.com/tdewolff/canvas"
 	"golang.org/x/image/draw"
@@ -136,3 +138,41 @@ func (bitmap *Bitmap) DrawVideoIcon(c *canvas.Context) {
 		c.View().Mul(sprite.Rect.GetMatrix()).Translate(sprite.Rect.W-marginRight, sprite.Rect.H-marginTop).Rotate(30),
 	)
 }
+
+// DrawVideoDuration draws the duration to the left of the video icon
+func (bitmap *Bitmap) DrawVideoDuration(c *canvas.Context, font *canvas.FontFamily, duration time.Duration) {
+	style := c.Style
+
+	sprite := bitmap.Sprite
+
+	iconSize := sprite.Rect.H * 0.04
+	marginTop := iconSize * 1.5
+	marginRight := iconSize * 3
+
+	face := font.Face(iconSize*4.5, color.White, canvas.FontRegular, canvas.FontNormal)
+	path, width := face.ToPath(formatDuration(duration))
+	capHeight := face.Metrics().CapHeight
+
+	style.FillColor = getRGBA(color.White)
+	style.StrokeColor = getRGBA(color.RGBA{R: 0, G: 0, B: 0, A: 0xCC})
+
+	canvasIconSize := canvas.Rect{W: iconSize}.Transform(c.View()).W
+
+	style.StrokeWidth = canvasIconSize * 0.06
+	style.StrokeJoiner = canvas.RoundJoiner{}
+
+	c.RenderPath(
+		path,
+		style,
+		c.View().Mul(sprite.Rect.GetMatrix()).Translate(sprite.Rect.W-marginRight-width, sprite.Rect.H-marginTop-capHeight*0.5),
+	)
+}
+
+// formatDuration formats the duration rounded to seconds, e.g. 1:05 or 1:02:05
+func formatDuration(duration time.Duration) string {
+	seconds := int(duration.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
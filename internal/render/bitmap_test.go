@@ -0,0 +1,21 @@
+package render
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		400 * time.Millisecond:                                 "0:00",
+		65 * time.Second:                                       "1:05",
+		59*time.Minute + 59*time.Second:                        "59:59",
+		59*time.Minute + 59*time.Second + 600*time.Millisecond: "1:00:00",
+		12*time.Hour + 5*time.Second:                           "12:00:05",
+	}
+	for duration, want := range tests {
+		if got := formatDuration(duration); got != want {
+			t.Errorf("formatDuration(%v) = %q, want %q", duration, got, want)
+		}
+	}
+}
@@ -63,7 +63,8 @@ func (photo *Photo) getBestBitmaps(config *Render, scene *Scene, c *canvas.Conte
 		originalZoomDist = photo.Sprite.Rect.GetPixelZoomDist(c, originalSize)
 	}
 
-	bitmaps := make([]BitmapAtZoom, 1+len(source.Images.Thumbnails))
+	thumbnails := source.GetImages().Thumbnails
+	bitmaps := make([]BitmapAtZoom, 1+len(thumbnails))
 	bitmaps[0] = BitmapAtZoom{
 		Bitmap: Bitmap{
 			Path:        originalPath,
@@ -73,8 +74,8 @@ func (photo *Photo) getBestBitmaps(config *Render, scene *Scene, c *canvas.Conte
 		ZoomDist: originalZoomDist,
 	}
 
-	for i := range source.Images.Thumbnails {
-		thumbnail := &source.Images.Thumbnails[i]
+	for i := range thumbnails {
+		thumbnail := &thumbnails[i]
 		thumbSize := thumbnail.Fit(originalSize)
 		thumbPath := thumbnail.GetPath(originalPath)
 		bitmaps[1+i] = BitmapAtZoom{
@@ -107,15 +108,16 @@ func (photo *Photo) getBestVariants(config *Render, scene *Scene, c *canvas.Cont
 		originalZoomDist = photo.Sprite.Rect.GetPixelZoomDist(c, originalSize)
 	}
 
-	variants := make([]Variant, 1+len(source.Images.Thumbnails))
+	thumbnails := source.GetImages().Thumbnails
+	variants := make([]Variant, 1+len(thumbnails))
 	variants[0] = Variant{
 		Thumbnail:   nil,
 		Orientation: originalInfo.Orientation,
 		ZoomDist:    originalZoomDist,
 	}
 
-	for i := range source.Images.Thumbnails {
-		thumbnail := &source.Images.Thumbnails[i]
+	for i := range thumbnails {
+		thumbnail := &thumbnails[i]
 		thumbSize := thumbnail.Fit(originalSize)
 		variants[1+i] = Variant{
 			Thumbnail: thumbnail,
@@ -141,6 +143,9 @@ func (photo *Photo) Draw(config *Render, scene *Scene, c *canvas.Context, scales
 
 		info := source.GetInfo(photo.Id)
 		style.FillColor = info.GetColor()
+		if info.Placeholder {
+			config.markIncomplete()
+		}
 
 		photo.Sprite.DrawWithStyle(c, style)
 		return
@@ -181,6 +186,9 @@ func (photo *Photo) Draw(config *Render, scene *Scene, c *canvas.Context, scales
 
 		if source.IsSupportedVideo(path) {
 			bitmap.DrawVideoIcon(c)
+			if info := source.GetInfo(photo.Id); info.Video.Duration > 0 {
+				bitmap.DrawVideoDuration(c, &scene.Fonts.Main, info.Video.Duration)
+			}
 		}
 
 		if config.DebugOverdraw {
@@ -202,6 +210,7 @@ func (photo *Photo) Draw(config *Render, scene *Scene, c *canvas.Context, scales
 	}
 
 	if !drawn {
+		config.markIncomplete()
 		style := c.Style
 		style.FillColor = canvas.Red
 		photo.Sprite.DrawWithStyle(c, style)
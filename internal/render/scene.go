@@ -1,25 +1,55 @@
 package render
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"image/color"
 	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tdewolff/canvas"
+	"github.com/tidwall/rtree"
 	"golang.org/x/image/draw"
 
 	"photofield/internal/image"
 )
 
 type Render struct {
-	TileSize          int     `json:"tile_size"`
-	MaxSolidPixelArea float64 `json:"max_solid_pixel_area"`
+	TileSize          int      `json:"tile_size"`
+	MaxSolidPixelArea float64  `json:"max_solid_pixel_area"`
+	TileFormat        string   `json:"tile_format"`
+	Jpeg              Encoding `json:"jpeg"`
+	Webp              Encoding `json:"webp"`
 	LogDraws          bool
 	DebugOverdraw     bool
 	DebugThumbnails   bool
 
 	Zoom        int
 	CanvasImage draw.Image
+	Background  color.Color
+	// Set while drawing if any of the photos were drawn with placeholders or
+	// failed to draw, accessed atomically as photos are drawn concurrently
+	incomplete int32
+}
+
+// Incomplete returns true if the drawn tile is expected to look different
+// once the missing infos and images are loaded
+func (config *Render) Incomplete() bool {
+	return atomic.LoadInt32(&config.incomplete) != 0
+}
+
+func (config *Render) markIncomplete() {
+	atomic.StoreInt32(&config.incomplete, 1)
+}
+
+type Encoding struct {
+	Quality  int  `json:"quality"`
+	Lossless bool `json:"lossless"`
 }
 
 type Point struct {
@@ -54,6 +84,7 @@ type SceneId = string
 type Scene struct {
 	Id           SceneId      `json:"id"`
 	CreatedAt    time.Time    `json:"created_at"`
+	Hash         string       `json:"-"`
 	Fonts        Fonts        `json:"-"`
 	Bounds       Rect         `json:"bounds"`
 	Photos       []Photo      `json:"-"`
@@ -61,6 +92,8 @@ type Scene struct {
 	Solids       []Solid      `json:"-"`
 	Texts        []Text       `json:"-"`
 	RegionSource RegionSource `json:"-"`
+
+	photoIndex *rtree.RTree
 }
 
 type Scales struct {
@@ -73,9 +106,13 @@ type PhotoRef struct {
 	Photo *Photo
 }
 
-func drawPhotoRefs(id int, photoRefs <-chan PhotoRef, counts chan int, config *Render, scene *Scene, c *canvas.Context, scales Scales, wg *sync.WaitGroup, source *image.Source) {
+func drawPhotoRefs(ctx context.Context, id int, photoRefs <-chan PhotoRef, counts chan int, config *Render, scene *Scene, c *canvas.Context, scales Scales, wg *sync.WaitGroup, source *image.Source) {
 	count := 0
 	for photoRef := range photoRefs {
+		// Keep draining the channel so that the sender does not block
+		if ctx.Err() != nil {
+			continue
+		}
 		photoRef.Photo.Draw(config, scene, c, scales, source)
 		count++
 	}
@@ -83,7 +120,9 @@ func drawPhotoRefs(id int, photoRefs <-chan PhotoRef, counts chan int, config *R
 	counts <- count
 }
 
-func (scene *Scene) Draw(config *Render, c *canvas.Context, scales Scales, source *image.Source) {
+// Draw draws the scene onto the canvas. Drawing stops early if the context is
+// cancelled, leaving the canvas partially drawn.
+func (scene *Scene) Draw(ctx context.Context, config *Render, c *canvas.Context, scales Scales, source *image.Source) {
 	for i := range scene.Solids {
 		solid := &scene.Solids[i]
 		solid.Draw(c, scales)
@@ -114,7 +153,7 @@ func (scene *Scene) Draw(config *Render, c *canvas.Context, scales Scales, sourc
 	wg.Add(concurrent)
 	counts := make(chan int)
 	for i := 0; i < concurrent; i++ {
-		go drawPhotoRefs(i, visiblePhotos, counts, config, scene, c, scales, wg, source)
+		go drawPhotoRefs(ctx, i, visiblePhotos, counts, config, scene, c, scales, wg, source)
 	}
 	wg.Wait()
 	for i := 0; i < concurrent; i++ {
@@ -148,8 +187,49 @@ func (scene *Scene) AddPhotosFromIdSlice(ids []image.ImageId) {
 	scene.FileCount = len(scene.Photos)
 }
 
+// BuildIndex builds a spatial index of the photos, so that the visible ones
+// can be found without going through all of them. It needs to be called again
+// if the photos are laid out differently.
+func (scene *Scene) BuildIndex() {
+	index := &rtree.RTree{}
+	for i := range scene.Photos {
+		rect := scene.Photos[i].Sprite.Rect
+		index.Insert(
+			[2]float64{rect.X, rect.Y},
+			[2]float64{rect.X + rect.W, rect.Y + rect.H},
+			i,
+		)
+	}
+	scene.photoIndex = index
+}
+
+// GetVisiblePhotos returns the photos overlapping the view in scene order,
+// up to maxCount of them. If more photos overlap the view, the index search
+// stops early, so which of them are returned is not defined.
 func (scene *Scene) GetVisiblePhotos(view Rect, maxCount int) <-chan PhotoRef {
 	out := make(chan PhotoRef)
+	if scene.photoIndex != nil {
+		indices := make([]int, 0)
+		scene.photoIndex.Search(
+			[2]float64{view.X, view.Y},
+			[2]float64{view.X + view.W, view.Y + view.H},
+			func(min, max [2]float64, data interface{}) bool {
+				indices = append(indices, data.(int))
+				return len(indices) < maxCount
+			},
+		)
+		sort.Ints(indices)
+		go func() {
+			for _, i := range indices {
+				out <- PhotoRef{
+					Index: i,
+					Photo: &scene.Photos[i],
+				}
+			}
+			close(out)
+		}()
+		return out
+	}
 	go func() {
 		count := 0
 		for i := range scene.Photos {
@@ -192,3 +272,55 @@ func (scene *Scene) GetRegions(config *Render, bounds Rect, limit *int) []Region
 func (scene *Scene) GetRegion(id int) Region {
 	return scene.RegionSource.GetRegionById(id, scene, RegionConfig{})
 }
+
+// GetHash returns a hash of everything that is drawn in the scene, so that
+// scenes laid out the same way produce the same hash, even across restarts.
+// The file stats of the photos are included, so that files changed in place
+// produce a different hash.
+func (scene *Scene) GetHash(stats map[image.ImageId]image.FileStat) string {
+	hash := sha1.New()
+	write := func(values ...interface{}) {
+		for _, value := range values {
+			binary.Write(hash, binary.LittleEndian, value)
+		}
+	}
+	writeRect := func(rect Rect) {
+		write(rect.X, rect.Y, rect.W, rect.H)
+	}
+	writeColor := func(c color.Color) {
+		if c == nil {
+			write(uint8(0))
+			return
+		}
+		r, g, b, a := c.RGBA()
+		write(uint8(1), r, g, b, a)
+	}
+
+	writeRect(scene.Bounds)
+	write(uint64(len(scene.Photos)))
+	for i := range scene.Photos {
+		photo := &scene.Photos[i]
+		write(uint32(photo.Id))
+		writeRect(photo.Sprite.Rect)
+		stat := stats[photo.Id]
+		write(stat.Size, stat.ModTime.Unix())
+	}
+	write(uint64(len(scene.Solids)))
+	for i := range scene.Solids {
+		solid := &scene.Solids[i]
+		writeRect(solid.Sprite.Rect)
+		writeColor(solid.Color)
+	}
+	write(uint64(len(scene.Texts)))
+	for i := range scene.Texts {
+		text := &scene.Texts[i]
+		writeRect(text.Sprite.Rect)
+		if text.Font != nil {
+			write(text.Font.Size)
+			writeColor(text.Font.Color)
+		}
+		write(uint64(len(text.Text)))
+		hash.Write([]byte(text.Text))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,47 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+)
+
+func visibleIndices(scene *Scene, view Rect, maxCount int) []int {
+	indices := make([]int, 0)
+	for photo := range scene.GetVisiblePhotos(view, maxCount) {
+		indices = append(indices, photo.Index)
+	}
+	return indices
+}
+
+func TestGetVisiblePhotos(t *testing.T) {
+	// 10x10 grid of 8x8 photos with 2 units of spacing
+	scene := &Scene{}
+	for i := 0; i < 100; i++ {
+		photo := Photo{}
+		photo.Sprite.Rect = Rect{X: float64(i%10) * 10, Y: float64(i/10) * 10, W: 8, H: 8}
+		scene.Photos = append(scene.Photos, photo)
+	}
+	view := Rect{X: 25, Y: 25, W: 20, H: 20}
+	all := visibleIndices(scene, view, 100)
+	want := []int{22, 23, 24, 32, 33, 34, 42, 43, 44}
+	if !reflect.DeepEqual(all, want) {
+		t.Fatalf("GetVisiblePhotos() without index = %v, want %v", all, want)
+	}
+
+	scene.BuildIndex()
+	if got := visibleIndices(scene, view, 100); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetVisiblePhotos() = %v, want %v", got, want)
+	}
+	for _, maxCount := range []int{1, 4, 9} {
+		got := visibleIndices(scene, view, maxCount)
+		if len(got) != maxCount {
+			t.Errorf("GetVisiblePhotos(%d) = %v, want %d photos", maxCount, got, maxCount)
+		}
+		for i, index := range got {
+			if !scene.Photos[index].Sprite.Rect.IsVisible(view) || i > 0 && index <= got[i-1] {
+				t.Errorf("GetVisiblePhotos(%d) = %v, want visible photos in scene order", maxCount, got)
+				break
+			}
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package scene
 
 import (
+	"encoding/json"
 	"log"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -16,11 +21,21 @@ import (
 	"photofield/internal/render"
 )
 
+// Every new combination of collection, layout, size and filter creates a new
+// scene, so scenes that have not been accessed for a while are removed along
+// with the persisted configs, as are the least recently accessed ones if there
+// are too many
+const maxSceneAge = 30 * 24 * time.Hour
+const maxScenes = 200
+
 type SceneSource struct {
 	DefaultScene render.Scene
+	// Called after a scene was invalidated or removed
+	OnChange func(change SceneChange)
 
 	sceneCache *ristretto.Cache
 	scenes     sync.Map
+	loading    sync.Map
 }
 
 type loadingScene struct {
@@ -29,8 +44,28 @@ type loadingScene struct {
 }
 
 type storedScene struct {
-	scene  *render.Scene
-	config SceneConfig
+	scene     *render.Scene
+	config    SceneConfig
+	createdAt time.Time
+	// Unix time of the last access, shared with the scene laid out again
+	accessed *int64
+}
+
+// newStoredScene returns a scene that has not been laid out yet. Access times
+// are not persisted, so scenes count as last accessed when they were created.
+func newStoredScene(config SceneConfig, createdAt time.Time) storedScene {
+	accessed := createdAt.Unix()
+	return storedScene{
+		config:    config,
+		createdAt: createdAt,
+		accessed:  &accessed,
+	}
+}
+
+type SceneChange struct {
+	Id           string `json:"id"`
+	CollectionId string `json:"collection_id"`
+	Removed      bool   `json:"removed"`
 }
 
 type SceneConfig struct {
@@ -38,6 +73,9 @@ type SceneConfig struct {
 	Collection collection.Collection
 	Layout     layout.Layout
 	Scene      render.Scene
+	// Filter of the scene itself, also set as the scene filter of the
+	// collection by ApplyFilter
+	Filter string
 }
 
 func NewSceneSource() *SceneSource {
@@ -61,7 +99,9 @@ func getSceneCost(scene *render.Scene) int64 {
 	photosCost := (int64)(len(scene.Photos)) * (int64)(unsafe.Sizeof(scene.Photos[0]))
 	solidsCost := (int64)(len(scene.Solids)) * (int64)(unsafe.Sizeof(scene.Solids[0]))
 	textsCost := (int64)(len(scene.Texts)) * ((int64)(unsafe.Sizeof(scene.Solids[0])) + (int64)(100))
-	return structCost + photosCost + solidsCost + textsCost
+	// Rough estimate of the rect, boxed index and node overhead per photo
+	indexCost := (int64)(len(scene.Photos)) * 64
+	return structCost + photosCost + solidsCost + textsCost + indexCost
 }
 
 func (source *SceneSource) loadScene(config SceneConfig, imageSource *image.Source) render.Scene {
@@ -84,18 +124,28 @@ func (source *SceneSource) loadScene(config SceneConfig, imageSource *image.Sour
 	case layout.Wall:
 		layout.LayoutWall(config.Layout, config.Collection, &scene, imageSource)
 
+	case layout.Calendar:
+		layout.LayoutCalendar(config.Layout, config.Collection, &scene, imageSource)
+
+	case layout.Map:
+		layout.LayoutMap(config.Layout, config.Collection, &scene, imageSource)
+
 	default:
 		layout.LayoutAlbum(config.Layout, config.Collection, &scene, imageSource)
 	}
 
 	if scene.RegionSource == nil {
 		scene.RegionSource = &layout.PhotoRegionSource{
-			Source: imageSource,
+			Source:     imageSource,
+			Dirs:       config.Collection.Dirs,
+			PathFilter: config.Collection.GetPathFilter(),
 		}
 	}
 
 	scene.FileCount = len(scene.Photos)
 	scene.CreatedAt = time.Now()
+	scene.Hash = scene.GetHash(imageSource.ListFileStats(config.Collection.Dirs))
+	scene.BuildIndex()
 	finished()
 
 	log.Printf("photos %d, scene %.0f x %.0f\n", len(scene.Photos), scene.Bounds.W, scene.Bounds.H)
@@ -103,18 +153,185 @@ func (source *SceneSource) loadScene(config SceneConfig, imageSource *image.Sour
 }
 
 func (source *SceneSource) GetSceneById(id string, imageSource *image.Source) *render.Scene {
-	value, found := source.sceneCache.Get(id)
+	value, loaded := source.scenes.Load(id)
+	if !loaded {
+		return nil
+	}
+	stored := value.(storedScene)
+	atomic.StoreInt64(stored.accessed, time.Now().Unix())
+
+	cached, found := source.sceneCache.Get(id)
 	if found {
-		return value.(*render.Scene)
+		return cached.(*render.Scene)
 	}
 
+	scene := stored.scene
+	if scene == nil {
+		scene = source.reloadScene(id, stored, imageSource)
+	}
+	source.sceneCache.Set(id, scene, getSceneCost(scene))
+	return scene
+}
+
+// GetCollectionId returns the id of the collection the scene lays out
+func (source *SceneSource) GetCollectionId(id string) (string, bool) {
 	stored, loaded := source.scenes.Load(id)
+	if !loaded {
+		return "", false
+	}
+	return stored.(storedScene).config.Collection.Id, true
+}
+
+// Lays out the scene again with the same id, making sure that concurrent
+// requests for the same scene only load it once.
+func (source *SceneSource) reloadScene(id string, stored storedScene, imageSource *image.Source) *render.Scene {
+	loading := &loadingScene{
+		loaded: make(chan struct{}),
+	}
+	existing, loaded := source.loading.LoadOrStore(id, loading)
 	if loaded {
-		scene := stored.(storedScene).scene
-		source.sceneCache.Set(id, scene, getSceneCost(scene))
-		return scene
+		loading = existing.(*loadingScene)
+		<-loading.loaded
+		return loading.scene
 	}
-	return nil
+
+	scene := source.loadScene(stored.config, imageSource)
+	scene.Id = id
+	stored.scene = &scene
+	source.scenes.Store(id, stored)
+	loading.scene = &scene
+	close(loading.loaded)
+	source.loading.Delete(id)
+	return &scene
+}
+
+// Invalidate marks all scenes containing any of the provided dirs as stale,
+// so that they get laid out again on next access.
+func (source *SceneSource) Invalidate(dirs []string) {
+	source.scenes.Range(func(key, value interface{}) bool {
+		stored := value.(storedScene)
+		if stored.scene == nil || !containsAnyDir(stored.config.Collection.Dirs, dirs) {
+			return true
+		}
+		id := key.(string)
+		log.Printf("scene %s invalidated", id)
+		stored.scene = nil
+		source.scenes.Store(id, stored)
+		source.sceneCache.Del(id)
+		source.notify(id, stored.config.Collection.Id, false)
+		return true
+	})
+}
+
+// ApplyFilter narrows down the scene collection to the files matching the
+// filter, on top of any filter the collection already has
+func (config *SceneConfig) ApplyFilter(filter string) error {
+	config.Filter = filter
+	config.Collection.SceneFilter = filter
+	_, err := config.Collection.ParseFilter()
+	return err
+}
+
+// UpdateCollection replaces the collection of all scenes of the collection
+// with the id, so that they get laid out again with the updated collection
+// on next access
+func (source *SceneSource) UpdateCollection(id string, c collection.Collection) {
+	source.scenes.Range(func(key, value interface{}) bool {
+		stored := value.(storedScene)
+		if stored.config.Collection.Id != id {
+			return true
+		}
+		sceneId := key.(string)
+		config := stored.config
+		config.Collection = c
+		if err := config.ApplyFilter(config.Filter); err != nil {
+			log.Printf("scene %s filter ignored: %s\n", sceneId, err.Error())
+		}
+		log.Printf("scene %s invalidated", sceneId)
+		stored.scene = nil
+		stored.config = config
+		source.scenes.Store(sceneId, stored)
+		source.sceneCache.Del(sceneId)
+		source.notify(sceneId, id, false)
+		return true
+	})
+}
+
+// RemoveCollection removes all scenes of the collection, including the
+// persisted ones
+func (source *SceneSource) RemoveCollection(id string, imageSource *image.Source) {
+	source.scenes.Range(func(key, value interface{}) bool {
+		stored := value.(storedScene)
+		if stored.config.Collection.Id != id {
+			return true
+		}
+		source.remove(key.(string), id, imageSource)
+		return true
+	})
+}
+
+func (source *SceneSource) remove(id string, collectionId string, imageSource *image.Source) {
+	log.Printf("scene %s removed", id)
+	source.scenes.Delete(id)
+	source.sceneCache.Del(id)
+	imageSource.DeleteScene(id)
+	source.notify(id, collectionId, true)
+}
+
+// prune removes the scenes that have not been accessed for longer than the
+// max age and the least recently accessed ones over the max count
+func (source *SceneSource) prune(imageSource *image.Source) {
+	type accessedScene struct {
+		id           string
+		collectionId string
+		accessed     int64
+	}
+	scenes := make([]accessedScene, 0)
+	source.scenes.Range(func(key, value interface{}) bool {
+		stored := value.(storedScene)
+		scenes = append(scenes, accessedScene{
+			id:           key.(string),
+			collectionId: stored.config.Collection.Id,
+			accessed:     atomic.LoadInt64(stored.accessed),
+		})
+		return true
+	})
+	sort.Slice(scenes, func(i, j int) bool {
+		return scenes[i].accessed > scenes[j].accessed
+	})
+	oldest := time.Now().Add(-maxSceneAge).Unix()
+	for i, scene := range scenes {
+		if i < maxScenes && scene.accessed >= oldest {
+			continue
+		}
+		source.remove(scene.id, scene.collectionId, imageSource)
+	}
+}
+
+func (source *SceneSource) notify(id string, collectionId string, removed bool) {
+	if source.OnChange == nil {
+		return
+	}
+	source.OnChange(SceneChange{
+		Id:           id,
+		CollectionId: collectionId,
+		Removed:      removed,
+	})
+}
+
+func containsAnyDir(parents []string, dirs []string) bool {
+	for _, parent := range parents {
+		parent = filepath.Clean(filepath.FromSlash(parent))
+		for _, dir := range dirs {
+			dir = filepath.Clean(dir)
+			if dir == parent ||
+				strings.HasPrefix(dir, parent+string(filepath.Separator)) ||
+				strings.HasPrefix(parent, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func sceneConfigEqual(a SceneConfig, b SceneConfig) bool {
@@ -124,6 +341,12 @@ func sceneConfigEqual(a SceneConfig, b SceneConfig) bool {
 	if a.Collection.IndexLimit != b.Collection.IndexLimit {
 		return false
 	}
+	if a.Collection.Filter != b.Collection.Filter {
+		return false
+	}
+	if a.Collection.SceneFilter != b.Collection.SceneFilter {
+		return false
+	}
 	for _, dirA := range a.Collection.Dirs {
 		found := false
 		for _, dirB := range b.Collection.Dirs {
@@ -154,15 +377,40 @@ func sceneConfigEqual(a SceneConfig, b SceneConfig) bool {
 		a.Layout.Type == b.Layout.Type
 }
 
-func (source *SceneSource) GetScenesWithConfig(config SceneConfig) []*render.Scene {
-	scenes := make([]*render.Scene, 0)
-	source.scenes.Range(func(_, value interface{}) bool {
+// GetScenesWithConfig returns the laid out scenes matching the config, most
+// recently created first. Only the most recent scene is laid out again if it
+// is stale, the other stale scenes are left out until they are accessed.
+func (source *SceneSource) GetScenesWithConfig(config SceneConfig, imageSource *image.Source) []*render.Scene {
+	type matchingScene struct {
+		id     string
+		stored storedScene
+	}
+	matching := make([]matchingScene, 0)
+	source.scenes.Range(func(key, value interface{}) bool {
 		stored := value.(storedScene)
 		if sceneConfigEqual(stored.config, config) {
-			scenes = append(scenes, stored.scene)
+			matching = append(matching, matchingScene{
+				id:     key.(string),
+				stored: stored,
+			})
 		}
 		return true
 	})
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].stored.createdAt.After(matching[j].stored.createdAt)
+	})
+
+	scenes := make([]*render.Scene, 0, len(matching))
+	for i, m := range matching {
+		scene := m.stored.scene
+		if scene == nil && i == 0 {
+			scene = source.GetSceneById(m.id, imageSource)
+		}
+		if scene == nil {
+			continue
+		}
+		scenes = append(scenes, scene)
+	}
 	return scenes
 }
 
@@ -180,9 +428,93 @@ func (source *SceneSource) Add(config SceneConfig, imageSource *image.Source) *r
 	scene := source.loadScene(config, imageSource)
 	scene.Id = id
 
-	source.scenes.Store(scene.Id, storedScene{
-		scene:  &scene,
-		config: config,
-	})
+	stored := newStoredScene(config, scene.CreatedAt)
+	stored.scene = &scene
+	source.scenes.Store(scene.Id, stored)
+	// The scene replaces any cached one with the same id
+	source.sceneCache.Del(scene.Id)
+	source.prune(imageSource)
+
+	if err := source.persist(&scene, config, imageSource); err != nil {
+		log.Printf("unable to persist scene %s: %s\n", scene.Id, err.Error())
+	}
 	return &scene
 }
+
+// persistedConfig is the part of the scene config stored in the database.
+// The rest is taken from the current configuration when the scene is
+// restored, so that configuration changes apply to restored scenes as well.
+type persistedConfig struct {
+	SceneFilter string      `json:"scene_filter,omitempty"`
+	Layout      layout.Type `json:"layout"`
+	SceneWidth  float64     `json:"scene_width"`
+	ImageHeight float64     `json:"image_height"`
+}
+
+func (source *SceneSource) persist(scene *render.Scene, config SceneConfig, imageSource *image.Source) error {
+	bytes, err := json.Marshal(persistedConfig{
+		SceneFilter: config.Filter,
+		Layout:      config.Layout.Type,
+		SceneWidth:  config.Layout.SceneWidth,
+		ImageHeight: config.Layout.ImageHeight,
+	})
+	if err != nil {
+		return err
+	}
+	return imageSource.WriteScene(image.StoredScene{
+		Id:           scene.Id,
+		CollectionId: config.Collection.Id,
+		Config:       string(bytes),
+		CreatedAt:    scene.CreatedAt,
+	})
+}
+
+// Restore adds the scenes persisted before the last restart with their
+// original ids. The scenes are only laid out once they are accessed again.
+// Scenes of collections that no longer exist are removed.
+func (source *SceneSource) Restore(defaultConfig SceneConfig, collections []collection.Collection, imageSource *image.Source) {
+	stored, err := imageSource.ListScenes()
+	if err != nil {
+		log.Printf("unable to list persisted scenes: %s\n", err.Error())
+		return
+	}
+
+	restored := 0
+	for _, s := range stored {
+		var c *collection.Collection
+		for i := range collections {
+			if collections[i].Id == s.CollectionId {
+				c = &collections[i]
+				break
+			}
+		}
+		if c == nil {
+			log.Printf("scene %s collection %s not found, removing\n", s.Id, s.CollectionId)
+			imageSource.DeleteScene(s.Id)
+			continue
+		}
+
+		var persisted persistedConfig
+		if err := json.Unmarshal([]byte(s.Config), &persisted); err != nil {
+			log.Printf("unable to restore scene %s: %s\n", s.Id, err.Error())
+			imageSource.DeleteScene(s.Id)
+			continue
+		}
+
+		config := defaultConfig
+		config.Collection = *c
+		if err := config.ApplyFilter(persisted.SceneFilter); err != nil {
+			log.Printf("unable to restore scene %s: %s\n", s.Id, err.Error())
+			imageSource.DeleteScene(s.Id)
+			continue
+		}
+		config.Layout.Type = persisted.Layout
+		config.Layout.SceneWidth = persisted.SceneWidth
+		config.Layout.ImageHeight = persisted.ImageHeight
+
+		source.scenes.Store(s.Id, newStoredScene(config, s.CreatedAt))
+		restored++
+	}
+	log.Printf("scenes restored %d\n", restored)
+	source.prune(imageSource)
+}
@@ -0,0 +1,340 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"log"
+	"photofield/internal/image"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("task not found")
+var ErrFinished = errors.New("task already finished")
+
+type Config struct {
+	// Number of tasks running at the same time, the rest stay queued
+	Concurrency int `json:"concurrency"`
+}
+
+// Finished tasks are kept around for a while, so that their final state
+// can still be looked up
+const finishedRetention = 1 * time.Hour
+
+const persistInterval = 5 * time.Second
+
+const notifyInterval = 1 * time.Second
+
+// store persists the tasks that did not finish yet, it is implemented by
+// image.Source
+type store interface {
+	WriteTask(task image.StoredTask) error
+	DeleteTask(id string) error
+	ListTasks() ([]image.StoredTask, error)
+}
+
+type entry struct {
+	task     Task
+	run      Run
+	progress Progress
+	ctx      context.Context
+	cancel   context.CancelFunc
+	// Last progress written to the database
+	persisted image.StoredTask
+	// Last done count passed to OnChange
+	notified int
+}
+
+// Manager runs tasks in the order they were added, up to the configured
+// number at the same time. Tasks that did not finish are stored in the
+// database, so that they can be resumed with Restore after a restart.
+type Manager struct {
+	// Called on every state change and periodically with the progress of
+	// running tasks. It is called with the manager locked, so it should
+	// return quickly and not call back into the manager.
+	OnChange func(task Task)
+
+	source      store
+	concurrency int
+
+	mutex   sync.Mutex
+	entries []*entry
+	running int
+}
+
+func NewManager(config Config, source *image.Source) *Manager {
+	return newManager(config, source)
+}
+
+func newManager(config Config, source store) *Manager {
+	manager := &Manager{
+		source:      source,
+		concurrency: config.Concurrency,
+	}
+	if manager.concurrency < 1 {
+		manager.concurrency = 1
+	}
+	go manager.persistProgress()
+	go manager.notifyProgress()
+	return manager
+}
+
+// Add queues the task to be run. If a task with the same id is already
+// queued or running, that task is returned along with false instead.
+func (manager *Manager) Add(task Task, run Run) (Task, bool) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.add(task, run, 0, 0)
+}
+
+func (manager *Manager) add(task Task, run Run, done int, resumeId int64) (Task, bool) {
+	index := manager.find(task.Id)
+	if index != -1 {
+		existing := manager.entries[index]
+		if !existing.task.State.Finished() {
+			return existing.get(), false
+		}
+		manager.entries = append(manager.entries[:index], manager.entries[index+1:]...)
+	}
+
+	task.State = Queued
+	if task.CreatedAt == nil {
+		now := time.Now()
+		task.CreatedAt = &now
+	}
+	e := &entry{
+		task:     task,
+		run:      run,
+		notified: done,
+	}
+	e.progress.SetDone(done)
+	e.progress.SetResumeId(resumeId)
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	manager.entries = append(manager.entries, e)
+	e.persisted = e.stored()
+	if err := manager.source.WriteTask(e.persisted); err != nil {
+		log.Printf("unable to store task %s: %s\n", task.Id, err.Error())
+	}
+	manager.notify(e)
+	manager.schedule()
+	return e.get(), true
+}
+
+// Cancel removes the task from the queue or stops it if it is running. A
+// running task stays in the running state until it actually stops.
+func (manager *Manager) Cancel(id string) (Task, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	index := manager.find(id)
+	if index == -1 {
+		return Task{}, ErrNotFound
+	}
+	e := manager.entries[index]
+	if e.task.State.Finished() {
+		return e.get(), ErrFinished
+	}
+	e.cancel()
+	if e.task.State == Queued {
+		// Never started, so it needs to be finished here instead
+		manager.finish(e, Cancelled, nil)
+	}
+	return e.get(), nil
+}
+
+// Get returns the task with the id, including recently finished ones
+func (manager *Manager) Get(id string) (Task, bool) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	index := manager.find(id)
+	if index == -1 {
+		return Task{}, false
+	}
+	return manager.entries[index].get(), true
+}
+
+// List returns all tasks in the order they were added, including recently
+// finished ones
+func (manager *Manager) List() []Task {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.prune()
+	tasks := make([]Task, len(manager.entries))
+	for i, e := range manager.entries {
+		tasks[i] = e.get()
+	}
+	return tasks
+}
+
+// Restore queues the tasks that did not finish before the last restart.
+// getRun returns the function that runs the task, or an error if the task
+// can no longer be run, e.g. because its collection was removed.
+func (manager *Manager) Restore(getRun func(task Task) (Run, error)) {
+	stored, err := manager.source.ListTasks()
+	if err != nil {
+		log.Printf("unable to list stored tasks: %s\n", err.Error())
+		return
+	}
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	count := 0
+	for i := range stored {
+		s := &stored[i]
+		task := Task{
+			Id:           s.Id,
+			Type:         s.Type,
+			Name:         s.Name,
+			CollectionId: s.CollectionId,
+			CreatedAt:    &s.CreatedAt,
+		}
+		run, err := getRun(task)
+		if err != nil {
+			log.Printf("task %s not restored: %s\n", task.Id, err.Error())
+			manager.source.DeleteTask(task.Id)
+			continue
+		}
+		manager.add(task, run, s.Done, s.ResumeId)
+		count++
+	}
+	log.Printf("tasks restored %d", count)
+}
+
+func (manager *Manager) find(id string) int {
+	for i, e := range manager.entries {
+		if e.task.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// schedule starts queued tasks while there are free slots
+func (manager *Manager) schedule() {
+	for _, e := range manager.entries {
+		if manager.running >= manager.concurrency {
+			return
+		}
+		if e.task.State != Queued {
+			continue
+		}
+		now := time.Now()
+		e.task.State = Running
+		e.task.StartedAt = &now
+		manager.running++
+		manager.notify(e)
+		go manager.execute(e)
+	}
+}
+
+func (manager *Manager) execute(e *entry) {
+	log.Printf("task %s started\n", e.task.Id)
+	err := e.run(e.ctx, &e.progress)
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.running--
+	switch {
+	case e.ctx.Err() != nil:
+		manager.finish(e, Cancelled, nil)
+	case err != nil:
+		manager.finish(e, Failed, err)
+	default:
+		manager.finish(e, Done, nil)
+	}
+	manager.schedule()
+}
+
+func (manager *Manager) finish(e *entry, state State, err error) {
+	now := time.Now()
+	e.task.State = state
+	e.task.FinishedAt = &now
+	if err != nil {
+		e.task.Error = err.Error()
+		log.Printf("task %s failed: %s\n", e.task.Id, err.Error())
+	} else {
+		log.Printf("task %s %s\n", e.task.Id, state)
+	}
+	e.cancel()
+	if err := manager.source.DeleteTask(e.task.Id); err != nil {
+		log.Printf("unable to delete stored task %s: %s\n", e.task.Id, err.Error())
+	}
+	manager.notify(e)
+}
+
+func (manager *Manager) notify(e *entry) {
+	if manager.OnChange == nil {
+		return
+	}
+	task := e.get()
+	e.notified = task.Done
+	manager.OnChange(task)
+}
+
+// prune removes finished tasks that are older than the retention
+func (manager *Manager) prune() {
+	entries := manager.entries[:0]
+	for _, e := range manager.entries {
+		if e.task.State.Finished() && time.Since(*e.task.FinishedAt) > finishedRetention {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	manager.entries = entries
+}
+
+// persistProgress periodically stores the progress of running tasks, so
+// that they can continue after the processed ids when resumed
+func (manager *Manager) persistProgress() {
+	for range time.Tick(persistInterval) {
+		manager.mutex.Lock()
+		for _, e := range manager.entries {
+			if e.task.State != Running {
+				continue
+			}
+			stored := e.stored()
+			if stored == e.persisted {
+				continue
+			}
+			if err := manager.source.WriteTask(stored); err != nil {
+				log.Printf("unable to store task %s: %s\n", e.task.Id, err.Error())
+				continue
+			}
+			e.persisted = stored
+		}
+		manager.mutex.Unlock()
+	}
+}
+
+// notifyProgress periodically reports the progress of running tasks that
+// made progress since the last report
+func (manager *Manager) notifyProgress() {
+	for range time.Tick(notifyInterval) {
+		manager.mutex.Lock()
+		for _, e := range manager.entries {
+			if e.task.State != Running || e.progress.Done() == e.notified {
+				continue
+			}
+			manager.notify(e)
+		}
+		manager.mutex.Unlock()
+	}
+}
+
+func (e *entry) get() Task {
+	task := e.task
+	task.Done = e.progress.Done()
+	task.Pending = e.progress.pending()
+	return task
+}
+
+func (e *entry) stored() image.StoredTask {
+	return image.StoredTask{
+		Id:           e.task.Id,
+		Type:         e.task.Type,
+		CollectionId: e.task.CollectionId,
+		Name:         e.task.Name,
+		Done:         e.progress.Done(),
+		ResumeId:     e.progress.ResumeId(),
+		CreatedAt:    *e.task.CreatedAt,
+	}
+}
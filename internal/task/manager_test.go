@@ -0,0 +1,156 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"photofield/internal/image"
+)
+
+type memoryStore struct {
+	sync.Map
+}
+
+func (store *memoryStore) WriteTask(task image.StoredTask) error {
+	store.Store(task.Id, task)
+	return nil
+}
+
+func (store *memoryStore) DeleteTask(id string) error {
+	store.Delete(id)
+	return nil
+}
+
+func (store *memoryStore) ListTasks() ([]image.StoredTask, error) {
+	tasks := make([]image.StoredTask, 0)
+	store.Range(func(key, value interface{}) bool {
+		tasks = append(tasks, value.(image.StoredTask))
+		return true
+	})
+	return tasks, nil
+}
+
+func waitForState(t *testing.T, manager *Manager, id string, state State) Task {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if task, ok := manager.Get(id); ok && task.State == state {
+			return task
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("task %s did not reach %s", id, state)
+	return Task{}
+}
+
+func blockUntil(release <-chan struct{}) Run {
+	return func(ctx context.Context, progress *Progress) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestManagerStates(t *testing.T) {
+	tests := []struct {
+		run    Run
+		cancel bool
+		state  State
+		err    string
+	}{
+		{func(ctx context.Context, progress *Progress) error { return nil }, false, Done, ""},
+		{func(ctx context.Context, progress *Progress) error { return errors.New("failed") }, false, Failed, "failed"},
+		{blockUntil(nil), true, Cancelled, ""},
+		// Cancelled tasks are not failed, even if they return an error
+		{func(ctx context.Context, progress *Progress) error {
+			<-ctx.Done()
+			return errors.New("failed")
+		}, true, Cancelled, ""},
+	}
+	for i, test := range tests {
+		store := &memoryStore{}
+		manager := newManager(Config{Concurrency: 1}, store)
+		manager.Add(Task{Id: "t"}, test.run)
+		if test.cancel {
+			waitForState(t, manager, "t", Running)
+			if _, err := manager.Cancel("t"); err != nil {
+				t.Errorf("%d: Cancel() error = %v", i, err)
+			}
+		}
+		task := waitForState(t, manager, "t", test.state)
+		if task.Error != test.err {
+			t.Errorf("%d: error = %q, want %q", i, task.Error, test.err)
+		}
+		if _, ok := store.Load("t"); ok {
+			t.Errorf("%d: finished task is still stored", i)
+		}
+		if _, err := manager.Cancel("t"); err != ErrFinished {
+			t.Errorf("%d: Cancel() finished error = %v, want %v", i, err, ErrFinished)
+		}
+	}
+}
+
+func TestManagerQueue(t *testing.T) {
+	manager := newManager(Config{Concurrency: 1}, &memoryStore{})
+	release := make(chan struct{})
+	manager.Add(Task{Id: "a"}, blockUntil(release))
+	manager.Add(Task{Id: "b"}, blockUntil(nil))
+	manager.Add(Task{Id: "c"}, blockUntil(nil))
+	waitForState(t, manager, "a", Running)
+	waitForState(t, manager, "b", Queued)
+
+	if _, ok := manager.Add(Task{Id: "a"}, blockUntil(nil)); ok {
+		t.Errorf("Add() of a running task = true, want false")
+	}
+	// Queued tasks are cancelled right away and never run
+	if task, err := manager.Cancel("b"); err != nil || task.State != Cancelled {
+		t.Errorf("Cancel() queued = %s, %v, want %s", task.State, err, Cancelled)
+	}
+	if _, err := manager.Cancel("d"); err != ErrNotFound {
+		t.Errorf("Cancel() unknown error = %v, want %v", err, ErrNotFound)
+	}
+
+	close(release)
+	waitForState(t, manager, "a", Done)
+	waitForState(t, manager, "c", Running)
+	manager.Cancel("c")
+	waitForState(t, manager, "c", Cancelled)
+
+	if _, ok := manager.Add(Task{Id: "a"}, blockUntil(nil)); !ok {
+		t.Errorf("Add() of a finished task = false, want true")
+	}
+}
+
+func TestManagerRestore(t *testing.T) {
+	store := &memoryStore{}
+	store.WriteTask(image.StoredTask{Id: "resumed", Type: "LOAD_META", Done: 5, ResumeId: 42})
+	store.WriteTask(image.StoredTask{Id: "removed", Type: "INDEX"})
+	manager := newManager(Config{Concurrency: 1}, store)
+
+	resumed := make(chan *Progress, 1)
+	manager.Restore(func(task Task) (Run, error) {
+		if task.Id == "removed" {
+			return nil, errors.New("collection removed")
+		}
+		return func(ctx context.Context, progress *Progress) error {
+			resumed <- progress
+			return nil
+		}, nil
+	})
+
+	progress := <-resumed
+	if progress.Done() != 5 || progress.ResumeId() != 42 {
+		t.Errorf("restored progress = %d, %d, want 5, 42", progress.Done(), progress.ResumeId())
+	}
+	if _, ok := manager.Get("removed"); ok {
+		t.Errorf("task that cannot run was restored")
+	}
+	if _, ok := store.Load("removed"); ok {
+		t.Errorf("task that cannot run is still stored")
+	}
+}
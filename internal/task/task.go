@@ -0,0 +1,87 @@
+package task
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type State string
+
+const (
+	Queued    State = "QUEUED"
+	Running   State = "RUNNING"
+	Done      State = "DONE"
+	Failed    State = "FAILED"
+	Cancelled State = "CANCELLED"
+)
+
+func (state State) Finished() bool {
+	return state == Done || state == Failed || state == Cancelled
+}
+
+type Task struct {
+	Id           string     `json:"id"`
+	Type         string     `json:"type"`
+	Name         string     `json:"name"`
+	CollectionId string     `json:"collection_id"`
+	State        State      `json:"state"`
+	Done         int        `json:"done"`
+	Pending      int        `json:"pending,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// Run does the work of a task, reporting the progress as it goes. It should
+// return as soon as possible once the context is cancelled.
+type Run func(ctx context.Context, progress *Progress) error
+
+// Progress is updated by the running task and read by the manager
+type Progress struct {
+	done     int64
+	total    int64
+	resumeId int64
+}
+
+// Done returns the number of items already processed, which is more than
+// zero when a task is resumed after a restart
+func (progress *Progress) Done() int {
+	return int(atomic.LoadInt64(&progress.done))
+}
+
+// SetTotal sets the number of all items of the task, including the done ones
+func (progress *Progress) SetTotal(total int) {
+	atomic.StoreInt64(&progress.total, int64(total))
+}
+
+func (progress *Progress) SetDone(done int) {
+	atomic.StoreInt64(&progress.done, int64(done))
+}
+
+func (progress *Progress) Add(done int) {
+	atomic.AddInt64(&progress.done, int64(done))
+}
+
+func (progress *Progress) Inc() {
+	progress.Add(1)
+}
+
+// ResumeId returns the last id up to which all items were processed, so that
+// a task resumed after a restart can continue after it
+func (progress *Progress) ResumeId() int64 {
+	return atomic.LoadInt64(&progress.resumeId)
+}
+
+func (progress *Progress) SetResumeId(id int64) {
+	atomic.StoreInt64(&progress.resumeId, id)
+}
+
+func (progress *Progress) pending() int {
+	pending := atomic.LoadInt64(&progress.total) - atomic.LoadInt64(&progress.done)
+	if pending < 0 {
+		return 0
+	}
+	return int(pending)
+}
@@ -0,0 +1,238 @@
+package tile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"photofield/internal/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type CacheConfig struct {
+	Dir     string `json:"dir"`
+	MaxSize string `json:"max_size"`
+}
+
+func (config *CacheConfig) MaxSizeBytes() int64 {
+	if config.MaxSize == "" {
+		return 0
+	}
+	value, err := units.FromHumanSize(config.MaxSize)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// Cache stores encoded tiles on disk, so that they can be served to all
+// clients without drawing them again. Tiles are evicted oldest first once the
+// cache grows over the max size.
+type Cache struct {
+	dir     string
+	maxSize int64
+	size    int64
+	pruning int32
+	mutex   sync.Mutex
+}
+
+var cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "tile_cache_hits",
+})
+
+var cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "tile_cache_misses",
+})
+
+// NewCache returns a cache in the configured dir or nil if the dir is not set
+func NewCache(config CacheConfig) (*Cache, error) {
+	if config.Dir == "" {
+		return nil, nil
+	}
+	err := os.MkdirAll(config.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	cache := &Cache{
+		dir:     config.Dir,
+		maxSize: config.MaxSizeBytes(),
+	}
+	files, err := cache.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		cache.size += file.size
+	}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "tile_cache_size",
+	}, func() float64 {
+		return float64(atomic.LoadInt64(&cache.size))
+	})
+	log.Printf("tile cache %s, %s used", cache.dir, units.BytesSize(float64(cache.size)))
+	return cache, nil
+}
+
+func (cache *Cache) path(key string) string {
+	return filepath.Join(cache.dir, key[0:2], key)
+}
+
+// Open returns the cached tile file for the key. The caller has to close the
+// file.
+func (cache *Cache) Open(key string) (*os.File, os.FileInfo, error) {
+	file, err := os.Open(cache.path(key))
+	if err != nil {
+		cacheMisses.Inc()
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		cacheMisses.Inc()
+		return nil, nil, err
+	}
+	cacheHits.Inc()
+	return file, stat, nil
+}
+
+// Put stores the tile under the key. The tile is written to a temporary file
+// first, so that concurrent reads never see partially written tiles.
+func (cache *Cache) Put(key string, data []byte) error {
+	path := cache.path(key)
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, ".tile-*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	// The replaced tile no longer counts towards the cache size
+	replaced := int64(0)
+	if stat, statErr := os.Stat(path); statErr == nil {
+		replaced = stat.Size()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	size := atomic.AddInt64(&cache.size, int64(len(data))-replaced)
+	if cache.maxSize > 0 && size > cache.maxSize &&
+		atomic.CompareAndSwapInt32(&cache.pruning, 0, 1) {
+		go func() {
+			cache.prune()
+			atomic.StoreInt32(&cache.pruning, 0)
+		}()
+	}
+	return nil
+}
+
+// Clear removes all cached tiles, e.g. after changes to the configuration
+// that affect how all tiles are drawn
+func (cache *Cache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	files, err := cache.list()
+	if err != nil {
+		log.Printf("unable to list tile cache: %s\n", err.Error())
+	}
+	for _, file := range files {
+		os.Remove(file.path)
+	}
+	atomic.StoreInt64(&cache.size, 0)
+	log.Printf("tile cache cleared %d tiles", len(files))
+}
+
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (cache *Cache) list() ([]cachedFile, error) {
+	files := make([]cachedFile, 0)
+	err := filepath.Walk(cache.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".tile-") {
+			// Leftover from an interrupted write
+			os.Remove(path)
+			return nil
+		}
+		files = append(files, cachedFile{
+			path:    path,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// prune removes the oldest tiles until the cache is back to 90% of the max
+// size, so that it does not need to run again right away
+func (cache *Cache) prune() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	finished := metrics.Elapsed("tile cache prune")
+	defer finished()
+
+	files, err := cache.list()
+	if err != nil {
+		log.Printf("unable to list tile cache: %s\n", err.Error())
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	size := int64(0)
+	for _, file := range files {
+		size += file.size
+	}
+
+	target := cache.maxSize * 9 / 10
+	removed := 0
+	for _, file := range files {
+		if size <= target {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			continue
+		}
+		size -= file.size
+		removed++
+	}
+	atomic.StoreInt64(&cache.size, size)
+	log.Printf("tile cache pruned %d tiles, %s used", removed, units.BytesSize(float64(size)))
+}
@@ -0,0 +1,194 @@
+package transcode
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"photofield/internal/metrics"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "transcode_cache_hits",
+})
+
+var cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "transcode_cache_misses",
+})
+
+var transcodeErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "transcode_errors",
+})
+
+var transcodesRunning int32
+
+// Videos watched more recently than this are not pruned, so that they are not
+// removed while being played
+const pruneMinAge = 10 * time.Minute
+
+// HLS playlists are only ended once the whole video is transcoded
+const playlistEnd = "#EXT-X-ENDLIST"
+
+func addMetrics(transcoder *Transcoder) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "transcode_cache_size",
+	}, func() float64 {
+		return float64(atomic.LoadInt64(&transcoder.size))
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "transcodes_running",
+	}, func() float64 {
+		return float64(atomic.LoadInt32(&transcodesRunning))
+	})
+}
+
+type cachedVideo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func isIncomplete(name string) bool {
+	return strings.HasPrefix(name, ".transcode-")
+}
+
+// list returns the transcoded videos, which are either MP4 files or dirs of
+// HLS files, in the subdirs of the cache dir
+func (transcoder *Transcoder) list() ([]cachedVideo, error) {
+	videos := make([]cachedVideo, 0)
+	subdirs, err := ioutil.ReadDir(transcoder.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+		dir := filepath.Join(transcoder.dir, subdir.Name())
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if isIncomplete(info.Name()) {
+				continue
+			}
+			path := filepath.Join(dir, info.Name())
+			size, err := diskUsage(path)
+			if err != nil {
+				continue
+			}
+			videos = append(videos, cachedVideo{
+				path:    path,
+				size:    size,
+				modTime: info.ModTime(),
+			})
+		}
+	}
+	return videos, nil
+}
+
+// removeIncomplete removes the leftovers of transcodes interrupted by a
+// restart, which are temporary MP4 files and HLS dirs with unended playlists
+func (transcoder *Transcoder) removeIncomplete() {
+	paths, err := filepath.Glob(filepath.Join(transcoder.dir, "*", ".transcode-*"))
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		os.RemoveAll(path)
+	}
+	dirs, err := filepath.Glob(filepath.Join(transcoder.dir, "*", "*.hls"))
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		playlist, err := ioutil.ReadFile(filepath.Join(dir, Playlist))
+		if err != nil || !strings.Contains(string(playlist), playlistEnd) {
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+// diskUsage returns the size of the file or the total size of the files in
+// the dir
+func diskUsage(path string) (int64, error) {
+	size := int64(0)
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// prune removes the least recently requested videos until the cache is back
+// to 90% of the max size, so that it does not need to run again right away.
+// Videos being transcoded are not counted yet and recently watched ones are
+// kept, so the cache can stay over the target until they are done.
+func (transcoder *Transcoder) prune() {
+	transcoder.pruneMutex.Lock()
+	defer transcoder.pruneMutex.Unlock()
+
+	finished := metrics.Elapsed("transcode cache prune")
+	defer finished()
+
+	all, err := transcoder.list()
+	if err != nil {
+		log.Printf("unable to list transcode cache: %s\n", err.Error())
+		return
+	}
+
+	transcoder.jobsMutex.Lock()
+	videos := make([]cachedVideo, 0, len(all))
+	for _, video := range all {
+		if _, ok := transcoder.jobs[video.path]; !ok {
+			videos = append(videos, video)
+		}
+	}
+	transcoder.jobsMutex.Unlock()
+
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].modTime.Before(videos[j].modTime)
+	})
+
+	size := int64(0)
+	for _, video := range videos {
+		size += video.size
+	}
+
+	target := transcoder.maxSize * 9 / 10
+	removed := 0
+	for _, video := range videos {
+		if size <= target {
+			break
+		}
+		if time.Since(video.modTime) < pruneMinAge {
+			continue
+		}
+		if err := os.RemoveAll(video.path); err != nil {
+			continue
+		}
+		size -= video.size
+		removed++
+	}
+	atomic.StoreInt64(&transcoder.size, size)
+	log.Printf("transcode cache pruned %d videos, %s used", removed, units.BytesSize(float64(size)))
+}
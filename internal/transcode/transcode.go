@@ -0,0 +1,453 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// Playlist is the filename of the HLS playlist within the dir of a video
+// transcoded to HLS, the segments are next to it
+const Playlist = "index.m3u8"
+
+const segmentDuration = 6
+
+// Transcoding the longest videos on slow machines can take a while, but a
+// stuck ffmpeg should not hold on to a worker forever
+const transcodeTimeout = 1 * time.Hour
+
+// Videos waiting for a worker beyond this many are not transcoded, as each of
+// them holds on to at least one request
+const maxQueuedJobs = 20
+
+// Requests for HLS files that are not transcoded yet check for them this often
+const hlsPollInterval = 250 * time.Millisecond
+
+// ErrBusy is returned if too many videos are already waiting to be transcoded
+var ErrBusy = errors.New("too many videos waiting to be transcoded")
+
+type Format int
+
+const (
+	MP4 Format = iota
+	HLS Format = iota
+)
+
+// ParseFormat returns the format of the video variant size name, if it is
+// one of the transcoded ones
+func ParseFormat(name string) (Format, bool) {
+	switch name {
+	case "mp4":
+		return MP4, true
+	case "hls":
+		return HLS, true
+	default:
+		return 0, false
+	}
+}
+
+// ContentType returns the content type of the transcoded file, as the
+// extensions of the HLS files are not known to all systems
+func ContentType(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mp4":
+		return "video/mp4"
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	default:
+		return ""
+	}
+}
+
+type Config struct {
+	Dir         string `json:"dir"`
+	MaxSize     string `json:"max_size"`
+	Concurrency int    `json:"concurrency"`
+	Resolution  int    `json:"resolution"`
+}
+
+func (config *Config) MaxSizeBytes() int64 {
+	if config.MaxSize == "" {
+		return 0
+	}
+	value, err := units.FromHumanSize(config.MaxSize)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+type job struct {
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+	// Requests waiting for the job, the job is cancelled once all of them
+	// are gone before it is done
+	waiters   int
+	cancelled bool
+	running   bool
+}
+
+// Transcoder converts videos that browsers are unable to play, e.g. HEVC or
+// AVI files, to H.264 MP4 files or HLS segments using ffmpeg. Videos are
+// transcoded on the first request and kept in a disk cache, which is pruned
+// oldest first once it grows over the max size. HLS files are served while
+// the rest of the video is still being transcoded.
+type Transcoder struct {
+	ffmpegPath string
+	dir        string
+	maxSize    int64
+	resolution int
+	size       int64
+	pruning    int32
+	// Limits the number of ffmpeg processes running at the same time
+	workers chan struct{}
+	// Transcodes in progress by output path, so that concurrent requests for
+	// the same video wait for the same transcode
+	jobs       map[string]*job
+	jobsMutex  sync.Mutex
+	pruneMutex sync.Mutex
+}
+
+// NewTranscoder returns a transcoder with the cache in the configured dir or
+// nil if the dir is not set or ffmpeg cannot be found
+func NewTranscoder(config Config, ffmpegPath string) (*Transcoder, error) {
+	if config.Dir == "" || ffmpegPath == "" {
+		return nil, nil
+	}
+	path, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		log.Printf("ffmpeg not found, videos will not be transcoded (%s)\n", err.Error())
+		return nil, nil
+	}
+	err = os.MkdirAll(config.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	transcoder := &Transcoder{
+		ffmpegPath: path,
+		dir:        config.Dir,
+		maxSize:    config.MaxSizeBytes(),
+		resolution: config.Resolution,
+		workers:    make(chan struct{}, concurrency),
+		jobs:       make(map[string]*job),
+	}
+	transcoder.removeIncomplete()
+	entries, err := transcoder.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		transcoder.size += entry.size
+	}
+	addMetrics(transcoder)
+	log.Printf("transcode cache %s, %s used", transcoder.dir, units.BytesSize(float64(transcoder.size)))
+	return transcoder, nil
+}
+
+// key identifies the transcoded video, the size and modification time of the
+// original are included, so that changed files are transcoded again
+func (transcoder *Transcoder) key(path string, stat os.FileInfo) string {
+	id := fmt.Sprintf("%s|%d|%d|%d", path, stat.Size(), stat.ModTime().UnixNano(), transcoder.resolution)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(id)))
+}
+
+func (transcoder *Transcoder) path(key string, format Format) string {
+	dir := filepath.Join(transcoder.dir, key[0:2])
+	switch format {
+	case HLS:
+		return filepath.Join(dir, key+".hls")
+	default:
+		return filepath.Join(dir, key+".mp4")
+	}
+}
+
+// Transcode returns the path of the video transcoded to the format, which is
+// the MP4 file or for HLS, the playlist or segment with the filename. If the
+// video has not been transcoded yet, it waits for the transcode to finish or
+// for the context to be done. HLS files are returned as soon as they are
+// written, so that playback can start right away. The transcode is cancelled
+// if all the requests waiting for it are done before it is.
+func (transcoder *Transcoder) Transcode(ctx context.Context, path string, format Format, filename string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	key := transcoder.key(path, stat)
+	output := transcoder.path(key, format)
+	file := output
+	if format == HLS {
+		file = filepath.Join(output, filename)
+	}
+
+	for {
+		transcoder.jobsMutex.Lock()
+		j, ok := transcoder.jobs[output]
+		if ok && j.cancelled {
+			// The cancelled transcode needs to clean up before starting over
+			transcoder.jobsMutex.Unlock()
+			select {
+			case <-j.done:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		if !ok {
+			// Incomplete outputs only exist while their job does
+			if _, err := os.Stat(output); err == nil {
+				transcoder.jobsMutex.Unlock()
+				cacheHits.Inc()
+				// Keeps recently watched videos from being pruned
+				now := time.Now()
+				os.Chtimes(output, now, now)
+				return file, nil
+			}
+			if transcoder.queued() >= maxQueuedJobs {
+				transcoder.jobsMutex.Unlock()
+				return "", ErrBusy
+			}
+			cacheMisses.Inc()
+			jobCtx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+			j = &job{
+				done:   make(chan struct{}),
+				cancel: cancel,
+			}
+			transcoder.jobs[output] = j
+			go transcoder.run(jobCtx, j, path, output, format)
+		}
+		j.waiters++
+		transcoder.jobsMutex.Unlock()
+
+		err = transcoder.wait(ctx, j, format, file)
+
+		transcoder.jobsMutex.Lock()
+		j.waiters--
+		if j.waiters == 0 && err != nil && err == ctx.Err() {
+			j.cancelled = true
+			j.cancel()
+		}
+		transcoder.jobsMutex.Unlock()
+
+		if err != nil {
+			return "", err
+		}
+		return file, nil
+	}
+}
+
+// wait waits for the job to be done or for HLS, only until the file is
+// written, as the segments are complete once they have their final name
+func (transcoder *Transcoder) wait(ctx context.Context, j *job, format Format, file string) error {
+	if format != HLS {
+		select {
+		case <-j.done:
+			return j.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	ticker := time.NewTicker(hlsPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(file); err == nil {
+			return nil
+		}
+		select {
+		case <-j.done:
+			return j.err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// queued returns the number of jobs waiting for a worker, jobsMutex needs to
+// be held
+func (transcoder *Transcoder) queued() int {
+	count := 0
+	for _, j := range transcoder.jobs {
+		if !j.running {
+			count++
+		}
+	}
+	return count
+}
+
+func (transcoder *Transcoder) run(ctx context.Context, j *job, path string, output string, format Format) {
+	defer j.cancel()
+
+	select {
+	case transcoder.workers <- struct{}{}:
+		transcoder.jobsMutex.Lock()
+		j.running = true
+		transcoder.jobsMutex.Unlock()
+		atomic.AddInt32(&transcodesRunning, 1)
+		start := time.Now()
+
+		j.err = transcoder.transcode(ctx, path, output, format)
+
+		atomic.AddInt32(&transcodesRunning, -1)
+		<-transcoder.workers
+
+		if j.err == nil {
+			log.Printf("transcoded %s in %s\n", path, time.Since(start).Round(time.Millisecond))
+		} else if ctx.Err() == context.Canceled {
+			log.Printf("transcoding %s cancelled\n", path)
+		} else {
+			transcodeErrors.Inc()
+		}
+	case <-ctx.Done():
+		j.err = ctx.Err()
+	}
+
+	transcoder.jobsMutex.Lock()
+	delete(transcoder.jobs, output)
+	transcoder.jobsMutex.Unlock()
+	close(j.done)
+}
+
+// transcode writes MP4 files to a temporary file first, so that partially
+// transcoded files are never served. HLS segments are written to the output
+// dir right away, so that they can be served while the rest is transcoded,
+// and the output dir is removed if the transcode fails.
+func (transcoder *Transcoder) transcode(ctx context.Context, path string, output string, format Format) error {
+	dir := filepath.Dir(output)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	var tmp string
+	var args []string
+	switch format {
+	case HLS:
+		tmp = output
+		err = os.Mkdir(tmp, 0755)
+		if err != nil {
+			return err
+		}
+		args = append(transcoder.encodeArgs(path),
+			"-f", "hls",
+			"-hls_time", fmt.Sprint(segmentDuration),
+			// Event playlists list the segments written so far and are
+			// only ended once the whole video is transcoded
+			"-hls_playlist_type", "event",
+			// Segments and playlists are written under a temporary name
+			// and renamed once complete
+			"-hls_flags", "temp_file",
+			"-hls_segment_filename", filepath.Join(tmp, "segment%04d.ts"),
+			filepath.Join(tmp, Playlist),
+		)
+	default:
+		var file *os.File
+		file, err = ioutil.TempFile(dir, ".transcode-*")
+		if err != nil {
+			return err
+		}
+		tmp = file.Name()
+		file.Close()
+		err = os.Chmod(tmp, 0644)
+		args = append(transcoder.encodeArgs(path),
+			"-movflags", "+faststart",
+			"-f", "mp4",
+			tmp,
+		)
+	}
+
+	if err == nil {
+		err = transcoder.ffmpeg(ctx, args...)
+	}
+	var size int64
+	if err == nil {
+		size, err = diskUsage(tmp)
+	}
+	if err == nil && size == 0 {
+		err = errors.New("no video transcoded")
+	}
+	if err == nil && tmp != output {
+		err = os.Rename(tmp, output)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	total := atomic.AddInt64(&transcoder.size, size)
+	if transcoder.maxSize > 0 && total > transcoder.maxSize &&
+		atomic.CompareAndSwapInt32(&transcoder.pruning, 0, 1) {
+		go func() {
+			transcoder.prune()
+			atomic.StoreInt32(&transcoder.pruning, 0)
+		}()
+	}
+	return nil
+}
+
+// encodeArgs returns the ffmpeg arguments to encode the first video and audio
+// streams of the video with the widely supported H.264 and AAC codecs. The
+// video is rotated according to its metadata and its shorter side is scaled
+// down to the resolution.
+func (transcoder *Transcoder) encodeArgs(path string) []string {
+	// Both dimensions need to be even for 4:2:0 chroma subsampling
+	scale := "scale=trunc(iw/2)*2:trunc(ih/2)*2"
+	if transcoder.resolution > 0 {
+		limit := func(side string) string {
+			return fmt.Sprintf("trunc(min(%s,%d)/2)*2", side, transcoder.resolution)
+		}
+		scale = fmt.Sprintf(
+			"scale='if(gt(iw,ih),-2,%s)':'if(gt(iw,ih),%s,-2)'",
+			limit("iw"),
+			limit("ih"),
+		)
+	}
+	return []string{
+		"-v", "error",
+		"-nostdin",
+		"-y",
+		"-i", path,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-vf", scale,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-b:a", "128k",
+	}
+}
+
+func (transcoder *Transcoder) ffmpeg(ctx context.Context, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, transcoder.ffmpegPath, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return err
+		}
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return nil
+}
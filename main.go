@@ -1,30 +1,43 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
 	"embed"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	goimage "image"
+	"image/color"
 	"image/draw"
 	"image/png"
 	"io/fs"
 	"io/ioutil"
 	"math"
+	"os/signal"
 	"path/filepath"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 
 	_ "net/http/pprof"
 
 	"github.com/felixge/fgprof"
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -41,14 +54,19 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	io_prometheus_client "github.com/prometheus/client_model/go"
 
+	"photofield/internal/auth"
 	"photofield/internal/codec"
 	"photofield/internal/collection"
+	"photofield/internal/event"
 	"photofield/internal/image"
 	"photofield/internal/layout"
 	"photofield/internal/metrics"
 	"photofield/internal/openapi"
 	"photofield/internal/render"
 	"photofield/internal/scene"
+	"photofield/internal/task"
+	"photofield/internal/tile"
+	"photofield/internal/transcode"
 )
 
 //go:embed defaults.yaml
@@ -70,23 +88,46 @@ var (
 
 var startupTime time.Time
 
+// Guarded by the mutex as the render and layout defaults can be reloaded
 var defaultSceneConfig scene.SceneConfig
+var defaultSceneConfigMutex sync.RWMutex
+
+// Last loaded configuration, only used to apply the changes on reload
+var loadedConfig AppConfig
 
 var tileRequestConfig TileRequestConfig
+var tileCache *tile.Cache
+var transcoder *transcode.Transcoder
 
 var tilePools sync.Map
 var imageSource *image.Source
 var sceneSource *scene.SceneSource
+var watcher *image.Watcher
+var watcherMutex sync.Mutex
+
+// Collections from the configuration file come first, followed by the ones
+// added through the API. The slice is replaced instead of modified on
+// changes, so it can be used without holding the lock afterwards.
 var collections []collection.Collection
+var collectionsMutex sync.RWMutex
 
-var indexTasks sync.Map
+var taskManager *task.Manager
+var authentication *auth.Auth
+var events *event.Broker
 var loadMetaOffset int64
 var loadColorOffset int64
+var dedupeOffset int64
+var generateThumbnailsOffset int64
 
 var tileRequestsOut chan struct{}
 var tileRequests []TileRequest
 var tileRequestsMutex sync.Mutex
 
+var tileRequestsCancelled = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "tile_requests_cancelled",
+}, []string{"stage"})
+
 var httpLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Namespace: metrics.Namespace,
 	Name:      "http_latency",
@@ -105,19 +146,16 @@ func instrumentationMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-type Task struct {
-	Id           string `json:"id"`
-	Type         string `json:"type"`
-	Name         string `json:"name"`
-	CollectionId string `json:"collection_id"`
-	Done         int    `json:"done"`
-	Pending      int    `json:"pending,omitempty"`
-}
-
 type TileWriter func(w io.Writer) error
 
 const MAX_PRIORITY = math.MaxInt8
 
+// Interval of comments sent to keep idle event streams open
+const eventsKeepalive = 30 * time.Second
+
+// Delay before clients reconnect to a dropped event stream
+const eventsRetry = 3 * time.Second
+
 type TileRequest struct {
 	Request  *http.Request
 	Response http.ResponseWriter
@@ -150,7 +188,7 @@ func respond(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
 	chirender.Respond(w, r, v)
 }
 
-func drawTile(c *canvas.Context, r *render.Render, scene *render.Scene, zoom int, x int, y int) {
+func drawTile(ctx context.Context, c *canvas.Context, r *render.Render, scene *render.Scene, zoom int, x int, y int) {
 
 	tileSize := float64(r.TileSize)
 	zoomPower := 1 << zoom
@@ -177,7 +215,11 @@ func drawTile(c *canvas.Context, r *render.Render, scene *render.Scene, zoom int
 	c.ResetView()
 
 	img := r.CanvasImage
-	draw.Draw(img, img.Bounds(), &goimage.Uniform{canvas.White}, goimage.Point{}, draw.Src)
+	background := r.Background
+	if background == nil {
+		background = canvas.White
+	}
+	draw.Draw(img, img.Bounds(), &goimage.Uniform{background}, goimage.Point{}, draw.Src)
 
 	matrix := canvas.Identity.
 		Translate(float64(-tx), float64(-ty+tileSize*float64(zoomPower))).
@@ -187,7 +229,7 @@ func drawTile(c *canvas.Context, r *render.Render, scene *render.Scene, zoom int
 
 	c.SetFillColor(canvas.Black)
 
-	scene.Draw(r, c, scales, imageSource)
+	scene.Draw(ctx, r, c, scales, imageSource)
 
 }
 
@@ -217,25 +259,293 @@ func putTileImage(config *render.Render, img *goimage.RGBA) {
 	pool.Put(img)
 }
 
+func getDefaultSceneConfig() scene.SceneConfig {
+	defaultSceneConfigMutex.RLock()
+	defer defaultSceneConfigMutex.RUnlock()
+	return defaultSceneConfig
+}
+
+func getCollections() []collection.Collection {
+	collectionsMutex.RLock()
+	defer collectionsMutex.RUnlock()
+	return collections
+}
+
 func getCollectionById(id string) *collection.Collection {
+	collections := getCollections()
+	index := getCollectionIndex(collections, id)
+	if index == -1 {
+		return nil
+	}
+	return &collections[index]
+}
+
+// isAdmin returns true if the user of the request is an admin or if
+// authentication is disabled
+func isAdmin(r *http.Request) bool {
+	user, ok := auth.FromContext(r.Context())
+	return !ok || user.Admin
+}
+
+// requireAdmin responds with a problem and returns false if the user of the
+// request is not an admin
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if isAdmin(r) {
+		return true
+	}
+	problem(w, r, http.StatusForbidden, "Forbidden")
+	return false
+}
+
+func canReadCollection(r *http.Request, c *collection.Collection) bool {
+	user, ok := auth.FromContext(r.Context())
+	return !ok || user.Admin || c.HasUser(user.Name)
+}
+
+// getReadableCollections returns the collections the user of the request is
+// allowed to read
+func getReadableCollections(r *http.Request) []collection.Collection {
+	collections := getCollections()
+	if isAdmin(r) {
+		return collections
+	}
+	readable := make([]collection.Collection, 0)
+	for i := range collections {
+		if canReadCollection(r, &collections[i]) {
+			readable = append(readable, collections[i])
+		}
+	}
+	return readable
+}
+
+// getReadableCollectionById returns nil if the collection does not exist or
+// if the user of the request is not allowed to read it
+func getReadableCollectionById(r *http.Request, id string) *collection.Collection {
+	c := getCollectionById(id)
+	if c == nil || !canReadCollection(r, c) {
+		return nil
+	}
+	return c
+}
+
+// getReadableScene returns nil if the scene does not exist or if the user of
+// the request is not allowed to read its collection
+func getReadableScene(r *http.Request, id string) *render.Scene {
+	if !isAdmin(r) {
+		collectionId, ok := sceneSource.GetCollectionId(id)
+		if !ok || getReadableCollectionById(r, collectionId) == nil {
+			return nil
+		}
+	}
+	return sceneSource.GetSceneById(id, imageSource)
+}
+
+// getReadableImagePath returns image.ErrNotFound if the file does not exist
+// or if it is not part of any collection the user of the request is allowed
+// to read
+func getReadableImagePath(r *http.Request, id image.ImageId) (string, error) {
+	path, err := imageSource.GetImagePath(id)
+	if err != nil || isAdmin(r) {
+		return path, err
+	}
+	if !canReadPath(r, path) {
+		return "", image.ErrNotFound
+	}
+	return path, nil
+}
+
+func canReadPath(r *http.Request, path string) bool {
+	for _, c := range getReadableCollections(r) {
+		for _, dir := range c.Dirs {
+			if !isPathInDir(dir, path) {
+				continue
+			}
+			if c.GetPathFilter().Match(dir, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isPathInDir(dir string, path string) bool {
+	dir = filepath.Clean(dir)
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func getCollectionIndex(collections []collection.Collection, id string) int {
 	for i := range collections {
 		if collections[i].Id == id {
-			return &collections[i]
+			return i
 		}
 	}
-	return nil
+	return -1
 }
 
-func getIndexTask(collection *collection.Collection) Task {
-	return Task{
-		Type:         string(openapi.TaskTypeINDEX),
-		Id:           fmt.Sprintf("index-%v", collection.Id),
-		Name:         fmt.Sprintf("Indexing %v", collection.Name),
+func newTask(taskType openapi.TaskType, collection *collection.Collection) task.Task {
+	var id, name string
+	switch taskType {
+	case openapi.TaskTypeINDEX:
+		id = "index"
+		name = "Indexing %v"
+	case openapi.TaskTypeLOADMETA:
+		id = "load-meta"
+		name = "Loading metadata for %v"
+	case openapi.TaskTypeLOADCOLOR:
+		id = "load-color"
+		name = "Loading colors for %v"
+	case openapi.TaskTypeDEDUPE:
+		id = "dedupe"
+		name = "Finding duplicates in %v"
+	case openapi.TaskTypeGENERATETHUMBNAILS:
+		id = "generate-thumbnails"
+		name = "Generating thumbnails for %v"
+	}
+	return task.Task{
+		Type:         string(taskType),
+		Id:           fmt.Sprintf("%v-%v", id, collection.Id),
+		Name:         fmt.Sprintf(name, collection.Name),
 		CollectionId: collection.Id,
-		Done:         0,
 	}
 }
 
+// getTaskRun returns the function doing the work of the task, also used to
+// resume tasks after a restart
+func getTaskRun(t task.Task) (task.Run, error) {
+	collection := getCollectionById(t.CollectionId)
+	if collection == nil {
+		return nil, fmt.Errorf("collection %s not found", t.CollectionId)
+	}
+	switch openapi.TaskType(t.Type) {
+	case openapi.TaskTypeINDEX:
+		return getIndexRun(*collection), nil
+	case openapi.TaskTypeLOADMETA:
+		return getIdsRun(collection.GetIds, imageSource.ProcessMetaLoads, true), nil
+	case openapi.TaskTypeLOADCOLOR:
+		return getIdsRun(collection.GetIds, imageSource.ProcessColorLoads, true), nil
+	case openapi.TaskTypeDEDUPE:
+		// Files that got their hash are not listed again
+		return getIdsRun(collection.GetIdsWithoutHash, imageSource.ProcessHashLoads, false), nil
+	case openapi.TaskTypeGENERATETHUMBNAILS:
+		return getIdsRun(collection.GetIds, imageSource.ProcessThumbnailGenerations, true), nil
+	}
+	return nil, fmt.Errorf("unsupported task type %s", t.Type)
+}
+
+func getIndexRun(collection collection.Collection) task.Run {
+	return func(ctx context.Context, progress *task.Progress) error {
+		// Indexing always starts over, as the walk order is not stable
+		progress.SetDone(0)
+		counter := make(chan int, 10)
+		counted := make(chan struct{})
+		go func() {
+			for add := range counter {
+				progress.Add(add)
+			}
+			close(counted)
+		}()
+		var err error
+		for _, dir := range collection.Dirs {
+			log.Printf("indexing %s %s\n", collection.Id, dir)
+			err = imageSource.IndexImages(ctx, dir, collection.GetPathFilter(), collection.IndexLimit, counter)
+			if err != nil {
+				break
+			}
+		}
+		close(counter)
+		<-counted
+		sceneSource.Invalidate(collection.Dirs)
+		if err == nil {
+			publishCollectionIndexed(collection.Id)
+		}
+		return err
+	}
+}
+
+func publishCollectionIndexed(id string) {
+	c := getCollectionById(id)
+	if c == nil {
+		return
+	}
+	indexed := *c
+	indexed.UpdateStatus(imageSource)
+	events.Publish(event.Collection, indexed)
+}
+
+// getIdsRun returns a run processing the listed ids. If resumable is set, the
+// ids up to the last one processed before a restart are skipped, which relies
+// on the ids being listed in ascending order.
+func getIdsRun(list func(source *image.Source) <-chan image.ImageId, process func(ctx context.Context, ids []image.ImageId, done func(id image.ImageId)) error, resumable bool) task.Run {
+	return func(ctx context.Context, progress *task.Progress) error {
+		ids := make([]image.ImageId, 0)
+		for id := range list(imageSource) {
+			ids = append(ids, id)
+		}
+		if !resumable {
+			progress.SetResumeId(0)
+		}
+		resumeId := image.ImageId(progress.ResumeId())
+		start := sort.Search(len(ids), func(i int) bool {
+			return ids[i] > resumeId
+		})
+		progress.SetDone(start)
+		progress.SetTotal(len(ids))
+		processed := newProcessedIds(ids[start:])
+		return process(ctx, ids[start:], func(id image.ImageId) {
+			progress.Inc()
+			if last, ok := processed.add(id); ok {
+				progress.SetResumeId(int64(last))
+			}
+		})
+	}
+}
+
+// processedIds tracks the ids processed out of order by concurrent workers
+type processedIds struct {
+	mutex     sync.Mutex
+	ids       []image.ImageId
+	processed map[image.ImageId]struct{}
+	next      int
+}
+
+func newProcessedIds(ids []image.ImageId) *processedIds {
+	return &processedIds{
+		ids:       ids,
+		processed: make(map[image.ImageId]struct{}),
+	}
+}
+
+// add marks the id as processed and returns the last id up to which all ids
+// are processed, if it changed
+func (p *processedIds) add(id image.ImageId) (image.ImageId, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.processed[id] = struct{}{}
+	advanced := false
+	for p.next < len(p.ids) {
+		next := p.ids[p.next]
+		if _, ok := p.processed[next]; !ok {
+			break
+		}
+		delete(p.processed, next)
+		p.next++
+		advanced = true
+	}
+	if !advanced {
+		return 0, false
+	}
+	return p.ids[p.next-1], true
+}
+
 func pushTileRequest(request TileRequest) {
 	tileRequestsMutex.Lock()
 	tileRequests = append(tileRequests, request)
@@ -276,8 +586,14 @@ func processTileRequests(concurrency int) {
 				if !ok {
 					panic("Mismatching tileRequestsIn and tileRequestsOut")
 				}
-				request.Process <- struct{}{}
-				<-request.Done
+				// The client might have gone away while the request was
+				// waiting, in which case the handler is not receiving anymore
+				select {
+				case request.Process <- struct{}{}:
+					<-request.Done
+				case <-request.Request.Context().Done():
+					tileRequestsCancelled.WithLabelValues("queued").Inc()
+				}
 			}
 		}()
 	}
@@ -286,13 +602,14 @@ func processTileRequests(concurrency int) {
 func renderSample(config render.Render, scene *render.Scene) {
 	log.Println("rendering sample")
 	config.LogDraws = true
+	ctx := context.Background()
 
 	image, context := getTileImage(&config)
 	defer putTileImage(&config, image)
 	config.CanvasImage = image
 
 	drawFinished := metrics.ElapsedWithCount("draw", len(scene.Photos))
-	drawTile(context, &config, scene, 0, 0, 0)
+	drawTile(ctx, context, &config, scene, 0, 0, 0)
 	drawFinished()
 
 	f, err := os.Create("out.png")
@@ -312,6 +629,191 @@ func IndexHandler(entrypoint string) func(w http.ResponseWriter, r *http.Request
 
 type Api struct{}
 
+func (*Api) PostAuthLogin(w http.ResponseWriter, r *http.Request) {
+	if !authentication.Enabled() {
+		problem(w, r, http.StatusNotFound, "Authentication disabled")
+		return
+	}
+
+	data := &openapi.PostAuthLoginJSONBody{}
+	if err := chirender.Decode(r, data); err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	session, err := authentication.SignIn(data.Name, data.Password, client)
+	if err == auth.ErrTooManyAttempts {
+		log.Printf("sign in refused for %s from %s, too many failed attempts\n", data.Name, r.RemoteAddr)
+		problem(w, r, http.StatusTooManyRequests, "Too many failed sign ins, try again later")
+		return
+	}
+	if err == auth.ErrInvalidCredentials {
+		log.Printf("sign in failed for %s from %s\n", data.Name, r.RemoteAddr)
+		problem(w, r, http.StatusUnauthorized, "Invalid name or password")
+		return
+	}
+	if err != nil {
+		log.Printf("unable to sign in %s: %s\n", data.Name, err.Error())
+		problem(w, r, http.StatusInternalServerError, "Unable to sign in")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CookieName,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	respond(w, r, http.StatusOK, session.User)
+}
+
+func (*Api) PostAuthLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(auth.CookieName); err == nil && authentication.Enabled() {
+		if err := authentication.SignOut(cookie.Value); err != nil {
+			log.Printf("unable to sign out: %s\n", err.Error())
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (*Api) GetAuthUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusNotFound, "Authentication disabled")
+		return
+	}
+	respond(w, r, http.StatusOK, user)
+}
+
+func (*Api) GetAuthTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusNotFound, "Authentication disabled")
+		return
+	}
+	tokens, err := authentication.ListTokens(user)
+	if err != nil {
+		problem(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respond(w, r, http.StatusOK, struct {
+		Items []auth.Token `json:"items"`
+	}{
+		Items: tokens,
+	})
+}
+
+func (*Api) PostAuthTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusNotFound, "Authentication disabled")
+		return
+	}
+
+	data := &openapi.PostAuthTokensJSONBody{}
+	if err := chirender.Decode(r, data); err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := authentication.CreateToken(user, data.Name)
+	if err != nil {
+		problem(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("token %d created for %s\n", token.Id, user.Name)
+	respond(w, r, http.StatusCreated, token)
+}
+
+func (*Api) DeleteAuthTokensId(w http.ResponseWriter, r *http.Request, id openapi.TokenId) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusNotFound, "Authentication disabled")
+		return
+	}
+	deleted, err := authentication.DeleteToken(user, int64(id))
+	if err != nil {
+		problem(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		problem(w, r, http.StatusNotFound, "Token not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isSecureRequest returns true if the request was made over HTTPS, directly
+// or through a reverse proxy
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// authMiddleware rejects API requests that are not authenticated if
+// authentication is enabled, except for signing in and out. The user of the
+// authenticated ones is added to the request context.
+func authMiddleware(apiPrefix string) func(http.Handler) http.Handler {
+	public := map[string]bool{
+		"/auth/login":  true,
+		"/auth/logout": true,
+	}
+	base := strings.TrimSuffix(apiPrefix, "/")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authentication.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user, ok := authentication.Authenticate(r)
+			if ok {
+				next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+				return
+			}
+			if public[strings.TrimPrefix(r.URL.Path, base)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			problem(w, r, http.StatusUnauthorized, "Unauthorized")
+		})
+	}
+}
+
+// adminMiddleware only lets admins through if authentication is enabled
+func adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authentication.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, ok := authentication.Authenticate(r)
+		if !ok {
+			problem(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if !user.Admin {
+			problem(w, r, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (*Api) PostScenes(w http.ResponseWriter, r *http.Request) {
 	data := &openapi.SceneParams{}
 	if err := chirender.Decode(r, data); err != nil {
@@ -319,16 +821,22 @@ func (*Api) PostScenes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sceneConfig := defaultSceneConfig
+	sceneConfig := getDefaultSceneConfig()
 	sceneConfig.Layout.SceneWidth = float64(data.SceneWidth)
 	sceneConfig.Layout.ImageHeight = float64(data.ImageHeight)
 	sceneConfig.Layout.Type = layout.Type(data.Layout)
-	collection := getCollectionById(string(data.CollectionId))
+	collection := getReadableCollectionById(r, string(data.CollectionId))
 	if collection == nil {
 		problem(w, r, http.StatusBadRequest, "Collection not found")
 		return
 	}
 	sceneConfig.Collection = *collection
+	if data.Filter != nil {
+		if err := sceneConfig.ApplyFilter(string(*data.Filter)); err != nil {
+			problem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	scene := sceneSource.Add(sceneConfig, imageSource)
 
@@ -337,7 +845,7 @@ func (*Api) PostScenes(w http.ResponseWriter, r *http.Request) {
 
 func (*Api) GetScenes(w http.ResponseWriter, r *http.Request, params openapi.GetScenesParams) {
 
-	sceneConfig := defaultSceneConfig
+	sceneConfig := getDefaultSceneConfig()
 	if params.SceneWidth != nil {
 		sceneConfig.Layout.SceneWidth = float64(*params.SceneWidth)
 	}
@@ -347,19 +855,20 @@ func (*Api) GetScenes(w http.ResponseWriter, r *http.Request, params openapi.Get
 	if params.Layout != nil {
 		sceneConfig.Layout.Type = layout.Type(*params.Layout)
 	}
-	collection := getCollectionById(string(params.CollectionId))
+	collection := getReadableCollectionById(r, string(params.CollectionId))
 	if collection == nil {
 		problem(w, r, http.StatusBadRequest, "Collection not found")
 		return
 	}
 	sceneConfig.Collection = *collection
+	if params.Filter != nil {
+		if err := sceneConfig.ApplyFilter(string(*params.Filter)); err != nil {
+			problem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
-	scenes := sceneSource.GetScenesWithConfig(sceneConfig)
-	sort.Slice(scenes, func(i, j int) bool {
-		a := scenes[i]
-		b := scenes[j]
-		return a.CreatedAt.After(b.CreatedAt)
-	})
+	scenes := sceneSource.GetScenesWithConfig(sceneConfig, imageSource)
 
 	respond(w, r, http.StatusOK, struct {
 		Items []*render.Scene `json:"items"`
@@ -370,7 +879,7 @@ func (*Api) GetScenes(w http.ResponseWriter, r *http.Request, params openapi.Get
 
 func (*Api) GetScenesId(w http.ResponseWriter, r *http.Request, id openapi.SceneId) {
 
-	scene := sceneSource.GetSceneById(string(id), imageSource)
+	scene := getReadableScene(r, string(id))
 	if scene == nil {
 		problem(w, r, http.StatusNotFound, "Scene not found")
 		return
@@ -380,22 +889,66 @@ func (*Api) GetScenesId(w http.ResponseWriter, r *http.Request, id openapi.Scene
 }
 
 func (*Api) GetCollections(w http.ResponseWriter, r *http.Request) {
-	for i := range collections {
-		collection := &collections[i]
+	items := append([]collection.Collection(nil), getReadableCollections(r)...)
+	for i := range items {
+		collection := &items[i]
 		collection.UpdateStatus(imageSource)
+		if !isAdmin(r) {
+			collection.Users = nil
+		}
 	}
 	respond(w, r, http.StatusOK, struct {
 		Items []collection.Collection `json:"items"`
 	}{
-		Items: collections,
+		Items: items,
 	})
 }
 
+func (*Api) PostCollections(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	data := &openapi.CollectionParams{}
+	if err := chirender.Decode(r, data); err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c := newCollectionFromParams(data)
+	if err := c.Validate(); err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	collectionsMutex.Lock()
+	if getCollectionIndex(collections, c.Id) != -1 {
+		collectionsMutex.Unlock()
+		problem(w, r, http.StatusConflict, "Collection already exists")
+		return
+	}
+	if err := c.Store(imageSource); err != nil {
+		collectionsMutex.Unlock()
+		log.Printf("unable to store collection %s: %s\n", c.Id, err.Error())
+		problem(w, r, http.StatusInternalServerError, "Unable to store collection")
+		return
+	}
+	updated := append([]collection.Collection(nil), collections...)
+	collections = append(updated, c)
+	collectionsMutex.Unlock()
+
+	log.Printf("collection %s added\n", c.Id)
+	startCollection(&c)
+	respond(w, r, http.StatusCreated, c)
+}
+
 func (*Api) GetCollectionsId(w http.ResponseWriter, r *http.Request, id openapi.CollectionId) {
 
-	for _, collection := range collections {
+	for _, collection := range getReadableCollections(r) {
 		if collection.Id == string(id) {
 			collection.UpdateStatus(imageSource)
+			if !isAdmin(r) {
+				collection.Users = nil
+			}
 			respond(w, r, http.StatusOK, collection)
 			return
 		}
@@ -404,6 +957,264 @@ func (*Api) GetCollectionsId(w http.ResponseWriter, r *http.Request, id openapi.
 	problem(w, r, http.StatusNotFound, "Scene not found")
 }
 
+func (*Api) PutCollectionsId(w http.ResponseWriter, r *http.Request, id openapi.CollectionId) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	data := &openapi.CollectionParams{}
+	if err := chirender.Decode(r, data); err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c := newCollectionFromParams(data)
+	if err := c.Validate(); err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	collectionsMutex.Lock()
+	index := getCollectionIndex(collections, string(id))
+	if index == -1 {
+		collectionsMutex.Unlock()
+		problem(w, r, http.StatusNotFound, "Collection not found")
+		return
+	}
+	if !collections[index].Stored {
+		collectionsMutex.Unlock()
+		problem(w, r, http.StatusBadRequest, "Collection is defined in the configuration file")
+		return
+	}
+	if c.Id != string(id) && getCollectionIndex(collections, c.Id) != -1 {
+		collectionsMutex.Unlock()
+		problem(w, r, http.StatusConflict, "Collection already exists")
+		return
+	}
+	if err := c.Store(imageSource); err != nil {
+		collectionsMutex.Unlock()
+		log.Printf("unable to store collection %s: %s\n", c.Id, err.Error())
+		problem(w, r, http.StatusInternalServerError, "Unable to store collection")
+		return
+	}
+	if c.Id != string(id) {
+		if err := imageSource.DeleteCollection(string(id)); err != nil {
+			// Keep only the old collection stored, as it is the one still in use
+			imageSource.DeleteCollection(c.Id)
+			collectionsMutex.Unlock()
+			log.Printf("unable to delete collection %s: %s\n", id, err.Error())
+			problem(w, r, http.StatusInternalServerError, "Unable to delete collection")
+			return
+		}
+	}
+	updated := append([]collection.Collection(nil), collections...)
+	updated[index] = c
+	collections = updated
+	collectionsMutex.Unlock()
+
+	log.Printf("collection %s updated\n", c.Id)
+	sceneSource.RemoveCollection(string(id), imageSource)
+	startCollection(&c)
+	respond(w, r, http.StatusOK, c)
+}
+
+func (*Api) DeleteCollectionsId(w http.ResponseWriter, r *http.Request, id openapi.CollectionId) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	collectionsMutex.Lock()
+	index := getCollectionIndex(collections, string(id))
+	if index == -1 {
+		collectionsMutex.Unlock()
+		problem(w, r, http.StatusNotFound, "Collection not found")
+		return
+	}
+	if !collections[index].Stored {
+		collectionsMutex.Unlock()
+		problem(w, r, http.StatusBadRequest, "Collection is defined in the configuration file")
+		return
+	}
+	if err := imageSource.DeleteCollection(string(id)); err != nil {
+		collectionsMutex.Unlock()
+		log.Printf("unable to delete collection %s: %s\n", id, err.Error())
+		problem(w, r, http.StatusInternalServerError, "Unable to delete collection")
+		return
+	}
+	updated := make([]collection.Collection, 0, len(collections)-1)
+	updated = append(updated, collections[:index]...)
+	collections = append(updated, collections[index+1:]...)
+	collectionsMutex.Unlock()
+
+	log.Printf("collection %s removed\n", id)
+	sceneSource.RemoveCollection(string(id), imageSource)
+	if imageSource.Watch {
+		watchCollections(getCollections())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newCollectionFromParams(data *openapi.CollectionParams) collection.Collection {
+	c := collection.Collection{
+		Name:   strings.TrimSpace(data.Name),
+		Stored: true,
+	}
+	for _, dir := range data.Dirs {
+		c.Dirs = append(c.Dirs, filepath.Clean(dir))
+	}
+	if data.Layout != nil {
+		c.Layout = string(*data.Layout)
+	}
+	if data.Limit != nil {
+		c.Limit = *data.Limit
+	}
+	if data.IndexLimit != nil {
+		c.IndexLimit = *data.IndexLimit
+	}
+	if data.Filter != nil {
+		c.Filter = string(*data.Filter)
+	}
+	if data.Include != nil {
+		c.Include = *data.Include
+	}
+	if data.Exclude != nil {
+		c.Exclude = *data.Exclude
+	}
+	if data.MaxDepth != nil {
+		c.MaxDepth = *data.MaxDepth
+	}
+	if data.Users != nil {
+		c.Users = *data.Users
+	}
+	prepareCollection(&c, getDefaultSceneConfig().Layout.Type)
+	return c
+}
+
+// startCollection watches and indexes a collection added at runtime
+func startCollection(c *collection.Collection) {
+	if imageSource.Watch {
+		watchCollections(getCollections())
+	}
+	indexCollection(c)
+}
+
+func (*Api) GetCollectionsIdDuplicates(w http.ResponseWriter, r *http.Request, id openapi.CollectionId, params openapi.GetCollectionsIdDuplicatesParams) {
+
+	collection := getReadableCollectionById(r, string(id))
+	if collection == nil {
+		problem(w, r, http.StatusNotFound, "Collection not found")
+		return
+	}
+
+	distance := 4
+	if params.Distance != nil {
+		distance = *params.Distance
+	}
+	if distance < 0 || distance > image.MaxDuplicateDistance {
+		problem(w, r, http.StatusBadRequest, fmt.Sprintf("Distance must be between 0 and %d", image.MaxDuplicateDistance))
+		return
+	}
+
+	groups := collection.GetDuplicates(imageSource, distance)
+
+	items := make([]openapi.DuplicateGroup, len(groups))
+	for i, group := range groups {
+		files := make([]openapi.DuplicateFile, len(group))
+		for j, file := range group {
+			files[j] = openapi.DuplicateFile{
+				Id:       openapi.FileId(file.Id),
+				Path:     file.Path,
+				Distance: file.Distance,
+			}
+		}
+		items[i] = openapi.DuplicateGroup{
+			Files: files,
+		}
+	}
+
+	respond(w, r, http.StatusOK, struct {
+		Items []openapi.DuplicateGroup `json:"items"`
+	}{
+		Items: items,
+	})
+}
+
+const maxSearchLimit = 10000
+
+func (*Api) GetSearch(w http.ResponseWriter, r *http.Request, params openapi.GetSearchParams) {
+
+	query, err := image.ParseQuery(params.Q)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := 100
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if limit < 1 || limit > maxSearchLimit {
+		problem(w, r, http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d", maxSearchLimit))
+		return
+	}
+
+	for _, id := range query.Collections {
+		collection := getReadableCollectionById(r, id)
+		if collection == nil {
+			problem(w, r, http.StatusBadRequest, fmt.Sprintf("Collection %s not found", id))
+			return
+		}
+		query.Dirs = append(query.Dirs, collection.Dirs...)
+	}
+	// The collection narrows down the results further instead of adding to
+	// the collections of the query
+	if params.CollectionId != nil {
+		collection := getReadableCollectionById(r, string(*params.CollectionId))
+		if collection == nil {
+			problem(w, r, http.StatusBadRequest, fmt.Sprintf("Collection %s not found", *params.CollectionId))
+			return
+		}
+		query.And = append(query.And, image.Query{
+			Dirs: collection.Dirs,
+		})
+	}
+	if len(query.Collections) == 0 && params.CollectionId == nil && !isAdmin(r) {
+		// Search all the collections the user can read instead of all files
+		for _, collection := range getReadableCollections(r) {
+			query.Dirs = append(query.Dirs, collection.Dirs...)
+		}
+		if len(query.Dirs) == 0 {
+			respond(w, r, http.StatusOK, struct {
+				Items []openapi.SearchResult `json:"items"`
+			}{
+				Items: []openapi.SearchResult{},
+			})
+			return
+		}
+	}
+
+	results, err := imageSource.Search(query, limit)
+	if err != nil {
+		problem(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	items := make([]openapi.SearchResult, 0, len(results))
+	for _, result := range results {
+		if !isAdmin(r) && !canReadPath(r, result.Path) {
+			continue
+		}
+		items = append(items, openapi.SearchResult{
+			Id:   openapi.FileId(result.Id),
+			Path: result.Path,
+		})
+	}
+
+	respond(w, r, http.StatusOK, struct {
+		Items []openapi.SearchResult `json:"items"`
+	}{
+		Items: items,
+	})
+}
+
 func gatherIntFromMetric(value *int, metric *io_prometheus_client.MetricFamily, name string) {
 	if metric.Name == nil || metric.Type == nil || *metric.Name != name {
 		return
@@ -436,27 +1247,48 @@ func gatherIntFromMetric(value *int, metric *io_prometheus_client.MetricFamily,
 
 func (*Api) GetTasks(w http.ResponseWriter, r *http.Request, params openapi.GetTasksParams) {
 
-	tasks := make([]Task, 0)
+	tasks := make([]task.Task, 0)
 
-	if params.Type == nil || *params.Type == openapi.TaskTypeINDEX {
-		indexTasks.Range(func(key, value interface{}) bool {
-			task := value.(Task)
-			if params.CollectionId == nil || task.CollectionId == string(*params.CollectionId) {
-				tasks = append(tasks, task)
-			}
-			return true
-		})
+	for _, t := range taskManager.List() {
+		if t.State.Finished() {
+			continue
+		}
+		if params.Type != nil && t.Type != string(*params.Type) {
+			continue
+		}
+		if params.CollectionId != nil && t.CollectionId != string(*params.CollectionId) {
+			continue
+		}
+		if !isAdmin(r) && getReadableCollectionById(r, t.CollectionId) == nil {
+			continue
+		}
+		tasks = append(tasks, t)
 	}
 
-	loadMetaTask := Task{
-		Type: string(openapi.TaskTypeLOADMETA),
-		Id:   "load-meta",
-		Name: "Loading metadata",
+	// Work queued in the background, e.g. for new files found while watching
+	loadMetaTask := task.Task{
+		Type:  string(openapi.TaskTypeLOADMETA),
+		Id:    "load-meta",
+		Name:  "Loading metadata",
+		State: task.Running,
 	}
-	loadColorTask := Task{
-		Type: string(openapi.TaskTypeLOADCOLOR),
-		Id:   "load-color",
-		Name: "Loading colors",
+	loadColorTask := task.Task{
+		Type:  string(openapi.TaskTypeLOADCOLOR),
+		Id:    "load-color",
+		Name:  "Loading colors",
+		State: task.Running,
+	}
+	dedupeTask := task.Task{
+		Type:  string(openapi.TaskTypeDEDUPE),
+		Id:    "dedupe",
+		Name:  "Finding duplicates",
+		State: task.Running,
+	}
+	generateThumbnailsTask := task.Task{
+		Type:  string(openapi.TaskTypeGENERATETHUMBNAILS),
+		Id:    "generate-thumbnails",
+		Name:  "Generating thumbnails",
+		State: task.Running,
 	}
 
 	metrics, err := prometheus.DefaultGatherer.Gather()
@@ -469,6 +1301,10 @@ func (*Api) GetTasks(w http.ResponseWriter, r *http.Request, params openapi.GetT
 		gatherIntFromMetric(&loadMetaTask.Done, metric, "pf_load_meta_done")
 		gatherIntFromMetric(&loadColorTask.Pending, metric, "pf_load_color_pending")
 		gatherIntFromMetric(&loadColorTask.Done, metric, "pf_load_color_done")
+		gatherIntFromMetric(&dedupeTask.Pending, metric, "pf_load_hash_pending")
+		gatherIntFromMetric(&dedupeTask.Done, metric, "pf_load_hash_done")
+		gatherIntFromMetric(&generateThumbnailsTask.Pending, metric, "pf_generate_thumbnails_pending")
+		gatherIntFromMetric(&generateThumbnailsTask.Done, metric, "pf_generate_thumbnails_done")
 	}
 
 	if loadMetaTask.Pending > 0 {
@@ -485,6 +1321,20 @@ func (*Api) GetTasks(w http.ResponseWriter, r *http.Request, params openapi.GetT
 	} else {
 		atomic.StoreInt64(&loadColorOffset, int64(loadColorTask.Done))
 	}
+	if dedupeTask.Pending > 0 {
+		offset := atomic.LoadInt64(&dedupeOffset)
+		dedupeTask.Done -= int(offset)
+		tasks = append(tasks, dedupeTask)
+	} else {
+		atomic.StoreInt64(&dedupeOffset, int64(dedupeTask.Done))
+	}
+	if generateThumbnailsTask.Pending > 0 {
+		offset := atomic.LoadInt64(&generateThumbnailsOffset)
+		generateThumbnailsTask.Done -= int(offset)
+		tasks = append(tasks, generateThumbnailsTask)
+	} else {
+		atomic.StoreInt64(&generateThumbnailsOffset, int64(generateThumbnailsTask.Done))
+	}
 
 	sort.Slice(tasks, func(i, j int) bool {
 		a := tasks[i]
@@ -493,7 +1343,7 @@ func (*Api) GetTasks(w http.ResponseWriter, r *http.Request, params openapi.GetT
 	})
 
 	respond(w, r, http.StatusOK, struct {
-		Items []Task `json:"items"`
+		Items []task.Task `json:"items"`
 	}{
 		Items: tasks,
 	})
@@ -506,53 +1356,165 @@ func (*Api) PostTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireAdmin(w, r) {
+		return
+	}
+
 	collection := getCollectionById(string(data.CollectionId))
 	if collection == nil {
 		problem(w, r, http.StatusBadRequest, "Collection not found")
 		return
 	}
 
-	switch data.Type {
+	t := newTask(data.Type, collection)
+	run, err := getTaskRun(t)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, "Unsupported task type")
+		return
+	}
+
+	t, added := taskManager.Add(t, run)
+	if !added {
+		respond(w, r, http.StatusConflict, t)
+		return
+	}
+	respond(w, r, http.StatusAccepted, t)
+}
+
+func (*Api) GetTasksId(w http.ResponseWriter, r *http.Request, id openapi.TaskId) {
+	t, ok := taskManager.Get(string(id))
+	if !ok || (!isAdmin(r) && getReadableCollectionById(r, t.CollectionId) == nil) {
+		problem(w, r, http.StatusNotFound, "Task not found")
+		return
+	}
+	respond(w, r, http.StatusOK, t)
+}
+
+func (*Api) DeleteTasksId(w http.ResponseWriter, r *http.Request, id openapi.TaskId) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	t, err := taskManager.Cancel(string(id))
+	switch err {
+	case nil:
+		respond(w, r, http.StatusAccepted, t)
+	case task.ErrNotFound:
+		problem(w, r, http.StatusNotFound, "Task not found")
+	case task.ErrFinished:
+		respond(w, r, http.StatusConflict, t)
+	default:
+		problem(w, r, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (*Api) GetEvents(w http.ResponseWriter, r *http.Request, params openapi.GetEventsParams) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		problem(w, r, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	subscribed, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	// Tells the client how long to wait before reconnecting
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventsKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			// Comment lines are ignored by clients, but keep proxies from
+			// closing the idle connection
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case e := <-subscribed:
+			collectionId := getEventCollectionId(e)
+			if params.CollectionId != nil && collectionId != string(*params.CollectionId) {
+				continue
+			}
+			if !isAdmin(r) {
+				if getReadableCollectionById(r, collectionId) == nil {
+					continue
+				}
+				if c, ok := e.Data.(collection.Collection); ok {
+					c.Users = nil
+					e.Data = c
+				}
+			}
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				log.Printf("unable to encode %s event: %s\n", e.Type, err.Error())
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func getEventCollectionId(e event.Event) string {
+	switch data := e.Data.(type) {
+	case task.Task:
+		return data.CollectionId
+	case collection.Collection:
+		return data.Id
+	case scene.SceneChange:
+		return data.CollectionId
+	}
+	return ""
+}
+
+func (*Api) GetScenesSceneIdTiles(w http.ResponseWriter, r *http.Request, sceneId openapi.SceneId, params openapi.GetScenesSceneIdTilesParams) {
+	startTime := time.Now()
+
+	scene := getReadableScene(r, string(sceneId))
+	if scene == nil {
+		problem(w, r, http.StatusBadRequest, "Scene not found")
+		return
+	}
+
+	format, err := getTileFormat(r, params)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	render := getTileRender(params, format)
+	key := getTileKey(scene, &render, format, params)
 
-	case openapi.TaskTypeINDEX:
-		task := getIndexTask(collection)
-		stored, loaded := indexTasks.LoadOrStore(collection.Id, task)
-		task = stored.(Task)
-		if loaded {
-			respond(w, r, http.StatusConflict, task)
-		} else {
-			respond(w, r, http.StatusAccepted, task)
-			indexCollection(collection)
-		}
+	// Tiles of the same scene id can change when the scene is laid out again,
+	// so clients have to revalidate them every time
+	w.Header().Set("Content-Type", format.MimeType())
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, key))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
 
-	case openapi.TaskTypeLOADMETA:
-		imageSource.QueueMetaLoads(collection.GetIds(imageSource))
-		task := Task{
-			Id:           fmt.Sprintf("load-meta-%v", collection.Id),
-			CollectionId: collection.Id,
-			Name:         fmt.Sprintf("Loading metadata for %v", collection.Name),
-		}
-		respond(w, r, http.StatusAccepted, task)
+	if checkNotModified(r, key) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-	case openapi.TaskTypeLOADCOLOR:
-		imageSource.QueueColorLoads(collection.GetIds(imageSource))
-		task := Task{
-			Id:           fmt.Sprintf("load-color-%v", collection.Id),
-			CollectionId: collection.Id,
-			Name:         fmt.Sprintf("Loading colors for %v", collection.Name),
+	if tileCache != nil {
+		file, stat, err := tileCache.Open(key)
+		if err == nil {
+			defer file.Close()
+			http.ServeContent(w, r, "", stat.ModTime(), file)
+			return
 		}
-		respond(w, r, http.StatusAccepted, task)
-
-	default:
-		problem(w, r, http.StatusBadRequest, "Unsupported task type")
 	}
-}
-
-func (*Api) GetScenesSceneIdTiles(w http.ResponseWriter, r *http.Request, sceneId openapi.SceneId, params openapi.GetScenesSceneIdTilesParams) {
-	startTime := time.Now()
 
 	if tileRequestConfig.Concurrency == 0 {
-		GetScenesSceneIdTilesImpl(w, r, sceneId, params)
+		GetScenesSceneIdTilesImpl(w, r, scene, &render, format, key, params)
 	} else {
 		request := TileRequest{
 			Request:  r,
@@ -562,9 +1524,13 @@ func (*Api) GetScenesSceneIdTiles(w http.ResponseWriter, r *http.Request, sceneI
 			Done:     make(chan struct{}),
 		}
 		pushTileRequest(request)
-		<-request.Process
-		GetScenesSceneIdTilesImpl(w, r, sceneId, params)
-		request.Done <- struct{}{}
+		select {
+		case <-request.Process:
+			GetScenesSceneIdTilesImpl(w, r, scene, &render, format, key, params)
+			request.Done <- struct{}{}
+		case <-r.Context().Done():
+			return
+		}
 	}
 
 	endTime := time.Now()
@@ -582,38 +1548,197 @@ func GetTilesRequestPriority(params openapi.GetScenesSceneIdTilesParams) int8 {
 	return 100
 }
 
-func GetScenesSceneIdTilesImpl(w http.ResponseWriter, r *http.Request, sceneId openapi.SceneId, params openapi.GetScenesSceneIdTilesParams) {
-	scene := sceneSource.GetSceneById(string(sceneId), imageSource)
-	if scene == nil {
-		problem(w, r, http.StatusBadRequest, "Scene not found")
+func GetScenesSceneIdTilesImpl(w http.ResponseWriter, r *http.Request, scene *render.Scene, render *render.Render, format codec.Format, key string, params openapi.GetScenesSceneIdTilesParams) {
+	zoom := params.Zoom
+	x := int(params.X)
+	y := int(params.Y)
+
+	img, context := getTileImage(render)
+	defer putTileImage(render, img)
+	render.CanvasImage = img
+	render.Zoom = zoom
+	drawTile(r.Context(), context, render, scene, zoom, x, y)
+	if r.Context().Err() != nil {
+		tileRequestsCancelled.WithLabelValues("rendering").Inc()
+		return
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case codec.Png:
+		err = codec.EncodePng(&buf, img)
+	case codec.Webp:
+		err = codec.EncodeWebp(&buf, img, render.Webp.Quality, render.Webp.Lossless)
+	default:
+		err = codec.EncodeJpeg(&buf, img, render.Jpeg.Quality)
+	}
+	if err != nil {
+		log.Printf("unable to encode %s tile: %s\n", format, err.Error())
+		problem(w, r, http.StatusInternalServerError, "Unable to encode tile")
+		return
+	}
+
+	// Tiles with placeholders are drawn again on the next request, so that
+	// neither the client nor the tile cache keep them around
+	if render.Incomplete() {
+		w.Header().Del("ETag")
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
 		return
 	}
 
-	render := defaultSceneConfig.Render
+	modTime := scene.CreatedAt
+	if tileCache != nil {
+		if err := tileCache.Put(key, buf.Bytes()); err != nil {
+			log.Printf("unable to cache tile: %s\n", err.Error())
+		} else {
+			modTime = time.Now()
+		}
+	}
+
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(buf.Bytes()))
+}
+
+// getTileRender returns the render config for the tile based on the defaults
+// and the request params
+func getTileRender(params openapi.GetScenesSceneIdTilesParams, format codec.Format) render.Render {
+	render := getDefaultSceneConfig().Render
 	render.TileSize = params.TileSize
+	if format == codec.Png {
+		render.Background = color.Transparent
+	}
 	if params.DebugOverdraw != nil {
 		render.DebugOverdraw = *params.DebugOverdraw
 	}
 	if params.DebugThumbnails != nil {
 		render.DebugThumbnails = *params.DebugThumbnails
 	}
+	return render
+}
 
-	zoom := params.Zoom
-	x := int(params.X)
-	y := int(params.Y)
+// getTileKey returns a hash of everything that affects the contents of the
+// tile, used both as the ETag and as the tile cache key
+func getTileKey(scene *render.Scene, render *render.Render, format codec.Format, params openapi.GetScenesSceneIdTilesParams) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s %s %d %d %d %d %v %v %v",
+		scene.Hash,
+		format,
+		render.TileSize,
+		params.Zoom,
+		params.X,
+		params.Y,
+		render.MaxSolidPixelArea,
+		render.DebugOverdraw,
+		render.DebugThumbnails,
+	)
+	switch format {
+	case codec.Jpeg:
+		fmt.Fprintf(hash, " %d", render.Jpeg.Quality)
+	case codec.Webp:
+		fmt.Fprintf(hash, " %d %v", render.Webp.Quality, render.Webp.Lossless)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
 
-	img, context := getTileImage(&render)
-	defer putTileImage(&render, img)
-	render.CanvasImage = img
-	render.Zoom = zoom
-	drawTile(context, &render, scene, zoom, x, y)
+// checkNotModified returns true if the If-None-Match header of the request
+// contains the ETag of the key
+func checkNotModified(r *http.Request, key string) bool {
+	etag := fmt.Sprintf(`"%s"`, key)
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		if match == etag || match == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// getTileFormat returns the format requested by the format param, falling back
+// to the most preferred supported format in the Accept header and the
+// configured tile format if none of them are acceptable
+func getTileFormat(r *http.Request, params openapi.GetScenesSceneIdTilesParams) (codec.Format, error) {
+	if params.Format != nil {
+		format := codec.Format(*params.Format)
+		if !format.Supported() {
+			return format, fmt.Errorf("tile format %s is not supported", format)
+		}
+		return format, nil
+	}
+
+	defaultFormat := codec.Format(getDefaultSceneConfig().Render.TileFormat)
+	if !defaultFormat.Supported() {
+		defaultFormat = codec.Jpeg
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return defaultFormat, nil
+	}
+
+	// Formats are only picked over the default if they are mentioned
+	// explicitly, as wildcards are accepted by pretty much every client
+	best := defaultFormat
+	bestQuality := 0.
+	bestExact := false
+	for _, format := range []codec.Format{defaultFormat, codec.Webp, codec.Jpeg, codec.Png} {
+		if !format.Supported() {
+			continue
+		}
+		quality, exact := getAcceptQuality(accept, format.MimeType())
+		if format != defaultFormat && !exact {
+			continue
+		}
+		if quality > bestQuality || (quality == bestQuality && exact && !bestExact) {
+			best = format
+			bestQuality = quality
+			bestExact = exact
+		}
+	}
+	return best, nil
+}
 
-	codec.EncodeJpeg(w, img)
+// getAcceptQuality returns the quality value of the mime type in the Accept
+// header, and whether the type was matched exactly instead of by a wildcard
+func getAcceptQuality(accept string, mimeType string) (float64, bool) {
+	typ := strings.Split(mimeType, "/")[0]
+	quality := 0.
+	exact := false
+	specificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(fields[0]))
+		s := -1
+		switch mediaRange {
+		case mimeType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		q := 1.
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		specificity = s
+		quality = q
+		exact = s == 2
+	}
+	return quality, exact
 }
 
 func (*Api) GetScenesSceneIdRegions(w http.ResponseWriter, r *http.Request, sceneId openapi.SceneId, params openapi.GetScenesSceneIdRegionsParams) {
 
-	scene := sceneSource.GetSceneById(string(sceneId), imageSource)
+	scene := getReadableScene(r, string(sceneId))
 	if scene == nil {
 		problem(w, r, http.StatusBadRequest, "Scene not found")
 		return
@@ -626,7 +1751,8 @@ func (*Api) GetScenesSceneIdRegions(w http.ResponseWriter, r *http.Request, scen
 		H: float64(params.H),
 	}
 
-	regions := scene.GetRegions(&defaultSceneConfig.Render, bounds, params.Limit)
+	sceneConfig := getDefaultSceneConfig()
+	regions := scene.GetRegions(&sceneConfig.Render, bounds, params.Limit)
 
 	respond(w, r, http.StatusOK, struct {
 		Items []render.Region `json:"items"`
@@ -637,7 +1763,7 @@ func (*Api) GetScenesSceneIdRegions(w http.ResponseWriter, r *http.Request, scen
 
 func (*Api) GetScenesSceneIdRegionsId(w http.ResponseWriter, r *http.Request, sceneId openapi.SceneId, id openapi.RegionId) {
 
-	scene := sceneSource.GetSceneById(string(sceneId), imageSource)
+	scene := getReadableScene(r, string(sceneId))
 	if scene == nil {
 		problem(w, r, http.StatusBadRequest, "Scene not found")
 		return
@@ -654,7 +1780,7 @@ func (*Api) GetScenesSceneIdRegionsId(w http.ResponseWriter, r *http.Request, sc
 
 func (*Api) GetFilesId(w http.ResponseWriter, r *http.Request, id openapi.FileIdPathParam) {
 
-	path, err := imageSource.GetImagePath(image.ImageId(id))
+	path, err := getReadableImagePath(r, image.ImageId(id))
 	if err == image.ErrNotFound {
 		problem(w, r, http.StatusNotFound, "File not found")
 		return
@@ -665,7 +1791,7 @@ func (*Api) GetFilesId(w http.ResponseWriter, r *http.Request, id openapi.FileId
 
 func (*Api) GetFilesIdOriginalFilename(w http.ResponseWriter, r *http.Request, id openapi.FileIdPathParam, filename openapi.FilenamePathParam) {
 
-	path, err := imageSource.GetImagePath(image.ImageId(id))
+	path, err := getReadableImagePath(r, image.ImageId(id))
 	if err == image.ErrNotFound {
 		problem(w, r, http.StatusNotFound, "File not found")
 		return
@@ -676,15 +1802,16 @@ func (*Api) GetFilesIdOriginalFilename(w http.ResponseWriter, r *http.Request, i
 
 func (*Api) GetFilesIdImageVariantsSizeFilename(w http.ResponseWriter, r *http.Request, id openapi.FileIdPathParam, size openapi.SizePathParam, filename openapi.FilenamePathParam) {
 
-	imagePath, err := imageSource.GetImagePath(image.ImageId(id))
+	imagePath, err := getReadableImagePath(r, image.ImageId(id))
 	if err == image.ErrNotFound {
 		problem(w, r, http.StatusNotFound, "Image not found")
 		return
 	}
 
 	path := ""
-	for i := range imageSource.Images.Thumbnails {
-		thumbnail := imageSource.Images.Thumbnails[i]
+	thumbnails := imageSource.GetImages().Thumbnails
+	for i := range thumbnails {
+		thumbnail := thumbnails[i]
 		candidatePath := thumbnail.GetPath(imagePath)
 		if !imageSource.Exists(candidatePath) {
 			continue
@@ -709,15 +1836,16 @@ func (*Api) GetFilesIdVideoVariantsSizeFilename(w http.ResponseWriter, r *http.R
 	// 	size = "M"
 	// }
 
-	videoPath, err := imageSource.GetImagePath(image.ImageId(id))
+	videoPath, err := getReadableImagePath(r, image.ImageId(id))
 	if err == image.ErrNotFound {
 		problem(w, r, http.StatusNotFound, "Video not found")
 		return
 	}
 
 	path := ""
-	for i := range imageSource.Videos.Thumbnails {
-		thumbnail := imageSource.Videos.Thumbnails[i]
+	thumbnails := imageSource.GetVideos().Thumbnails
+	for i := range thumbnails {
+		thumbnail := thumbnails[i]
 		candidatePath := thumbnail.GetPath(videoPath)
 		if !imageSource.Exists(candidatePath) {
 			continue
@@ -728,6 +1856,13 @@ func (*Api) GetFilesIdVideoVariantsSizeFilename(w http.ResponseWriter, r *http.R
 		path = candidatePath
 	}
 
+	if path == "" && transcoder != nil {
+		if format, ok := transcode.ParseFormat(string(size)); ok {
+			serveTranscodedVideo(w, r, image.ImageId(id), videoPath, format, string(filename))
+			return
+		}
+	}
+
 	if path == "" || !imageSource.Exists(path) {
 		problem(w, r, http.StatusNotFound, "Resized video not found")
 		return
@@ -736,6 +1871,60 @@ func (*Api) GetFilesIdVideoVariantsSizeFilename(w http.ResponseWriter, r *http.R
 	http.ServeFile(w, r, path)
 }
 
+// serveTranscodedVideo serves the video transcoded to the format, which can
+// take a while on the first request. HLS playlists refer to their segments
+// relative to themselves, so for HLS the filename is the name of the playlist
+// or segment.
+func serveTranscodedVideo(w http.ResponseWriter, r *http.Request, id image.ImageId, videoPath string, format transcode.Format, filename string) {
+	if format == transcode.MP4 && isBrowserCompatibleVideo(id, videoPath) {
+		http.ServeFile(w, r, videoPath)
+		return
+	}
+
+	if format == transcode.HLS && (filename != filepath.Base(filename) || transcode.ContentType(filename) == "") {
+		problem(w, r, http.StatusNotFound, "HLS file not found")
+		return
+	}
+
+	path, err := transcoder.Transcode(r.Context(), videoPath, format, filename)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		if err == transcode.ErrBusy {
+			w.Header().Set("Retry-After", "10")
+			problem(w, r, http.StatusServiceUnavailable, "Too many videos waiting to be transcoded")
+			return
+		}
+		log.Printf("unable to transcode %s: %s\n", videoPath, err.Error())
+		problem(w, r, http.StatusInternalServerError, "Unable to transcode video")
+		return
+	}
+
+	if format == transcode.HLS {
+		if _, err := os.Stat(path); err != nil {
+			problem(w, r, http.StatusNotFound, "HLS file not found")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", transcode.ContentType(path))
+	http.ServeFile(w, r, path)
+}
+
+// isBrowserCompatibleVideo returns true if the video is an H.264 MP4 file
+// that can be served as it is instead of transcoding it. MP4 files with an
+// unknown codec, e.g. without ffprobe or before their info is loaded, are
+// served as they are too, as most of them are H.264.
+func isBrowserCompatibleVideo(id image.ImageId, path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".mp4" && ext != ".m4v" {
+		return false
+	}
+	info := imageSource.GetInfo(id)
+	return info.Video.Codec == "h264" || info.Video.Codec == ""
+}
+
 func AddPrefix(prefix string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -767,6 +1956,53 @@ type AppConfig struct {
 	Render       render.Render           `json:"render"`
 	Media        image.Config            `json:"media"`
 	TileRequests TileRequestConfig       `json:"tile_requests"`
+	TileCache    tile.CacheConfig        `json:"tile_cache"`
+	Transcoding  transcode.Config        `json:"transcoding"`
+	Tasks        task.Config             `json:"tasks"`
+	Auth         auth.Config             `json:"auth"`
+}
+
+// manageUsers adds, removes or lists the users as requested by the flags
+func manageUsers(add string, admin bool, remove string, list bool) error {
+	if add != "" {
+		password, exists := os.LookupEnv("PHOTOFIELD_PASSWORD")
+		if !exists {
+			fmt.Fprintf(os.Stderr, "Password for %s: ", add)
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return err
+			}
+			password = strings.TrimRight(line, "\r\n")
+		}
+		if err := authentication.SetUser(add, password, admin); err != nil {
+			return err
+		}
+		log.Printf("user %s saved\n", add)
+	}
+	if remove != "" {
+		removed, err := authentication.RemoveUser(remove)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return fmt.Errorf("user %s not found", remove)
+		}
+		log.Printf("user %s removed\n", remove)
+	}
+	if list {
+		users, err := authentication.ListUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.Admin {
+				fmt.Printf("%s (admin)\n", user.Name)
+			} else {
+				fmt.Println(user.Name)
+			}
+		}
+	}
+	return nil
 }
 
 func expandCollections(collections *[]collection.Collection) {
@@ -781,87 +2017,317 @@ func expandCollections(collections *[]collection.Collection) {
 	*collections = expanded
 }
 
-func indexCollections(collections *[]collection.Collection) (ok bool) {
-	go func() {
-		for _, collection := range *collections {
-			counter := make(chan int, 10)
-			go func(id string, counter chan int) {
-				task := getIndexTask(&collection)
-				for add := range counter {
-					task.Done += add
-					indexTasks.Store(id, task)
-				}
-				indexTasks.Delete(id)
-			}(collection.Id, counter)
-			for _, dir := range collection.Dirs {
-				imageSource.IndexImages(dir, collection.IndexLimit, counter)
-			}
-			close(counter)
-		}
-	}()
-	return true
+func indexCollection(collection *collection.Collection) {
+	t := newTask(openapi.TaskTypeINDEX, collection)
+	if _, added := taskManager.Add(t, getIndexRun(*collection)); !added {
+		log.Printf("collection %s is already being indexed\n", collection.Id)
+	}
 }
 
-func indexCollection(collection *collection.Collection) {
-	counter := make(chan int, 10)
-	go func() {
-		task := getIndexTask(collection)
-		for add := range counter {
-			task.Done += add
-			indexTasks.Store(collection.Id, task)
+// appendStoredCollections returns the collections followed by the ones added
+// through the API
+func appendStoredCollections(collections []collection.Collection) []collection.Collection {
+	stored, err := collection.LoadStored(imageSource)
+	if err != nil {
+		log.Printf("unable to load stored collections: %s\n", err.Error())
+		return collections
+	}
+	for _, c := range stored {
+		if getCollectionIndex(collections, c.Id) != -1 {
+			log.Printf("collection %s is also defined in the configuration file, ignoring stored one\n", c.Id)
+			continue
 		}
-		indexTasks.Delete(collection.Id)
-	}()
-	go func() {
-		log.Printf("indexing %s\n", collection.Id)
+		collections = append(collections, c)
+	}
+	return collections
+}
+
+// watchCollections starts watching the dirs of the collections, replacing
+// the previous watcher if there is one
+func watchCollections(collections []collection.Collection) {
+	watcherMutex.Lock()
+	defer watcherMutex.Unlock()
+
+	if watcher != nil {
+		watcher.Close()
+		watcher = nil
+	}
+
+	dirs := make([]image.WatchedDir, 0)
+	for _, collection := range collections {
+		filter := collection.GetPathFilter()
 		for _, dir := range collection.Dirs {
-			log.Printf("indexing %s %s\n", collection.Id, dir)
-			imageSource.IndexImages(dir, collection.IndexLimit, counter)
+			dirs = append(dirs, image.WatchedDir{
+				Dir:    dir,
+				Filter: filter,
+			})
 		}
-		close(counter)
-	}()
+	}
+	w, err := imageSource.NewWatcher(dirs, func(dirs []string) {
+		sceneSource.Invalidate(dirs)
+	})
+	if err != nil {
+		log.Printf("unable to watch collections: %s\n", err.Error())
+		return
+	}
+	watcher = w
 }
 
-func loadConfiguration(path string) AppConfig {
+func loadConfiguration(path string, dataDir string) AppConfig {
+
+	appConfig, err := readConfiguration(path)
+	if err != nil {
+		log.Printf("%s, using defaults\n", err.Error())
+		appConfig = defaults
+	}
+
+	if err := initConfiguration(&appConfig, dataDir); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return appConfig
+}
 
+// readConfiguration reads the configuration file with the defaults filled in
+func readConfiguration(path string) (AppConfig, error) {
 	var appConfig AppConfig
 
 	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Printf("unable to open %s, using defaults (%s)\n", path, err.Error())
-		appConfig = defaults
-	} else if err := yaml.Unmarshal(bytes, &appConfig); err != nil {
-		log.Printf("unable to parse %s, using defaults (%s)\n", path, err.Error())
-		appConfig = defaults
-	} else if err := mergo.Merge(&appConfig, defaults); err != nil {
+		return appConfig, fmt.Errorf("unable to open %s (%s)", path, err.Error())
+	}
+	if err := yaml.Unmarshal(bytes, &appConfig); err != nil {
+		return appConfig, fmt.Errorf("unable to parse %s (%s)", path, err.Error())
+	}
+	if err := mergo.Merge(&appConfig, defaults); err != nil {
 		panic("unable to merge configuration with defaults")
 	}
+	return appConfig, nil
+}
 
+// initConfiguration expands and prepares the collections, sets up the
+// thumbnails and resolves the paths relative to the data dir
+func initConfiguration(appConfig *AppConfig, dataDir string) error {
 	expandCollections(&appConfig.Collections)
 	for i := range appConfig.Collections {
 		collection := &appConfig.Collections[i]
-		collection.GenerateId()
-		collection.Layout = strings.ToUpper(collection.Layout)
-		if collection.Layout == "" {
-			collection.Layout = string(appConfig.Layout.Type)
+		prepareCollection(collection, appConfig.Layout.Type)
+		if _, err := collection.ParseFilter(); err != nil {
+			return fmt.Errorf("collection %s: %s", collection.Id, err.Error())
 		}
-		if collection.Limit > 0 && collection.IndexLimit == 0 {
-			collection.IndexLimit = collection.Limit
+		if filter := collection.GetPathFilter(); filter != nil {
+			if err := filter.Validate(); err != nil {
+				return fmt.Errorf("collection %s: %s", collection.Id, err.Error())
+			}
 		}
 	}
 
 	for i := range appConfig.Media.Images.Thumbnails {
-		appConfig.Media.Images.Thumbnails[i].Init()
+		thumbnail := &appConfig.Media.Images.Thumbnails[i]
+		if err := thumbnail.Init(); err != nil {
+			return fmt.Errorf("image thumbnail %s: %s", thumbnail.Name, err.Error())
+		}
 	}
 	for i := range appConfig.Media.Videos.Thumbnails {
-		appConfig.Media.Videos.Thumbnails[i].Init()
+		thumbnail := &appConfig.Media.Videos.Thumbnails[i]
+		if err := thumbnail.Init(); err != nil {
+			return fmt.Errorf("video thumbnail %s: %s", thumbnail.Name, err.Error())
+		}
 	}
 
-	return appConfig
+	appConfig.Media.DatabasePath = filepath.Join(dataDir, "photofield.cache.db")
+	thumbnailDir := appConfig.Media.Caches.Thumbnails.Dir
+	if thumbnailDir == "" {
+		thumbnailDir = "thumbnails"
+	}
+	if !filepath.IsAbs(thumbnailDir) {
+		thumbnailDir = filepath.Join(dataDir, thumbnailDir)
+	}
+	appConfig.Media.Caches.Thumbnails.Dir = thumbnailDir
+
+	tileCacheDir := appConfig.TileCache.Dir
+	if tileCacheDir != "" && !filepath.IsAbs(tileCacheDir) {
+		appConfig.TileCache.Dir = filepath.Join(dataDir, tileCacheDir)
+	}
+
+	transcodeDir := appConfig.Transcoding.Dir
+	if transcodeDir != "" && !filepath.IsAbs(transcodeDir) {
+		appConfig.Transcoding.Dir = filepath.Join(dataDir, transcodeDir)
+	}
+	return nil
+}
+
+// reloadConfiguration applies the changes of the configuration file that do
+// not require a restart. The current configuration is kept if the file is
+// invalid.
+func reloadConfiguration(path string, dataDir string) {
+	appConfig, err := readConfiguration(path)
+	if err == nil {
+		err = initConfiguration(&appConfig, dataDir)
+	}
+	if err != nil {
+		log.Printf("configuration not reloaded, %s\n", err.Error())
+		return
+	}
+
+	previous := loadedConfig
+	loadedConfig = appConfig
+	log.Printf("configuration reloaded from %s\n", path)
+
+	if !reflect.DeepEqual(previous.TileRequests, appConfig.TileRequests) {
+		log.Println("tile_requests changes require a restart")
+	}
+	if !reflect.DeepEqual(previous.TileCache, appConfig.TileCache) {
+		log.Println("tile_cache changes require a restart")
+	}
+	if !reflect.DeepEqual(previous.Tasks, appConfig.Tasks) {
+		log.Println("tasks changes require a restart")
+	}
+	if !reflect.DeepEqual(previous.Auth, appConfig.Auth) {
+		log.Println("auth changes require a restart")
+	}
+	if !reflect.DeepEqual(getRestartMediaConfig(previous.Media), getRestartMediaConfig(appConfig.Media)) {
+		log.Println("media changes other than extensions, date formats and thumbnails require a restart")
+	}
+
+	collectionsMutex.Lock()
+	updated := appendStoredCollections(appConfig.Collections)
+	current := collections
+	collections = updated
+	collectionsMutex.Unlock()
+
+	defaultSceneConfigMutex.Lock()
+	if len(updated) > 0 {
+		defaultSceneConfig.Collection = updated[0]
+	}
+	defaultSceneConfig.Layout = appConfig.Layout
+	defaultSceneConfig.Render = appConfig.Render
+	defaultSceneConfigMutex.Unlock()
+
+	thumbnailsChanged := imageSource.Reconfigure(appConfig.Media)
+	if thumbnailsChanged && tileCache != nil {
+		tileCache.Clear()
+	}
+
+	reindex := !reflect.DeepEqual(previous.Media.ListExtensions, appConfig.Media.ListExtensions)
+	for _, c := range current {
+		if getCollectionIndex(updated, c.Id) == -1 {
+			log.Printf("collection %s removed\n", c.Id)
+			sceneSource.RemoveCollection(c.Id, imageSource)
+		}
+	}
+	for i := range updated {
+		c := &updated[i]
+		index := getCollectionIndex(current, c.Id)
+		switch {
+		case index == -1:
+			log.Printf("collection %s added\n", c.Id)
+			indexCollection(c)
+		case !reflect.DeepEqual(current[index], *c):
+			log.Printf("collection %s updated\n", c.Id)
+			sceneSource.UpdateCollection(c.Id, *c)
+			if reindex || !reflect.DeepEqual(current[index].Dirs, c.Dirs) ||
+				current[index].IndexLimit != c.IndexLimit {
+				indexCollection(c)
+			}
+		case reindex:
+			indexCollection(c)
+		}
+	}
+
+	if imageSource.Watch && !reflect.DeepEqual(current, updated) {
+		watchCollections(updated)
+	}
+}
+
+// getRestartMediaConfig returns the media config without the parts that can
+// be reloaded
+func getRestartMediaConfig(config image.Config) image.Config {
+	config.ListExtensions = nil
+	config.DateFormats = nil
+	config.Images = image.FileConfig{}
+	config.Videos = image.FileConfig{}
+	return config
+}
+
+// watchConfiguration reloads the configuration when the file changes or when
+// the process receives SIGHUP
+func watchConfiguration(path string, dataDir string) {
+	reload := make(chan struct{}, 1)
+	trigger := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			log.Println("configuration reload requested")
+			trigger()
+		}
+	}()
+
+	// The dir is watched instead of the file, as editors often replace the
+	// file instead of writing to it
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("unable to watch configuration: %s\n", err.Error())
+	} else if err := fsw.Add(filepath.Dir(path)); err != nil {
+		log.Printf("unable to watch configuration: %s\n", err.Error())
+		fsw.Close()
+	} else {
+		go func() {
+			var timer *time.Timer
+			for {
+				select {
+				case event, ok := <-fsw.Events:
+					if !ok {
+						return
+					}
+					if filepath.Clean(event.Name) != filepath.Clean(path) ||
+						event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+						continue
+					}
+					// Wait for the writes to settle
+					if timer == nil {
+						timer = time.AfterFunc(1*time.Second, trigger)
+					} else {
+						timer.Reset(1 * time.Second)
+					}
+				case err, ok := <-fsw.Errors:
+					if !ok {
+						return
+					}
+					log.Printf("configuration watcher error: %s\n", err.Error())
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for range reload {
+			reloadConfiguration(path, dataDir)
+		}
+	}()
+}
+
+// prepareCollection sets the id and fills in the defaults of the collection
+func prepareCollection(collection *collection.Collection, layoutType layout.Type) {
+	collection.GenerateId()
+	collection.Layout = strings.ToUpper(collection.Layout)
+	if collection.Layout == "" {
+		collection.Layout = string(layoutType)
+	}
+	if collection.Limit > 0 && collection.IndexLimit == 0 {
+		collection.IndexLimit = collection.Limit
+	}
 }
 
 func addExampleScene() {
-	sceneConfig := defaultSceneConfig
+	sceneConfig := getDefaultSceneConfig()
 	sceneConfig.Scene.Id = "Tqcqtc6h69"
 	sceneConfig.Layout.SceneWidth = 800
 	sceneConfig.Layout.ImageHeight = 200
@@ -901,6 +2367,10 @@ func main() {
 
 	versionPtr := flag.Bool("version", false, "print version and exit")
 	vacuumPtr := flag.Bool("vacuum", false, "clean database for smaller size and better performance, and exit")
+	addUserPtr := flag.String("add-user", "", "add a user or change the password of an existing one, and exit. The password is read from PHOTOFIELD_PASSWORD or stdin")
+	adminPtr := flag.Bool("admin", false, "make the user added with -add-user an admin")
+	removeUserPtr := flag.String("remove-user", "", "remove a user, and exit")
+	listUsersPtr := flag.Bool("list-users", false, "list all users, and exit")
 
 	flag.Parse()
 
@@ -916,6 +2386,11 @@ func main() {
 	if err := yaml.Unmarshal(defaultsYaml, &defaults); err != nil {
 		panic(err)
 	}
+	if codec.HeifSupported {
+		heif := []string{".heic", ".heif"}
+		defaults.Media.ListExtensions = append(defaults.Media.ListExtensions, heif...)
+		defaults.Media.Images.Extensions = append(defaults.Media.Images.Extensions, heif...)
+	}
 
 	dataDir, exists := os.LookupEnv("PHOTOFIELD_DATA_DIR")
 	if !exists {
@@ -923,8 +2398,13 @@ func main() {
 	}
 	configurationPath := filepath.Join(dataDir, "configuration.yaml")
 
-	appConfig := loadConfiguration(configurationPath)
-	appConfig.Media.DatabasePath = filepath.Join(dataDir, "photofield.cache.db")
+	appConfig := loadConfiguration(configurationPath, dataDir)
+	loadedConfig = appConfig
+
+	imageExtensions := appConfig.Media.Images.Extensions
+	if !codec.HeifSupported && (containsString(imageExtensions, ".heic") || containsString(imageExtensions, ".heif")) {
+		log.Println("HEIC and HEIF files are configured as images, but they can only be decoded in builds with the libheif tag")
+	}
 
 	if len(appConfig.Collections) > 0 {
 		defaultSceneConfig.Collection = appConfig.Collections[0]
@@ -934,9 +2414,20 @@ func main() {
 	defaultSceneConfig.Render = appConfig.Render
 	tileRequestConfig = appConfig.TileRequests
 
+	var err error
+	tileCache, err = tile.NewCache(appConfig.TileCache)
+	if err != nil {
+		log.Printf("unable to use tile cache: %s\n", err.Error())
+	}
+
 	imageSource = image.NewSource(appConfig.Media, migrations)
 	defer imageSource.Close()
 
+	transcoder, err = transcode.NewTranscoder(appConfig.Transcoding, appConfig.Media.FFmpegPath)
+	if err != nil {
+		log.Printf("unable to use transcoding: %s\n", err.Error())
+	}
+
 	if *vacuumPtr {
 		err := imageSource.Vacuum()
 		if err != nil {
@@ -945,11 +2436,33 @@ func main() {
 		return
 	}
 
+	authentication = auth.New(appConfig.Auth, imageSource)
+
+	if *addUserPtr != "" || *removeUserPtr != "" || *listUsersPtr {
+		if err := manageUsers(*addUserPtr, *adminPtr, *removeUserPtr, *listUsersPtr); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if authentication.Enabled() {
+		log.Println("authentication enabled")
+	}
+
+	events = event.NewBroker()
+
+	taskManager = task.NewManager(appConfig.Tasks, imageSource)
+	taskManager.OnChange = func(t task.Task) {
+		events.Publish(event.Task, t)
+	}
+
 	sceneSource = scene.NewSceneSource()
+	sceneSource.OnChange = func(change scene.SceneChange) {
+		events.Publish(event.Scene, change)
+	}
 
 	fontFamily := canvas.NewFontFamily("Main")
 	// fontFamily.Use(canvas.CommonLigatures)
-	err := fontFamily.LoadFont(robotoRegular, canvas.FontRegular)
+	err = fontFamily.LoadFont(robotoRegular, canvas.FontRegular)
 	if err != nil {
 		panic(err)
 	}
@@ -961,6 +2474,14 @@ func main() {
 		Debug:  fontFamily.Face(34.0, canvas.Black, canvas.FontRegular, canvas.FontNormal),
 	}
 	sceneSource.DefaultScene = defaultSceneConfig.Scene
+	collections = appendStoredCollections(collections)
+	sceneSource.Restore(defaultSceneConfig, collections, imageSource)
+	taskManager.Restore(getTaskRun)
+
+	if appConfig.Media.Watch {
+		watchCollections(collections)
+	}
+	watchConfiguration(configurationPath, dataDir)
 
 	// addExampleScene()
 	// renderSample(defaultSceneConfig.Config, sceneSource.GetScene(defaultSceneConfig, imageSource))
@@ -991,21 +2512,32 @@ func main() {
 
 	r.Route(apiPrefix, func(r chi.Router) {
 
-		r.Use(cors.Handler(cors.Options{
-			AllowedOrigins: []string{"*"},
-			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-			MaxAge:         300, // Maximum value not ignored by any of major browsers
-		}))
+		origins := appConfig.Auth.AllowedOrigins
+		if len(origins) == 0 && !appConfig.Auth.Enabled {
+			origins = []string{"*"}
+		}
+		// Without any allowed origins, only same-origin requests are allowed
+		if len(origins) > 0 {
+			r.Use(cors.Handler(cors.Options{
+				AllowedOrigins: origins,
+				AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+				AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+				// Session cookies are only sent along to explicitly allowed origins
+				AllowCredentials: appConfig.Auth.Enabled && !containsString(origins, "*"),
+				MaxAge:           300, // Maximum value not ignored by any of major browsers
+			}))
+		}
+
+		r.Use(authMiddleware(apiPrefix))
 
 		var api Api
 		r.Mount("/", openapi.Handler(&api))
-		r.Mount("/metrics", promhttp.Handler())
+		r.Mount("/metrics", adminMiddleware(promhttp.Handler()))
 	})
 	msg := fmt.Sprintf("api at %v%v", addr, apiPrefix)
 
-	r.Mount("/debug", middleware.Profiler())
-	r.Handle("/debug/fgprof", fgprof.Handler())
+	r.Mount("/debug", adminMiddleware(middleware.Profiler()))
+	r.Handle("/debug/fgprof", adminMiddleware(fgprof.Handler()))
 
 	if apiPrefix != "/" {
 		subfs, err := fs.Sub(StaticFs, "ui/dist")
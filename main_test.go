@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http/httptest"
+	"photofield/internal/codec"
+	"photofield/internal/openapi"
+	"testing"
+)
+
+func TestGetAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept  string
+		quality float64
+		exact   bool
+	}{
+		{"", 0, false},
+		{"Image/PNG", 1, true},
+		{"image/jpeg", 0, false},
+		{"image/*", 1, false},
+		{"image/png; charset=x; q=0.5 ", 0.5, true},
+		{"image/png;q=0, */*", 0, true},
+		// More specific ranges take precedence regardless of the order
+		{"image/png;q=0.2, image/*;q=0.9, */*;q=0.5", 0.2, true},
+		{"*/*;q=0.1, image/*;q=0.7", 0.7, false},
+	}
+	for _, test := range tests {
+		quality, exact := getAcceptQuality(test.accept, "image/png")
+		if quality != test.quality || exact != test.exact {
+			t.Errorf("getAcceptQuality(%q) = %v, %v, want %v, %v", test.accept, quality, exact, test.quality, test.exact)
+		}
+	}
+}
+
+func TestGetTileFormat(t *testing.T) {
+	webp := codec.Jpeg
+	if codec.WebpSupported {
+		webp = codec.Webp
+	}
+	tests := []struct {
+		defaultFormat string
+		accept        string
+		want          codec.Format
+	}{
+		{"png", "", codec.Png},
+		{"gif", "", codec.Jpeg},
+		{"png", "image/*,*/*;q=0.8", codec.Png},
+		{"jpeg", "image/png,*/*", codec.Png},
+		{"jpeg", "image/png,image/jpeg", codec.Jpeg},
+		{"png", "image/png;q=0,image/jpeg;q=0.1", codec.Jpeg},
+		{"jpeg", "image/avif,image/webp,image/*,*/*;q=0.8", webp},
+	}
+	for _, test := range tests {
+		defaultSceneConfigMutex.Lock()
+		defaultSceneConfig.Render.TileFormat = test.defaultFormat
+		defaultSceneConfigMutex.Unlock()
+
+		r := httptest.NewRequest("GET", "/scenes/s/tiles", nil)
+		r.Header.Set("Accept", test.accept)
+		got, err := getTileFormat(r, openapi.GetScenesSceneIdTilesParams{})
+		if err != nil || got != test.want {
+			t.Errorf("%s default, accept %q: getTileFormat() = %v, %v, want %v", test.defaultFormat, test.accept, got, err, test.want)
+		}
+	}
+}
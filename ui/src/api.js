@@ -4,9 +4,19 @@ import qs from "qs";
 
 const host = import.meta.env.VITE_API_HOST || "/api";
 
+// Sends the user to sign in if authentication is enabled and they are not
+// signed in (anymore)
+function checkUnauthorized(response) {
+  if (response.status != 401) return;
+  if (window.location.pathname == "/login") return;
+  const redirect = window.location.pathname + window.location.search;
+  window.location.assign(`/login?${qs.stringify({ redirect })}`);
+}
+
 async function fetcher(endpoint) {
   const response = await fetch(host + endpoint);
   if (!response.ok) {
+    checkUnauthorized(response);
     console.error(response);
     throw new Error(response.statusText);
   }
@@ -16,6 +26,7 @@ async function fetcher(endpoint) {
 export async function get(endpoint, def) {
   const response = await fetch(host + endpoint);
   if (!response.ok) {
+    checkUnauthorized(response);
     if (def !== undefined) {
       return def;
     }
@@ -34,6 +45,7 @@ export async function post(endpoint, body, def) {
     }
   });
   if (!response.ok) {
+    checkUnauthorized(response);
     if (def !== undefined) {
       return def;
     }
@@ -61,6 +73,35 @@ export async function getCollection(id) {
   return get(`/collections/` + id);
 }
 
+export async function getUser() {
+  return get(`/auth/user`, null);
+}
+
+// Returns the signed in user or null if the name or password is invalid
+export async function login(name, password) {
+  const response = await fetch(host + `/auth/login`, {
+    method: "POST",
+    body: JSON.stringify({ name, password }),
+    headers: {
+      "Content-Type": "application/json; charset=utf-8",
+    }
+  });
+  if (response.status == 401) {
+    return null;
+  }
+  if (!response.ok) {
+    console.error(response);
+    throw new Error(response.statusText);
+  }
+  return await response.json();
+}
+
+export async function logout() {
+  await fetch(host + `/auth/logout`, {
+    method: "POST",
+  });
+}
+
 export async function createTask(type, id) {
   return await post(`/tasks`, {
     type,
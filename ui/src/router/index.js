@@ -2,6 +2,7 @@ import { createWebHistory, createRouter } from "vue-router";
 import App from "../App.vue";
 import Home from "../components/Home.vue";
 import NaturalViewer from "../components/NaturalViewer.vue";
+import Login from "../components/Login.vue";
 
 const routes = [
   {
@@ -28,7 +29,13 @@ const routes = [
         props: true,
       },
     ],
-  }
+  },
+  {
+    name: "login",
+    path: "/login",
+    component: Login,
+    props: route => ({ redirect: route.query.redirect }),
+  },
 ];
 
 const router = createRouter({